@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+)
+
+// The activity feed pushes every ExecutionReport and reject the client
+// receives to the browser as it arrives, including the ones no page asked
+// for, like fills on resting orders, expiries and admin cancels. Browsers
+// listen with Server-Sent Events on /activity/stream.
+
+const (
+	// events kept to show a browser that has just connected
+	ACTIVITY_HISTORY = 50
+
+	// events buffered for each browser, a browser that falls further
+	// behind misses them
+	ACTIVITY_BUFFER = 32
+)
+
+type activityEvent struct {
+	Time    string `json:"time"`
+	User    string `json:"user"`
+	Kind    string `json:"kind"`
+	Summary string `json:"summary"`
+	Message string `json:"message"`
+}
+
+type activityFeed struct {
+	mu        sync.Mutex
+	recent    []activityEvent
+	listeners map[chan activityEvent]struct{}
+}
+
+func newActivityFeed() *activityFeed {
+	return &activityFeed{listeners: make(map[chan activityEvent]struct{})}
+}
+
+// publish describes msg and sends it to every browser listening. Messages
+// that aren't execution reports or rejects are left out.
+func (af *activityFeed) publish(msg *quickfix.Message) {
+
+	event, ok := describeActivity(msg)
+	if !ok {
+		return
+	}
+
+	af.mu.Lock()
+	defer af.mu.Unlock()
+
+	af.recent = append(af.recent, event)
+	if len(af.recent) > ACTIVITY_HISTORY {
+		af.recent = af.recent[len(af.recent)-ACTIVITY_HISTORY:]
+	}
+
+	for listener := range af.listeners {
+		select {
+		case listener <- event:
+		default:
+		}
+	}
+}
+
+// listen returns the recent events and a channel for the ones that follow.
+func (af *activityFeed) listen() ([]activityEvent, chan activityEvent) {
+
+	af.mu.Lock()
+	defer af.mu.Unlock()
+
+	listener := make(chan activityEvent, ACTIVITY_BUFFER)
+	af.listeners[listener] = struct{}{}
+
+	return append([]activityEvent{}, af.recent...), listener
+}
+
+func (af *activityFeed) stopListening(listener chan activityEvent) {
+
+	af.mu.Lock()
+	defer af.mu.Unlock()
+
+	delete(af.listeners, listener)
+}
+
+// describeActivity sums up an ExecutionReport, OrderCancelReject, session
+// Reject or BusinessMessageReject in a line.
+func describeActivity(msg *quickfix.Message) (activityEvent, bool) {
+
+	event := activityEvent{
+		Time:    time.Now().UTC().Format(time.TimeOnly),
+		Message: prettyPrintStr(*msg),
+	}
+	event.User, _ = msg.Header.GetString(tag.TargetSubID)
+
+	text, _ := msg.Body.GetString(tag.Text)
+	clOrdID, _ := msg.Body.GetString(tag.ClOrdID)
+
+	msgType, _ := msg.Header.GetString(tag.MsgType)
+
+	switch enum.MsgType(msgType) {
+	case enum.MsgType_EXECUTION_REPORT:
+		event.Kind = "Execution Report"
+		event.Summary = describeExecution(msg, clOrdID)
+
+	case enum.MsgType_ORDER_CANCEL_REJECT:
+		_, reason := cancelRejectReason(*msg)
+		event.Kind = "Cancel Reject"
+		event.Summary = fmt.Sprintf("%s: %s", clOrdID, reason)
+
+	case enum.MsgType_REJECT:
+		refSeqNum, _ := msg.Body.GetInt(tag.RefSeqNum)
+		event.Kind = "Session Reject"
+		event.Summary = fmt.Sprintf("Message %d Rejected: %s", refSeqNum, text)
+
+	case enum.MsgType_BUSINESS_MESSAGE_REJECT:
+		refMsgType, _ := msg.Body.GetString(tag.RefMsgType)
+		event.Kind = "Business Reject"
+		event.Summary = fmt.Sprintf("%s Message Rejected: %s", refMsgType, text)
+
+	default:
+		return event, false
+	}
+
+	return event, true
+}
+
+func describeExecution(msg *quickfix.Message, clOrdID string) string {
+
+	symbol, _ := msg.Body.GetString(tag.Symbol)
+	execType, _ := msg.Body.GetString(tag.ExecType)
+	leaves, _ := msg.Body.GetString(tag.LeavesQty)
+
+	side := "SELL"
+	if s, _ := msg.Body.GetString(tag.Side); enum.Side(s) == enum.Side_BUY {
+		side = "BUY"
+	}
+
+	summary := fmt.Sprintf("%s %s %s: %s", clOrdID, side, symbol, execTypeName(enum.ExecType(execType)))
+
+	if enum.ExecType(execType) == enum.ExecType_TRADE {
+		lastQty, _ := msg.Body.GetString(tag.LastQty)
+		lastPx, _ := msg.Body.GetString(tag.LastPx)
+		summary += fmt.Sprintf(" %s @ %s", lastQty, lastPx)
+	}
+
+	summary += fmt.Sprintf(", %s Left", leaves)
+
+	if text, err := msg.Body.GetString(tag.Text); err == nil {
+		summary += " (" + text + ")"
+	}
+
+	return summary
+}
+
+func execTypeName(execType enum.ExecType) string {
+
+	switch execType {
+	case enum.ExecType_NEW:
+		return "New"
+	case enum.ExecType_TRADE:
+		return "Fill"
+	case enum.ExecType_CANCELED:
+		return "Cancelled"
+	case enum.ExecType_REPLACED:
+		return "Replaced"
+	case enum.ExecType_REJECTED:
+		return "Rejected"
+	case enum.ExecType_EXPIRED:
+		return "Expired"
+	case enum.ExecType_RESTATED:
+		return "Restated"
+	case enum.ExecType_TRIGGERED_OR_ACTIVATED_BY_SYSTEM:
+		return "Triggered"
+	case enum.ExecType_ORDER_STATUS:
+		return "Status"
+	}
+
+	return string(execType)
+}
+
+func (wf website_frontend) activity(w http.ResponseWriter, r *http.Request) {
+
+	wf.templates.ExecuteTemplate(w, "activity.html", struct{ SubID string }{r.FormValue("subID")})
+
+}
+
+// activityStream sends the activity feed as Server-Sent Events until the
+// browser goes away. With a subID only that user's events are sent, along
+// with session level rejects, which belong to no user.
+func (wf website_frontend) activityStream(w http.ResponseWriter, r *http.Request) {
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming Not Supported", http.StatusInternalServerError)
+		return
+	}
+
+	user := r.FormValue("subID")
+
+	recent, listener := wf.feed.listen()
+	defer wf.feed.stopListening(listener)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	send := func(event activityEvent) {
+		if user != "" && event.User != "" && event.User != user {
+			return
+		}
+		data, _ := json.Marshal(event)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+
+	for _, event := range recent {
+		send(event)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case event := <-listener:
+			send(event)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+	"github.com/shopspring/decimal"
+)
+
+// activityMessage is a message of msgType to user with fields set in its
+// body.
+func activityMessage(msgType enum.MsgType, user string, fields ...quickfix.FieldWriter) *quickfix.Message {
+	msg := quickfix.NewMessage()
+	msg.Header.Set(field.NewMsgType(msgType))
+	if user != "" {
+		msg.Header.Set(field.NewTargetSubID(user))
+	}
+	for _, f := range fields {
+		msg.Body.Set(f)
+	}
+	return msg
+}
+
+func TestDescribeActivity(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		msg     *quickfix.Message
+		kind    string
+		summary string
+		ok      bool
+	}{
+		{"new order", activityMessage(enum.MsgType_EXECUTION_REPORT, "alice",
+			field.NewClOrdID("1"), field.NewSide(enum.Side_BUY), field.NewSymbol("AAPL"),
+			field.NewExecType(enum.ExecType_NEW), field.NewLeavesQty(decimal.NewFromInt(100), 0)),
+			"Execution Report", "1 BUY AAPL: New, 100 Left", true},
+		{"fill", activityMessage(enum.MsgType_EXECUTION_REPORT, "alice",
+			field.NewClOrdID("1"), field.NewSide(enum.Side_SELL), field.NewSymbol("AAPL"),
+			field.NewExecType(enum.ExecType_TRADE), field.NewLastQty(decimal.NewFromInt(40), 0),
+			field.NewLastPx(decimal.RequireFromString("101.50"), 2), field.NewLeavesQty(decimal.NewFromInt(60), 0)),
+			"Execution Report", "1 SELL AAPL: Fill 40 @ 101.50, 60 Left", true},
+		{"admin cancel", activityMessage(enum.MsgType_EXECUTION_REPORT, "bob",
+			field.NewClOrdID("7"), field.NewSide(enum.Side_BUY), field.NewSymbol("MSFT"),
+			field.NewExecType(enum.ExecType_CANCELED), field.NewLeavesQty(decimal.Zero, 0), field.NewText("Kill Switch Engaged")),
+			"Execution Report", "7 BUY MSFT: Cancelled, 0 Left (Kill Switch Engaged)", true},
+		{"cancel reject", activityMessage(enum.MsgType_ORDER_CANCEL_REJECT, "alice",
+			field.NewClOrdID("2"), field.NewCxlRejReason(enum.CxlRejReason_UNKNOWN_ORDER)),
+			"Cancel Reject", "2: Unknown Order", true},
+		{"session reject", activityMessage(enum.MsgType_REJECT, "",
+			field.NewRefSeqNum(12), field.NewText("Required Tag Missing")),
+			"Session Reject", "Message 12 Rejected: Required Tag Missing", true},
+		{"business reject", activityMessage(enum.MsgType_BUSINESS_MESSAGE_REJECT, "alice",
+			field.NewRefMsgType("D"), field.NewText("Unknown Security")),
+			"Business Reject", "D Message Rejected: Unknown Security", true},
+		{"market data left out", activityMessage(enum.MsgType_MARKET_DATA_SNAPSHOT_FULL_REFRESH, "alice",
+			field.NewSymbol("AAPL")), "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, ok := describeActivity(tt.msg)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if event.Kind != tt.kind || event.Summary != tt.summary {
+				t.Errorf("described as %q, %q, want %q, %q", event.Kind, event.Summary, tt.kind, tt.summary)
+			}
+			if user, _ := tt.msg.Header.GetString(tag.TargetSubID); event.User != user {
+				t.Errorf("user = %q, want %q", event.User, user)
+			}
+		})
+	}
+}
+
+func TestExecTypeName(t *testing.T) {
+
+	tests := []struct {
+		execType enum.ExecType
+		want     string
+	}{
+		{enum.ExecType_NEW, "New"},
+		{enum.ExecType_TRADE, "Fill"},
+		{enum.ExecType_CANCELED, "Cancelled"},
+		{enum.ExecType_REPLACED, "Replaced"},
+		{enum.ExecType_REJECTED, "Rejected"},
+		{enum.ExecType_EXPIRED, "Expired"},
+		{enum.ExecType_RESTATED, "Restated"},
+		{enum.ExecType_TRIGGERED_OR_ACTIVATED_BY_SYSTEM, "Triggered"},
+		{enum.ExecType_ORDER_STATUS, "Status"},
+		{enum.ExecType_PENDING_CANCEL, string(enum.ExecType_PENDING_CANCEL)},
+	}
+
+	for _, tt := range tests {
+		if got := execTypeName(tt.execType); got != tt.want {
+			t.Errorf("execTypeName(%s) = %q, want %q", tt.execType, got, tt.want)
+		}
+	}
+}
+
+func TestActivityStreamFiltersBySubID(t *testing.T) {
+
+	feed := newActivityFeed()
+	feed.publish(activityMessage(enum.MsgType_EXECUTION_REPORT, "alice", field.NewClOrdID("a1"), field.NewExecType(enum.ExecType_NEW)))
+	feed.publish(activityMessage(enum.MsgType_EXECUTION_REPORT, "bob", field.NewClOrdID("b1"), field.NewExecType(enum.ExecType_NEW)))
+	feed.publish(activityMessage(enum.MsgType_REJECT, "", field.NewRefSeqNum(3)))
+
+	tests := []struct {
+		subID string
+		kinds []string
+		users []string
+	}{
+		{"", []string{"Execution Report", "Execution Report", "Session Reject"}, []string{"alice", "bob", ""}},
+		{"alice", []string{"Execution Report", "Session Reject"}, []string{"alice", ""}},
+		{"carol", []string{"Session Reject"}, []string{""}},
+	}
+
+	for _, tt := range tests {
+		t.Run("subID "+tt.subID, func(t *testing.T) {
+			wf := website_frontend{feed: feed}
+
+			// the browser has gone as soon as the recent events are sent
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			r := httptest.NewRequest("GET", "/activity/stream?subID="+tt.subID, nil).WithContext(ctx)
+			w := httptest.NewRecorder()
+			wf.activityStream(w, r)
+
+			if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+				t.Errorf("Content-Type = %q, want text/event-stream", ct)
+			}
+
+			var kinds, users []string
+			for _, line := range strings.Split(w.Body.String(), "\n") {
+				data, ok := strings.CutPrefix(line, "data: ")
+				if !ok {
+					continue
+				}
+
+				var event activityEvent
+				if err := json.Unmarshal([]byte(data), &event); err != nil {
+					t.Fatal(err)
+				}
+				kinds, users = append(kinds, event.Kind), append(users, event.User)
+			}
+
+			if !reflect.DeepEqual(kinds, tt.kinds) || !reflect.DeepEqual(users, tt.users) {
+				t.Errorf("streamed %v for %v, want %v for %v", kinds, users, tt.kinds, tt.users)
+			}
+		})
+	}
+}
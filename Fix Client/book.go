@@ -0,0 +1,489 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+	"github.com/shopspring/decimal"
+
+	fix50er "github.com/quickfixgo/fix50/executionreport"
+	fix50mdir "github.com/quickfixgo/fix50/marketdataincrementalrefresh"
+	fix50mdr "github.com/quickfixgo/fix50/marketdatarequest"
+	fix50mdrr "github.com/quickfixgo/fix50/marketdatarequestreject"
+	fix50mdsfr "github.com/quickfixgo/fix50/marketdatasnapshotfullrefresh"
+	fix50omsr "github.com/quickfixgo/fix50/ordermassstatusrequest"
+)
+
+// The book page shows the exchange's depth for one symbol. The client
+// subscribes to market data for a symbol the first time its page is opened
+// and keeps its own copy of the book from the snapshot and the incremental
+// refreshes that follow. Working orders are tracked from every
+// ExecutionReport the client sees, seeded with an OrderMassStatusRequest
+// the first time a user opens a book page. Open pages are streamed the
+// book again with Server-Sent Events whenever either changes.
+
+const (
+	BOOK_DEPTH    = 10
+	RECENT_TRADES = 20
+
+	// MDReqIDs and MassStatusReqIDs sent by the book page start with this
+	BOOK_REQ_PREFIX = "book-"
+)
+
+type bookLevel struct {
+	Price string `json:"price"`
+	Size  string `json:"size"`
+}
+
+type bookTrade struct {
+	Price string `json:"price"`
+	Size  string `json:"size"`
+	Time  string `json:"time"`
+}
+
+// clientBook is the client's copy of one symbol's book. Levels are keyed
+// by price as the exchange sent it.
+type clientBook struct {
+	bids     map[string]decimal.Decimal
+	asks     map[string]decimal.Decimal
+	trades   []bookTrade // newest first
+	rejected string
+}
+
+// bookChanges tells open book pages that what they show may have changed.
+// A page that hasn't caught up with the last change is only told once.
+//
+// A nil bookChanges tells no one.
+type bookChanges struct {
+	mu        sync.Mutex
+	listeners map[chan struct{}]struct{}
+}
+
+func newBookChanges() *bookChanges {
+	return &bookChanges{listeners: make(map[chan struct{}]struct{})}
+}
+
+func (bc *bookChanges) listen() chan struct{} {
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	listener := make(chan struct{}, 1)
+	bc.listeners[listener] = struct{}{}
+
+	return listener
+}
+
+func (bc *bookChanges) stopListening(listener chan struct{}) {
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	delete(bc.listeners, listener)
+}
+
+func (bc *bookChanges) notify() {
+
+	if bc == nil {
+		return
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	for listener := range bc.listeners {
+		select {
+		case listener <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// marketData is every book the client has subscribed to.
+type marketData struct {
+	mu      sync.Mutex
+	books   map[string]*clientBook
+	changes *bookChanges
+}
+
+func newMarketData(changes *bookChanges) *marketData {
+	return &marketData{books: make(map[string]*clientBook), changes: changes}
+}
+
+// subscribe sends a MarketDataRequest for symbol unless the client already
+// has one open. A request the exchange turned down is tried again. While
+// the session is down the book is only remembered, to be asked for on
+// logon.
+func (md *marketData) subscribe(symbol string, connected bool) {
+
+	md.mu.Lock()
+	book, ok := md.books[symbol]
+	if !ok {
+		md.books[symbol] = &clientBook{bids: make(map[string]decimal.Decimal), asks: make(map[string]decimal.Decimal)}
+	}
+	send := !ok || book.rejected != ""
+	md.mu.Unlock()
+
+	if send && connected {
+		sendMarketDataRequest(symbol)
+	}
+}
+
+// resubscribe asks for every book again, the exchange forgets
+// subscriptions when the session logs out.
+func (md *marketData) resubscribe() {
+
+	md.mu.Lock()
+	symbols := make([]string, 0, len(md.books))
+	for symbol := range md.books {
+		symbols = append(symbols, symbol)
+	}
+	md.mu.Unlock()
+
+	for _, symbol := range symbols {
+		sendMarketDataRequest(symbol)
+	}
+}
+
+func sendMarketDataRequest(symbol string) {
+
+	req := fix50mdr.New(
+		field.NewMDReqID(BOOK_REQ_PREFIX+symbol),
+		field.NewSubscriptionRequestType(enum.SubscriptionRequestType_SNAPSHOT_PLUS_UPDATES),
+		field.NewMarketDepth(BOOK_DEPTH))
+
+	req.SetMDUpdateType(enum.MDUpdateType_INCREMENTAL_REFRESH)
+
+	entryTypes := fix50mdr.NewNoMDEntryTypesRepeatingGroup()
+	entryTypes.Add().SetMDEntryType(enum.MDEntryType_BID)
+	entryTypes.Add().SetMDEntryType(enum.MDEntryType_OFFER)
+	entryTypes.Add().SetMDEntryType(enum.MDEntryType_TRADE)
+	req.SetNoMDEntryTypes(entryTypes)
+
+	symbols := fix50mdr.NewNoRelatedSymRepeatingGroup()
+	symbols.Add().SetSymbol(symbol)
+	req.SetNoRelatedSym(symbols)
+
+	req.Header.Set(field.NewSenderCompID("Client")) // make sure these are correct!
+	req.Header.Set(field.NewTargetCompID("Exchange"))
+
+	if err := quickfix.Send(req); err != nil {
+		fmt.Printf("Failed To Subscribe To %s: %s \n\r", symbol, err)
+	}
+}
+
+// onSnapshot replaces a book with the one the exchange sent.
+func (md *marketData) onSnapshot(msg *quickfix.Message) {
+
+	snapshot := fix50mdsfr.FromMessage(msg)
+
+	symbol, _ := snapshot.GetSymbol()
+	entries, err := snapshot.GetNoMDEntries()
+	if err != nil {
+		return
+	}
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	book, ok := md.books[symbol]
+	if !ok {
+		return
+	}
+	defer md.changes.notify()
+
+	book.bids = make(map[string]decimal.Decimal)
+	book.asks = make(map[string]decimal.Decimal)
+	book.rejected = ""
+
+	for i := 0; i < entries.Len(); i++ {
+		entry := entries.Get(i)
+		entryType, _ := entry.GetMDEntryType()
+		price, _ := entry.GetMDEntryPx()
+		size, _ := entry.GetMDEntrySize()
+
+		// the last trade is already in the list after a resubscribe
+		if entryType == enum.MDEntryType_TRADE && len(book.trades) > 0 {
+			continue
+		}
+
+		book.apply(enum.MDUpdateAction_NEW, entryType, price, size)
+	}
+}
+
+// onIncrement applies the changes the exchange sent to each book.
+func (md *marketData) onIncrement(msg *quickfix.Message) {
+
+	entries, err := fix50mdir.FromMessage(msg).GetNoMDEntries()
+	if err != nil {
+		return
+	}
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+	defer md.changes.notify()
+
+	for i := 0; i < entries.Len(); i++ {
+		entry := entries.Get(i)
+		symbol, _ := entry.GetSymbol()
+
+		book, ok := md.books[symbol]
+		if !ok {
+			continue
+		}
+
+		action, _ := entry.GetMDUpdateAction()
+		entryType, _ := entry.GetMDEntryType()
+		price, _ := entry.GetMDEntryPx()
+		size, _ := entry.GetMDEntrySize()
+
+		book.apply(action, entryType, price, size)
+	}
+}
+
+// onReject remembers why the exchange turned a subscription down, so the
+// page can say so.
+func (md *marketData) onReject(msg *quickfix.Message) {
+
+	reject := fix50mdrr.FromMessage(msg)
+
+	reqID, _ := reject.GetMDReqID()
+	text, err := reject.GetText()
+	if err != nil {
+		text = "Market Data Request Rejected"
+	}
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	if book, ok := md.books[strings.TrimPrefix(reqID, BOOK_REQ_PREFIX)]; ok {
+		book.rejected = text
+		md.changes.notify()
+	}
+}
+
+func (b *clientBook) apply(action enum.MDUpdateAction, entryType enum.MDEntryType, price, size decimal.Decimal) {
+
+	var levels map[string]decimal.Decimal
+
+	switch entryType {
+	case enum.MDEntryType_BID:
+		levels = b.bids
+	case enum.MDEntryType_OFFER:
+		levels = b.asks
+	case enum.MDEntryType_TRADE:
+		trade := bookTrade{Price: price.String(), Size: size.String(), Time: time.Now().UTC().Format(time.TimeOnly)}
+		b.trades = append([]bookTrade{trade}, b.trades...)
+		if len(b.trades) > RECENT_TRADES {
+			b.trades = b.trades[:RECENT_TRADES]
+		}
+		return
+	default:
+		return
+	}
+
+	if action == enum.MDUpdateAction_DELETE {
+		delete(levels, price.String())
+	} else {
+		levels[price.String()] = size
+	}
+}
+
+// view copies symbol's book for the page, best prices first.
+func (md *marketData) view(symbol string) (bids, asks []bookLevel, trades []bookTrade, rejected string) {
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	book, ok := md.books[symbol]
+	if !ok {
+		return
+	}
+
+	bids = sortedLevels(book.bids, true)
+	asks = sortedLevels(book.asks, false)
+	trades = append([]bookTrade{}, book.trades...)
+
+	return bids, asks, trades, book.rejected
+}
+
+func sortedLevels(levels map[string]decimal.Decimal, descending bool) []bookLevel {
+
+	prices := make([]decimal.Decimal, 0, len(levels))
+	for price := range levels {
+		prices = append(prices, decimal.RequireFromString(price))
+	}
+
+	sort.Slice(prices, func(i, j int) bool {
+		if descending {
+			return prices[i].GreaterThan(prices[j])
+		}
+		return prices[i].LessThan(prices[j])
+	})
+
+	sorted := make([]bookLevel, 0, len(prices))
+	for _, price := range prices {
+		sorted = append(sorted, bookLevel{Price: price.String(), Size: levels[price.String()].String()})
+	}
+
+	return sorted
+}
+
+type workingOrder struct {
+	ClOrdID string `json:"clOrdID"`
+	OrderID string `json:"orderID"`
+	Symbol  string `json:"symbol"`
+	Side    string `json:"side"`
+	Price   string `json:"price"`
+	Leaves  string `json:"leaves"`
+	Status  string `json:"status"`
+}
+
+// workingOrders are the open orders of each user, as far as the
+// ExecutionReports the client has seen tell.
+type workingOrders struct {
+	mu      sync.Mutex
+	orders  map[string]map[string]workingOrder // by user, then ClOrdID
+	seeded  map[string]bool
+	changes *bookChanges
+}
+
+func newWorkingOrders(changes *bookChanges) *workingOrders {
+	return &workingOrders{
+		orders:  make(map[string]map[string]workingOrder),
+		seeded:  make(map[string]bool),
+		changes: changes,
+	}
+}
+
+// seed asks the exchange for user's open orders, once.
+func (wo *workingOrders) seed(user string) {
+
+	wo.mu.Lock()
+	seeded := wo.seeded[user]
+	wo.seeded[user] = true
+	wo.mu.Unlock()
+
+	if seeded || user == "" {
+		return
+	}
+
+	req := fix50omsr.New(
+		field.NewMassStatusReqID(BOOK_REQ_PREFIX+user),
+		field.NewMassStatusReqType(enum.MassStatusReqType_STATUS_FOR_ALL_ORDERS))
+
+	req.Header.Set(field.NewSenderSubID(user))
+	req.Header.Set(field.NewSenderCompID("Client")) // make sure these are correct!
+	req.Header.Set(field.NewTargetCompID("Exchange"))
+
+	if err := quickfix.Send(req); err != nil {
+		fmt.Printf("Failed To Request Open Orders For %s: %s \n\r", user, err)
+
+		wo.mu.Lock()
+		delete(wo.seeded, user)
+		wo.mu.Unlock()
+	}
+}
+
+// onExecutionReport records the order an ExecutionReport is about, or
+// forgets it once it is no longer working.
+func (wo *workingOrders) onExecutionReport(msg *quickfix.Message) {
+
+	report := fix50er.FromMessage(msg)
+
+	user, _ := msg.Header.GetString(tag.TargetSubID)
+	clOrdID, _ := report.GetClOrdID()
+	orderID, _ := report.GetOrderID()
+	ordStatus, _ := report.GetOrdStatus()
+
+	// a status report saying there is nothing open names no order
+	if clOrdID == "" || clOrdID == "NONE" {
+		return
+	}
+
+	wo.mu.Lock()
+	defer wo.mu.Unlock()
+
+	orders, ok := wo.orders[user]
+	if !ok {
+		orders = make(map[string]workingOrder)
+		wo.orders[user] = orders
+	}
+
+	// an order sent with the ClOrdID of one still working is rejected, and
+	// the working order carries on
+	if working, ok := orders[clOrdID]; ok && ordStatus == enum.OrdStatus_REJECTED && working.OrderID != orderID {
+		return
+	}
+
+	defer wo.changes.notify()
+
+	// a replace carries on under its new ClOrdID
+	if origClOrdID, err := report.GetOrigClOrdID(); err == nil && ordStatus != enum.OrdStatus_REJECTED {
+		delete(orders, origClOrdID)
+	}
+
+	switch ordStatus {
+	case enum.OrdStatus_NEW, enum.OrdStatus_PARTIALLY_FILLED, enum.OrdStatus_PENDING_NEW, enum.OrdStatus_PENDING_REPLACE:
+	default:
+		delete(orders, clOrdID)
+		return
+	}
+
+	order := workingOrder{ClOrdID: clOrdID, OrderID: orderID, Status: ordStatusName(ordStatus)}
+	order.Symbol, _ = report.GetSymbol()
+	if side, _ := report.GetSide(); side == enum.Side_BUY {
+		order.Side = "BUY"
+	} else {
+		order.Side = "SELL"
+	}
+	if price, err := report.GetPrice(); err == nil {
+		order.Price = price.String()
+	}
+	if leaves, err := report.GetLeavesQty(); err == nil {
+		order.Leaves = leaves.String()
+	}
+
+	orders[clOrdID] = order
+}
+
+// view lists user's working orders in symbol, by ClOrdID.
+func (wo *workingOrders) view(user, symbol string) []workingOrder {
+
+	wo.mu.Lock()
+	defer wo.mu.Unlock()
+
+	open := []workingOrder{}
+	for _, order := range wo.orders[user] {
+		if order.Symbol == symbol {
+			open = append(open, order)
+		}
+	}
+
+	sort.Slice(open, func(i, j int) bool { return open[i].ClOrdID < open[j].ClOrdID })
+
+	return open
+}
+
+func ordStatusName(status enum.OrdStatus) string {
+
+	switch status {
+	case enum.OrdStatus_NEW:
+		return "New"
+	case enum.OrdStatus_PARTIALLY_FILLED:
+		return "Partially Filled"
+	case enum.OrdStatus_PENDING_NEW:
+		return "Pending New"
+	case enum.OrdStatus_PENDING_REPLACE:
+		return "Pending Replace"
+	}
+
+	return string(status)
+}
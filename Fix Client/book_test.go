@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/quickfix"
+	"github.com/shopspring/decimal"
+
+	fix50er "github.com/quickfixgo/fix50/executionreport"
+	fix50mdrr "github.com/quickfixgo/fix50/marketdatarequestreject"
+)
+
+// orderReport is an ExecutionReport to alice about order clOrdID, known to
+// the exchange as orderID, for 10 AAPL at 100 with leaves left.
+func orderReport(clOrdID, orderID string, execType enum.ExecType, ordStatus enum.OrdStatus, leaves int64) *quickfix.Message {
+	report := fix50er.New(field.NewOrderID(orderID), field.NewExecID("1"), field.NewExecType(execType),
+		field.NewOrdStatus(ordStatus), field.NewSide(enum.Side_BUY),
+		field.NewLeavesQty(decimal.NewFromInt(leaves), 0), field.NewCumQty(decimal.NewFromInt(10-leaves), 0))
+
+	report.Header.Set(field.NewTargetSubID("alice"))
+	report.SetClOrdID(clOrdID)
+	report.SetSymbol("AAPL")
+	report.SetPrice(decimal.NewFromInt(100), 0)
+
+	return report.ToMessage()
+}
+
+func TestWorkingOrders(t *testing.T) {
+
+	replaced := orderReport("1r", "5", enum.ExecType_REPLACED, enum.OrdStatus_NEW, 10)
+	replaced.Body.Set(field.NewOrigClOrdID("1"))
+
+	tests := []struct {
+		name    string
+		reports []*quickfix.Message
+		working map[string]string // ClOrdID to leaves
+	}{
+		{"new order", []*quickfix.Message{
+			orderReport("1", "5", enum.ExecType_NEW, enum.OrdStatus_NEW, 10),
+		}, map[string]string{"1": "10"}},
+		{"partly filled", []*quickfix.Message{
+			orderReport("1", "5", enum.ExecType_NEW, enum.OrdStatus_NEW, 10),
+			orderReport("1", "5", enum.ExecType_TRADE, enum.OrdStatus_PARTIALLY_FILLED, 4),
+		}, map[string]string{"1": "4"}},
+		{"filled", []*quickfix.Message{
+			orderReport("1", "5", enum.ExecType_NEW, enum.OrdStatus_NEW, 10),
+			orderReport("1", "5", enum.ExecType_TRADE, enum.OrdStatus_FILLED, 0),
+		}, map[string]string{}},
+		{"cancelled", []*quickfix.Message{
+			orderReport("1", "5", enum.ExecType_NEW, enum.OrdStatus_NEW, 10),
+			orderReport("1", "5", enum.ExecType_CANCELED, enum.OrdStatus_CANCELED, 0),
+		}, map[string]string{}},
+		{"replaced", []*quickfix.Message{
+			orderReport("1", "5", enum.ExecType_NEW, enum.OrdStatus_NEW, 10),
+			replaced,
+		}, map[string]string{"1r": "10"}},
+		{"duplicate ClOrdID rejected", []*quickfix.Message{
+			orderReport("1", "5", enum.ExecType_NEW, enum.OrdStatus_NEW, 10),
+			orderReport("1", "NONE", enum.ExecType_REJECTED, enum.OrdStatus_REJECTED, 0),
+		}, map[string]string{"1": "10"}},
+		{"rejected", []*quickfix.Message{
+			orderReport("2", "NONE", enum.ExecType_REJECTED, enum.OrdStatus_REJECTED, 0),
+		}, map[string]string{}},
+		{"nothing open", []*quickfix.Message{
+			orderReport("NONE", "NONE", enum.ExecType_ORDER_STATUS, enum.OrdStatus_REJECTED, 0),
+		}, map[string]string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wo := newWorkingOrders(nil)
+			for _, report := range tt.reports {
+				wo.onExecutionReport(report)
+			}
+
+			working := make(map[string]string)
+			for _, order := range wo.view("alice", "AAPL") {
+				working[order.ClOrdID] = order.Leaves
+			}
+
+			if !reflect.DeepEqual(working, tt.working) {
+				t.Errorf("working = %v, want %v", working, tt.working)
+			}
+		})
+	}
+}
+
+func TestClientBookApply(t *testing.T) {
+
+	md := newMarketData(nil)
+	md.books["AAPL"] = &clientBook{bids: make(map[string]decimal.Decimal), asks: make(map[string]decimal.Decimal)}
+	book := md.books["AAPL"]
+
+	px := decimal.RequireFromString
+	book.apply(enum.MDUpdateAction_NEW, enum.MDEntryType_BID, px("99"), px("10"))
+	book.apply(enum.MDUpdateAction_NEW, enum.MDEntryType_BID, px("99.5"), px("5"))
+	book.apply(enum.MDUpdateAction_NEW, enum.MDEntryType_OFFER, px("101"), px("7"))
+	book.apply(enum.MDUpdateAction_NEW, enum.MDEntryType_OFFER, px("100.5"), px("3"))
+	book.apply(enum.MDUpdateAction_CHANGE, enum.MDEntryType_BID, px("99"), px("12"))
+	book.apply(enum.MDUpdateAction_DELETE, enum.MDEntryType_OFFER, px("101"), decimal.Zero)
+	book.apply(enum.MDUpdateAction_NEW, enum.MDEntryType_TRADE, px("100"), px("2"))
+
+	bids, asks, trades, _ := md.view("AAPL")
+
+	if want := []bookLevel{{"99.5", "5"}, {"99", "12"}}; !reflect.DeepEqual(bids, want) {
+		t.Errorf("bids = %v, want %v", bids, want)
+	}
+	if want := []bookLevel{{"100.5", "3"}}; !reflect.DeepEqual(asks, want) {
+		t.Errorf("asks = %v, want %v", asks, want)
+	}
+	if len(trades) != 1 || trades[0].Price != "100" || trades[0].Size != "2" {
+		t.Errorf("trades = %v, want one of 2 @ 100", trades)
+	}
+}
+
+func TestBookChangesNotify(t *testing.T) {
+
+	changes := newBookChanges()
+	listener := changes.listen()
+
+	// a page that hasn't caught up is told once
+	changes.notify()
+	changes.notify()
+
+	select {
+	case <-listener:
+	default:
+		t.Fatal("not told about a change")
+	}
+	select {
+	case <-listener:
+		t.Fatal("told twice")
+	default:
+	}
+
+	changes.stopListening(listener)
+	changes.notify()
+	select {
+	case <-listener:
+		t.Fatal("told after it stopped listening")
+	default:
+	}
+
+	// nothing to tell
+	var none *bookChanges
+	none.notify()
+}
+
+func TestBookStream(t *testing.T) {
+
+	changes := newBookChanges()
+	wf := website_frontend{
+		session: &sessionStatus{},
+		market:  newMarketData(changes),
+		orders:  newWorkingOrders(changes),
+		changes: changes,
+	}
+	wf.session.loggedOn.Store(true)
+	wf.market.books["AAPL"] = &clientBook{bids: make(map[string]decimal.Decimal), asks: make(map[string]decimal.Decimal)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /book/{symbol}/stream", wf.bookStream)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/book/AAPL/stream?subID=alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	events := bufio.NewScanner(resp.Body)
+	next := func() bookView {
+		for events.Scan() {
+			if data, ok := strings.CutPrefix(events.Text(), "data: "); ok {
+				var view bookView
+				if err := json.Unmarshal([]byte(data), &view); err != nil {
+					t.Fatal(err)
+				}
+				return view
+			}
+		}
+		t.Fatalf("stream ended: %v", events.Err())
+		return bookView{}
+	}
+
+	if view := next(); view.Symbol != "AAPL" || len(view.Orders) != 0 {
+		t.Fatalf("first view = %+v, want AAPL with no orders", view)
+	}
+
+	// changes nothing this page shows, so nothing is sent for it
+	wf.orders.onExecutionReport(orderReport("2", "6", enum.ExecType_REJECTED, enum.OrdStatus_REJECTED, 0))
+	wf.orders.onExecutionReport(orderReport("1", "5", enum.ExecType_NEW, enum.OrdStatus_NEW, 10))
+
+	if view := next(); len(view.Orders) != 1 || view.Orders[0].ClOrdID != "1" {
+		t.Errorf("orders after the new order = %v, want 1", view.Orders)
+	}
+
+	wf.market.onReject(marketDataReject("AAPL", "Unknown Symbol"))
+
+	if view := next(); view.Rejected != "Unknown Symbol" {
+		t.Errorf("rejected = %q, want Unknown Symbol", view.Rejected)
+	}
+}
+
+// marketDataReject turns down the book page's subscription to symbol.
+func marketDataReject(symbol, text string) *quickfix.Message {
+	reject := fix50mdrr.New(field.NewMDReqID(BOOK_REQ_PREFIX + symbol))
+	reject.SetText(text)
+	return reject.ToMessage()
+}
@@ -5,24 +5,49 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/quickfixgo/enum"
 	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/quickfix/store/file"
+	"github.com/quickfixgo/tag"
 )
 
 type Client struct {
-	msg_chan chan quickfix.Message
+	responses *responseRouter
+	session   *sessionStatus
+	market    *marketData
+	orders    *workingOrders
+	feed      *activityFeed
+	changes   *bookChanges
 }
 
 func (e Client) OnCreate(sessionID quickfix.SessionID) {}
 
 // OnLogon implemented as part of Application interface
-func (e Client) OnLogon(sessionID quickfix.SessionID) {}
+func (e Client) OnLogon(sessionID quickfix.SessionID) {
+	if sessionID.BeginString == quickfix.BeginStringFIXT11 {
+		e.session.loggedOn.Store(true)
+		e.market.resubscribe()
+		e.changes.notify()
+	}
+}
 
 // OnLogout implemented as part of Application interface
-func (e Client) OnLogout(sessionID quickfix.SessionID) {}
+func (e Client) OnLogout(sessionID quickfix.SessionID) {
+	if sessionID.BeginString == quickfix.BeginStringFIXT11 {
+		e.session.loggedOn.Store(false)
+		e.changes.notify()
+	}
+}
 
 // FromAdmin implemented as part of Application interface
 func (e Client) FromAdmin(msg *quickfix.Message, sessionID quickfix.SessionID) (reject quickfix.MessageRejectError) {
+	if msg.IsMsgTypeOf(string(enum.MsgType_REJECT)) {
+		e.feed.publish(msg)
+		e.responses.deliver(*msg)
+	}
 	return nil
 }
 
@@ -38,10 +63,88 @@ func (e Client) ToApp(msg *quickfix.Message, sessionID quickfix.SessionID) (err
 // FromApp implemented as part of Application interface. This is the callback for all Application level messages from the counter party.
 func (e Client) FromApp(msg *quickfix.Message, sessionID quickfix.SessionID) (reject quickfix.MessageRejectError) {
 	fmt.Printf("FromApp %s \n\r", msg.String())
-	e.msg_chan <- *msg
+
+	e.feed.publish(msg)
+
+	// the exchange announces every change of trading phase and auction
+	// price, no page asks for these
+	switch msgType, _ := msg.Header.GetString(tag.MsgType); msgType {
+	case "h":
+		text, _ := msg.Body.GetString(tag.Text)
+		fmt.Printf("Trading Session Status: %s \n\r", text)
+		return
+	case "f":
+		symbol, _ := msg.Body.GetString(tag.Symbol)
+		text, _ := msg.Body.GetString(tag.Text)
+		fmt.Printf("Security Status For %s: %s \n\r", symbol, text)
+		return
+
+	// market data only feeds the book pages
+	case "W":
+		e.market.onSnapshot(msg)
+		return
+	case "X":
+		e.market.onIncrement(msg)
+		return
+	case "Y":
+		e.market.onReject(msg)
+		return
+
+	case "8":
+		e.orders.onExecutionReport(msg)
+
+		// the book page asked for these itself
+		if reqID, err := msg.Body.GetString(tag.MassStatusReqID); err == nil && strings.HasPrefix(reqID, BOOK_REQ_PREFIX) {
+			return
+		}
+	}
+
+	// the exchange can send several reports for one order (an ack and then
+	// fills), only the one a page is waiting for goes to it.
+	e.responses.deliver(*msg)
 	return
 }
 
+// newMessageStoreFactory picks the message store named by MessageStore in
+// the [DEFAULT] section. "file" (FileStorePath) keeps sequence numbers and
+// sent messages across restarts so ResendRequests can be answered,
+// "memory" forgets them.
+func newMessageStoreFactory(appSettings *quickfix.Settings) (quickfix.MessageStoreFactory, error) {
+
+	store := "memory"
+	if appSettings.GlobalSettings().HasSetting("MessageStore") {
+		store, _ = appSettings.GlobalSettings().Setting("MessageStore")
+	}
+
+	switch store {
+	case "memory":
+		return quickfix.NewMemoryStoreFactory(), nil
+	case "file":
+		return file.NewStoreFactory(appSettings), nil
+	}
+
+	return nil, fmt.Errorf("unknown MessageStore %q, expected memory or file", store)
+}
+
+// responseTimeout is how long pages wait for the exchange to answer,
+// ResponseTimeout (seconds) in the [DEFAULT] section.
+func responseTimeout(appSettings *quickfix.Settings) time.Duration {
+
+	timeout := 10
+	if appSettings.GlobalSettings().HasSetting("ResponseTimeout") {
+		seconds, err := appSettings.GlobalSettings().IntSetting("ResponseTimeout")
+		if err != nil {
+			log.Fatalf("Bad ResponseTimeout %s \n\r", err)
+		}
+		if seconds <= 0 {
+			log.Fatalf("ResponseTimeout Must Be Positive \n\r")
+		}
+		timeout = seconds
+	}
+
+	return time.Duration(timeout) * time.Second
+}
+
 //go:embed config/Client.cfg
 var configFS embed.FS
 
@@ -65,8 +168,8 @@ func main() {
 		log.Fatalf("Error Parsing App Settings %s \n\r", err)
 	}
 
-	msg_chan := make(chan quickfix.Message)
-	app := Client{msg_chan}
+	changes := newBookChanges()
+	app := Client{responses: newResponseRouter(responseTimeout(appSettings)), session: &sessionStatus{}, market: newMarketData(changes), orders: newWorkingOrders(changes), feed: newActivityFeed(), changes: changes}
 
 	fileLogFactory, err := quickfix.NewFileLogFactory(appSettings)
 
@@ -74,7 +177,11 @@ func main() {
 		log.Fatalf("Error Creating LogFactory %s \n\r", err)
 	}
 
-	messageStoreFactory := quickfix.NewMemoryStoreFactory()
+	messageStoreFactory, err := newMessageStoreFactory(appSettings)
+
+	if err != nil {
+		log.Fatalf("Error Creating Message Store %s \n\r", err)
+	}
 
 	initiator, err := quickfix.NewInitiator(
 		app,
@@ -92,7 +199,7 @@ func main() {
 		log.Fatalf("Error Starting Initiator %s \n\r", err)
 	}
 
-	wf := newWebsiteFrontend(msg_chan)
+	wf := newWebsiteFrontend(app)
 
 	if len(os.Args[1:]) >= 2 {
 		wf.start_web_tls(":443", os.Args[1], os.Args[2])
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+)
+
+// When the exchange can't be reached, doesn't answer or rejects a message
+// outright, pages show the error page instead of their usual answer and
+// the client carries on.
+
+// sessionStatus is whether the FIXT session the pages send on is logged on.
+type sessionStatus struct {
+	loggedOn atomic.Bool
+}
+
+func (ss *sessionStatus) connected() bool {
+	return ss.loggedOn.Load()
+}
+
+type errorDetail struct {
+	Name  string
+	Value string
+}
+
+// exchange sends msg and waits for the answer pending expects. Anything
+// other than an answer is shown on the error page, and exchange reports
+// false.
+func (wf website_frontend) exchange(w http.ResponseWriter, msg *quickfix.Message, pending *pendingResponse) (quickfix.Message, bool) {
+
+	if !wf.session.connected() {
+		wf.showError(w, http.StatusServiceUnavailable, "Not Connected To The Exchange",
+			"The FIX session is down, nothing was sent. Try again once the client has logged on.", nil, msg, nil)
+		return quickfix.Message{}, false
+	}
+
+	if err := quickfix.Send(msg); err != nil {
+		fmt.Printf("Failed To Send Message %s \n\r", err)
+		wf.showError(w, http.StatusBadGateway, "Failed To Send Message", err.Error(), nil, msg, nil)
+		return quickfix.Message{}, false
+	}
+	pending.sent(msg)
+
+	resp, ok := pending.wait()
+	if !ok {
+		wf.showError(w, http.StatusGatewayTimeout, "No Answer From The Exchange",
+			fmt.Sprintf("Nothing came back within %s, anything that does turns up in the inbox.", wf.responses.timeout), nil, msg, nil)
+		return quickfix.Message{}, false
+	}
+
+	if rejected, reason, details := rejectDetails(resp); rejected {
+		wf.showError(w, http.StatusOK, "Message Rejected By The Exchange", reason, details, msg, &resp)
+		return quickfix.Message{}, false
+	}
+
+	return resp, true
+}
+
+func (wf website_frontend) showError(w http.ResponseWriter, status int, title, reason string, details []errorDetail, msg *quickfix.Message, resp *quickfix.Message) {
+
+	page := struct {
+		Title    string
+		Reason   string
+		Details  []errorDetail
+		Message  string
+		Response string
+	}{Title: title, Reason: reason, Details: details, Message: prettyPrintStr(*msg)}
+
+	if resp != nil {
+		page.Response = prettyPrintStr(*resp)
+	}
+
+	w.WriteHeader(status)
+	wf.templates.ExecuteTemplate(w, "error.html", page)
+
+}
+
+// rejectDetails reports whether msg is a session level Reject or a
+// BusinessMessageReject and, if so, what it turned down and why.
+func rejectDetails(msg quickfix.Message) (bool, string, []errorDetail) {
+
+	var reason string
+	var details []errorDetail
+
+	add := func(name string, t quickfix.Tag) {
+		if value, err := msg.Body.GetString(t); err == nil {
+			details = append(details, errorDetail{name, value})
+		}
+	}
+
+	switch {
+	case msg.IsMsgTypeOf(string(enum.MsgType_REJECT)):
+		code, _ := msg.Body.GetString(tag.SessionRejectReason)
+		reason = "Session Level Reject: " + sessionRejectReason(enum.SessionRejectReason(code))
+		add("Reason Code", tag.SessionRejectReason)
+		add("Rejected Tag", tag.RefTagID)
+
+	case msg.IsMsgTypeOf(string(enum.MsgType_BUSINESS_MESSAGE_REJECT)):
+		code, _ := msg.Body.GetString(tag.BusinessRejectReason)
+		reason = "Business Message Reject: " + businessRejectReason(enum.BusinessRejectReason(code))
+		add("Reason Code", tag.BusinessRejectReason)
+		add("Rejected ID", tag.BusinessRejectRefID)
+
+	default:
+		return false, "", nil
+	}
+
+	add("Rejected Message Type", tag.RefMsgType)
+	add("Rejected Sequence Number", tag.RefSeqNum)
+	add("Text", tag.Text)
+
+	return true, reason, details
+}
+
+func sessionRejectReason(code enum.SessionRejectReason) string {
+
+	switch code {
+	case enum.SessionRejectReason_INVALID_TAG_NUMBER:
+		return "Invalid Tag Number"
+	case enum.SessionRejectReason_REQUIRED_TAG_MISSING:
+		return "Required Tag Missing"
+	case enum.SessionRejectReason_TAG_NOT_DEFINED_FOR_THIS_MESSAGE_TYPE:
+		return "Tag Not Defined For This Message Type"
+	case enum.SessionRejectReason_UNDEFINED_TAG:
+		return "Undefined Tag"
+	case enum.SessionRejectReason_TAG_SPECIFIED_WITHOUT_A_VALUE:
+		return "Tag Specified Without A Value"
+	case enum.SessionRejectReason_VALUE_IS_INCORRECT:
+		return "Value Is Incorrect"
+	case enum.SessionRejectReason_INCORRECT_DATA_FORMAT_FOR_VALUE:
+		return "Incorrect Data Format For Value"
+	case enum.SessionRejectReason_COMPID_PROBLEM:
+		return "CompID Problem"
+	case enum.SessionRejectReason_SENDINGTIME_ACCURACY_PROBLEM:
+		return "SendingTime Accuracy Problem"
+	case enum.SessionRejectReason_INVALID_MSGTYPE:
+		return "Invalid MsgType"
+	case enum.SessionRejectReason_TAG_APPEARS_MORE_THAN_ONCE:
+		return "Tag Appears More Than Once"
+	case enum.SessionRejectReason_TAG_SPECIFIED_OUT_OF_REQUIRED_ORDER:
+		return "Tag Specified Out Of Required Order"
+	case enum.SessionRejectReason_REPEATING_GROUP_FIELDS_OUT_OF_ORDER:
+		return "Repeating Group Fields Out Of Order"
+	case enum.SessionRejectReason_INCORRECT_NUMINGROUP_COUNT_FOR_REPEATING_GROUP:
+		return "Incorrect NumInGroup Count For Repeating Group"
+	}
+
+	return "Other"
+}
+
+func businessRejectReason(code enum.BusinessRejectReason) string {
+
+	switch code {
+	case enum.BusinessRejectReason_UNKNOWN_ID:
+		return "Unknown ID"
+	case enum.BusinessRejectReason_UNKNOWN_SECURITY:
+		return "Unknown Security"
+	case enum.BusinessRejectReason_UNSUPPORTED_MESSAGE_TYPE:
+		return "Unsupported Message Type"
+	case enum.BusinessRejectReason_APPLICATION_NOT_AVAILABLE:
+		return "Application Not Available"
+	case enum.BusinessRejectReason_CONDITIONALLY_REQUIRED_FIELD_MISSING:
+		return "Conditionally Required Field Missing"
+	case enum.BusinessRejectReason_NOT_AUTHORIZED:
+		return "Not Authorized"
+	}
+
+	return "Other"
+}
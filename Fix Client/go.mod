@@ -14,7 +14,7 @@ require (
 	github.com/quickfixgo/fix50 v0.1.0
 	github.com/quickfixgo/quickfix v0.9.6
 	github.com/quickfixgo/tag v0.1.0
-
+	github.com/shopspring/decimal v1.4.0
 )
 
 require (
@@ -26,7 +26,6 @@ require (
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/quickfixgo/fixt11 v0.1.0 // indirect
 	github.com/rivo/uniseg v0.2.0 // indirect
-	github.com/shopspring/decimal v1.4.0 // indirect
 	golang.org/x/net v0.24.0 // indirect
 	golang.org/x/sys v0.19.0 // indirect
 )
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+)
+
+// Pages wait for the answer to the request they sent, and the exchange's
+// messages are handed to them by what the answer will carry: the user in
+// TargetSubID along with the OrdStatusReqID, ClOrdID or, for answers that
+// carry neither, the MsgType. A session level Reject or a
+// BusinessMessageReject names the MsgSeqNum of the message it turned down
+// instead. Anything no page is waiting for, like later fills on an order,
+// goes to the inbox.
+
+const INBOX_SIZE = 100
+
+// responseKey names an answer a page is waiting for, the value of one
+// field in a message to user. Rejects don't say who they are for, and
+// every session numbers its messages from 1, so they are told apart by
+// session instead.
+type responseKey struct {
+	user    string
+	session string
+	tag     quickfix.Tag
+	value   string
+}
+
+func clOrdIDKey(user, clOrdID string) responseKey {
+	return responseKey{user: user, tag: tag.ClOrdID, value: clOrdID}
+}
+
+func ordStatusReqIDKey(user, reqID string) responseKey {
+	return responseKey{user: user, tag: tag.OrdStatusReqID, value: reqID}
+}
+
+func msgTypeKey(user, msgType string) responseKey {
+	return responseKey{user: user, tag: tag.MsgType, value: msgType}
+}
+
+func refSeqNumKey(session string, seqNum int) responseKey {
+	return responseKey{session: session, tag: tag.RefSeqNum, value: strconv.Itoa(seqNum)}
+}
+
+// sessionOf names the session msg went out or came in on by its
+// BeginString and CompIDs, ours first.
+func sessionOf(msg *quickfix.Message, received bool) string {
+	beginString, _ := msg.Header.GetString(tag.BeginString)
+	ours, _ := msg.Header.GetString(tag.SenderCompID)
+	theirs, _ := msg.Header.GetString(tag.TargetCompID)
+
+	if received {
+		ours, theirs = theirs, ours
+	}
+
+	return beginString + ":" + ours + "->" + theirs
+}
+
+// pendingResponse is a page waiting for an answer.
+type pendingResponse struct {
+	router *responseRouter
+	keys   []responseKey
+
+	// buffered, so an answer that arrives before the page gets round to
+	// waiting is kept for it rather than dropped
+	resp chan quickfix.Message
+	done bool
+}
+
+type inboxMessage struct {
+	Time    string
+	User    string
+	Message string
+}
+
+type responseRouter struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	waiting map[responseKey][]*pendingResponse
+	inbox   []inboxMessage // newest first
+
+	// rejects that arrived before the page sending the message knew its
+	// MsgSeqNum, by session and RefSeqNum
+	rejects map[responseKey]quickfix.Message
+}
+
+func newResponseRouter(timeout time.Duration) *responseRouter {
+	return &responseRouter{
+		timeout: timeout,
+		waiting: make(map[responseKey][]*pendingResponse),
+		rejects: make(map[responseKey]quickfix.Message),
+	}
+}
+
+// expect registers a page waiting for a message matching any of keys. It
+// has to be called before the request is sent, so the answer can't arrive
+// first. Pages waiting on the same key are answered in turn.
+func (rr *responseRouter) expect(keys ...responseKey) *pendingResponse {
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	pending := &pendingResponse{router: rr, keys: keys, resp: make(chan quickfix.Message, 1)}
+	for _, key := range keys {
+		rr.waiting[key] = append(rr.waiting[key], pending)
+	}
+
+	return pending
+}
+
+// sent also waits for a reject of msg, once quickfix has numbered it.
+func (p *pendingResponse) sent(msg *quickfix.Message) {
+
+	seqNum, err := msg.Header.GetInt(tag.MsgSeqNum)
+	if err != nil {
+		return
+	}
+
+	p.router.mu.Lock()
+	defer p.router.mu.Unlock()
+
+	if p.done {
+		return
+	}
+
+	key := refSeqNumKey(sessionOf(msg, false), seqNum)
+
+	if reject, ok := p.router.rejects[key]; ok {
+		delete(p.router.rejects, key)
+		p.router.answer(p, reject)
+		return
+	}
+
+	p.keys = append(p.keys, key)
+	p.router.waiting[key] = append(p.router.waiting[key], p)
+}
+
+// wait blocks until the answer arrives, or reports false once the
+// router's timeout has passed without one.
+func (p *pendingResponse) wait() (quickfix.Message, bool) {
+
+	timer := time.NewTimer(p.router.timeout)
+	defer timer.Stop()
+
+	select {
+	case resp := <-p.resp:
+		return resp, true
+	case <-timer.C:
+		return quickfix.Message{}, false
+	}
+}
+
+// cancel stops waiting, an answer arriving later goes to the inbox.
+func (p *pendingResponse) cancel() {
+
+	p.router.mu.Lock()
+	defer p.router.mu.Unlock()
+
+	p.router.forget(p)
+}
+
+// forget removes every key p is waiting on. The lock must be held.
+func (rr *responseRouter) forget(p *pendingResponse) {
+
+	for _, key := range p.keys {
+		queue := rr.waiting[key]
+		for i := range queue {
+			if queue[i] == p {
+				queue = append(queue[:i:i], queue[i+1:]...)
+				break
+			}
+		}
+
+		if len(queue) == 0 {
+			delete(rr.waiting, key)
+		} else {
+			rr.waiting[key] = queue
+		}
+	}
+}
+
+// answer hands p its response. The lock must be held.
+func (rr *responseRouter) answer(p *pendingResponse, msg quickfix.Message) {
+	rr.forget(p)
+	p.done = true
+	p.resp <- msg
+}
+
+// deliver hands msg to the page waiting for it, trying the most specific
+// key first, or puts it in the inbox.
+func (rr *responseRouter) deliver(msg quickfix.Message) {
+
+	user, _ := msg.Header.GetString(tag.TargetSubID)
+	msgType, _ := msg.Header.GetString(tag.MsgType)
+
+	var keys []responseKey
+	refSeqNum, refErr := msg.Body.GetInt(tag.RefSeqNum)
+	rejectKey := refSeqNumKey(sessionOf(&msg, true), refSeqNum)
+	isReject := msgType == string(enum.MsgType_REJECT) || msgType == string(enum.MsgType_BUSINESS_MESSAGE_REJECT)
+	if isReject && refErr == nil {
+		keys = append(keys, rejectKey)
+	}
+	if reqID, err := msg.Body.GetString(tag.OrdStatusReqID); err == nil {
+		keys = append(keys, ordStatusReqIDKey(user, reqID))
+	}
+	if clOrdID, err := msg.Body.GetString(tag.ClOrdID); err == nil {
+		keys = append(keys, clOrdIDKey(user, clOrdID))
+	}
+	// a BusinessMessageReject names the ClOrdID of what it turned down
+	if refID, err := msg.Body.GetString(tag.BusinessRejectRefID); err == nil {
+		keys = append(keys, clOrdIDKey(user, refID))
+	}
+	keys = append(keys, msgTypeKey(user, msgType))
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	for _, key := range keys {
+		if queue := rr.waiting[key]; len(queue) > 0 {
+			rr.answer(queue[0], msg)
+			return
+		}
+	}
+
+	if isReject && refErr == nil {
+		// nothing is kept for long, a page takes its reject straight away
+		if len(rr.rejects) >= INBOX_SIZE {
+			rr.rejects = make(map[responseKey]quickfix.Message)
+		}
+		rr.rejects[rejectKey] = msg
+	}
+
+	rr.inbox = append([]inboxMessage{{
+		Time:    time.Now().UTC().Format(time.TimeOnly),
+		User:    user,
+		Message: prettyPrintStr(msg),
+	}}, rr.inbox...)
+
+	if len(rr.inbox) > INBOX_SIZE {
+		rr.inbox = rr.inbox[:INBOX_SIZE]
+	}
+}
+
+// messages lists the inbox, only user's messages if user isn't empty.
+func (rr *responseRouter) messages(user string) []inboxMessage {
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	var messages []inboxMessage
+	for _, msg := range rr.inbox {
+		if user == "" || msg.User == user {
+			messages = append(messages, msg)
+		}
+	}
+
+	return messages
+}
+
+func (wf website_frontend) inbox(w http.ResponseWriter, r *http.Request) {
+
+	subId := r.FormValue("subID")
+
+	wf.templates.ExecuteTemplate(w, "inbox.html",
+		struct {
+			SubID    string
+			Messages []inboxMessage
+		}{subId, wf.responses.messages(subId)})
+
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/quickfix"
+)
+
+func executionReport(user, clOrdID string) quickfix.Message {
+	msg := quickfix.NewMessage()
+	msg.Header.Set(field.NewMsgType(enum.MsgType_EXECUTION_REPORT))
+	msg.Header.Set(field.NewTargetSubID(user))
+	msg.Body.Set(field.NewClOrdID(clOrdID))
+	return *msg
+}
+
+// answered reports whether pending has been handed an answer.
+func answered(pending *pendingResponse) bool {
+	select {
+	case <-pending.resp:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestResponseRouterDelivery(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		expect    responseKey
+		deliver   quickfix.Message
+		answered  bool
+		inboxSize int
+	}{
+		{"answered before waiting", clOrdIDKey("alice", "1"), executionReport("alice", "1"), true, 0},
+		{"other user's order", clOrdIDKey("alice", "1"), executionReport("bob", "1"), false, 1},
+		{"other order", clOrdIDKey("alice", "1"), executionReport("alice", "2"), false, 1},
+		{"by message type", msgTypeKey("alice", string(enum.MsgType_EXECUTION_REPORT)), executionReport("alice", "2"), true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newResponseRouter(50 * time.Millisecond)
+			pending := router.expect(tt.expect)
+
+			// the answer turns up before the page is waiting for it
+			router.deliver(tt.deliver)
+
+			if got := answered(pending); got != tt.answered {
+				t.Errorf("answered = %v, want %v", got, tt.answered)
+			}
+			if got := len(router.messages("")); got != tt.inboxSize {
+				t.Errorf("inbox has %d messages, want %d", got, tt.inboxSize)
+			}
+		})
+	}
+}
+
+func TestResponseRouterAnswersInTurn(t *testing.T) {
+
+	router := newResponseRouter(50 * time.Millisecond)
+	first := router.expect(clOrdIDKey("alice", "1"))
+	second := router.expect(clOrdIDKey("alice", "1"))
+
+	router.deliver(executionReport("alice", "1"))
+	if !answered(first) || answered(second) {
+		t.Fatal("first answer not handed to the first page")
+	}
+
+	router.deliver(executionReport("alice", "1"))
+	if !answered(second) {
+		t.Fatal("second answer not handed to the second page")
+	}
+}
+
+func TestResponseRouterCancelledGoesToInbox(t *testing.T) {
+
+	router := newResponseRouter(50 * time.Millisecond)
+	pending := router.expect(clOrdIDKey("alice", "1"))
+	pending.cancel()
+
+	router.deliver(executionReport("alice", "1"))
+
+	if answered(pending) {
+		t.Error("cancelled page was answered")
+	}
+	if messages := router.messages("alice"); len(messages) != 1 {
+		t.Fatalf("inbox has %d messages for alice, want 1", len(messages))
+	}
+	if messages := router.messages("bob"); len(messages) != 0 {
+		t.Fatalf("inbox has %d messages for bob, want none", len(messages))
+	}
+}
+
+func TestResponseRouterLateAnswerGoesToInbox(t *testing.T) {
+
+	router := newResponseRouter(10 * time.Millisecond)
+	pending := router.expect(clOrdIDKey("alice", "1"))
+
+	if _, answered := pending.wait(); answered {
+		t.Fatal("answered with nothing delivered")
+	}
+	pending.cancel()
+
+	router.deliver(executionReport("alice", "1"))
+
+	if messages := router.messages("alice"); len(messages) != 1 {
+		t.Fatalf("inbox has %d messages for alice, want 1", len(messages))
+	}
+}
+
+// sentOn is a message numbered seqNum, as quickfix leaves it once sent
+// from ours to the exchange.
+func sentOn(ours string, seqNum int) *quickfix.Message {
+	msg := quickfix.NewMessage()
+	msg.Header.Set(field.NewBeginString(quickfix.BeginStringFIXT11))
+	msg.Header.Set(field.NewSenderCompID(ours))
+	msg.Header.Set(field.NewTargetCompID("EXCHANGE"))
+	msg.Header.Set(field.NewMsgSeqNum(seqNum))
+	return msg
+}
+
+// rejectTo is a session level Reject of message seqNum sent by ours.
+func rejectTo(ours string, seqNum int) quickfix.Message {
+	msg := quickfix.NewMessage()
+	msg.Header.Set(field.NewBeginString(quickfix.BeginStringFIXT11))
+	msg.Header.Set(field.NewMsgType(enum.MsgType_REJECT))
+	msg.Header.Set(field.NewSenderCompID("EXCHANGE"))
+	msg.Header.Set(field.NewTargetCompID(ours))
+	msg.Body.Set(field.NewRefSeqNum(seqNum))
+	return *msg
+}
+
+func TestResponseRouterRejectsKeptApartBySession(t *testing.T) {
+
+	router := newResponseRouter(10 * time.Millisecond)
+
+	// the reject turns up before either page knows its MsgSeqNum
+	router.deliver(rejectTo("CLIENT_B", 5))
+
+	onA := router.expect(clOrdIDKey("alice", "1"))
+	onA.sent(sentOn("CLIENT_A", 5))
+	if _, answered := onA.wait(); answered {
+		t.Error("CLIENT_A was answered with CLIENT_B's reject")
+	}
+	onA.cancel()
+
+	onB := router.expect(clOrdIDKey("bob", "1"))
+	onB.sent(sentOn("CLIENT_B", 5))
+	if _, answered := onB.wait(); !answered {
+		t.Error("CLIENT_B's reject was not handed to its page")
+	}
+
+	// and once a page is waiting
+	waiting := router.expect(clOrdIDKey("alice", "2"))
+	waiting.sent(sentOn("CLIENT_A", 6))
+	router.deliver(rejectTo("CLIENT_B", 6))
+	if _, answered := waiting.wait(); answered {
+		t.Error("CLIENT_A was answered with CLIENT_B's reject after sending")
+	}
+}
@@ -1,20 +1,27 @@
 package main
 
 import (
+	"bytes"
 	"embed"
+	"encoding/json"
+	"fmt"
 	"html/template"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/quickfixgo/enum"
 	"github.com/quickfixgo/field"
 	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
 	"github.com/shopspring/decimal"
 
+	fix50ocrr "github.com/quickfixgo/fix50/ordercancelreplacerequest"
 	fix50cxl "github.com/quickfixgo/fix50/ordercancelrequest"
+	fix50omc "github.com/quickfixgo/fix50/ordermasscancelrequest"
 	fix50osr "github.com/quickfixgo/fix50/orderstatusrequest"
 )
 
@@ -38,6 +45,8 @@ type OrderDetails struct {
 	volume      decimal.Decimal
 	orderType   enum.OrdType
 	side        enum.Side
+	timeInForce enum.TimeInForce
+	expireTime  time.Time
 	oid         string
 	senderSubId string
 }
@@ -59,26 +68,108 @@ func formFixMessage(o OrderDetails) *quickfix.Message {
 	msg.Body.Set(field.NewSide(o.side))
 	msg.Body.Set(field.NewTransactTime(time.Now()))
 	msg.Body.Set(field.NewOrdType(o.orderType))
-	msg.Body.Set(field.NewOrderQty(o.volume, 0))
-	msg.Body.Set(field.NewPrice(o.price, 0))
+	msg.Body.Set(field.NewOrderQty(o.volume, scaleOf(o.volume)))
+
+	// market orders take whatever price the book gives them
+	if o.orderType != enum.OrdType_MARKET {
+		msg.Body.Set(field.NewPrice(o.price, scaleOf(o.price)))
+	}
+	msg.Body.Set(field.NewTimeInForce(o.timeInForce))
+
+	if o.timeInForce == enum.TimeInForce_GOOD_TILL_DATE {
+		msg.Body.Set(field.NewExpireTime(o.expireTime))
+	}
 
 	return msg
 
 }
 
+// scaleOf is how many decimal places d was typed in with, so prices and
+// quantities go out as entered rather than rounded to whole units.
+func scaleOf(d decimal.Decimal) int32 {
+	if d.Exponent() >= 0 {
+		return 0
+	}
+	return -d.Exponent()
+}
+
 func prettyPrintStr(msg quickfix.Message) string {
 
-	msg_s := strings.ReplaceAll(msg.String(), string(uint64(1)), "\n")
+	msg_s := strings.ReplaceAll(msg.String(), "\x01", "\n")
 
 	return msg_s
 
 }
 
+// cancelRejectReason reports whether msg is an OrderCancelReject and, if
+// so, why the exchange turned the cancel or amend down.
+func cancelRejectReason(msg quickfix.Message) (bool, string) {
+
+	if !msg.IsMsgTypeOf(string(enum.MsgType_ORDER_CANCEL_REJECT)) {
+		return false, ""
+	}
+
+	reason := "Unknown"
+	code, _ := msg.Body.GetString(tag.CxlRejReason)
+
+	switch enum.CxlRejReason(code) {
+	case enum.CxlRejReason_TOO_LATE_TO_CANCEL:
+		reason = "Too Late - The Order Is No Longer Open"
+	case enum.CxlRejReason_UNKNOWN_ORDER:
+		reason = "Unknown Order"
+	case enum.CxlRejReason_DUPLICATE_CLORDID:
+		reason = "Duplicate Order ID"
+	case enum.CxlRejReason_OTHER:
+		reason = "Other"
+	}
+
+	if text, err := msg.Body.GetString(tag.Text); err == nil {
+		reason += " (" + text + ")"
+	}
+
+	return true, reason
+
+}
+
+// massCancelRejectReason reports whether an OrderMassCancelReport turned
+// the request down and, if so, why.
+func massCancelRejectReason(msg quickfix.Message) (bool, string) {
+
+	response, _ := msg.Body.GetString(tag.MassCancelResponse)
+	if enum.MassCancelResponse(response) != enum.MassCancelResponse_CANCEL_REQUEST_REJECTED {
+		return false, ""
+	}
+
+	reason := "Unknown"
+	code, _ := msg.Body.GetString(tag.MassCancelRejectReason)
+
+	switch enum.MassCancelRejectReason(code) {
+	case enum.MassCancelRejectReason_MASS_CANCEL_NOT_SUPPORTED:
+		reason = "Mass Cancel Not Supported"
+	case enum.MassCancelRejectReason_INVALID_OR_UNKNOWN_SECURITY:
+		reason = "Invalid Or Unknown Ticker"
+	case enum.MassCancelRejectReason_OTHER:
+		reason = "Other"
+	}
+
+	if text, err := msg.Body.GetString(tag.Text); err == nil {
+		reason += " (" + text + ")"
+	}
+
+	return true, reason
+
+}
+
 type website_frontend struct {
 	//templates map[string]*template.Template
 	templates *template.Template
-	msgs      chan quickfix.Message
+	responses *responseRouter
+	session   *sessionStatus
 	users     map[string]string
+	market    *marketData
+	orders    *workingOrders
+	feed      *activityFeed
+	changes   *bookChanges
 }
 
 func (wf website_frontend) root(w http.ResponseWriter, r *http.Request) {
@@ -110,15 +201,143 @@ func (wf website_frontend) cancelOrder(w http.ResponseWriter, r *http.Request) {
 	cancel.Header.Set(field.NewSenderCompID("Client")) // make sure these are correct!
 	cancel.Header.Set(field.NewTargetCompID("Exchange"))
 
-	quickfix.Send(cancel)
-	resp := <-wf.msgs
+	pending := wf.responses.expect(clOrdIDKey(subId, clordID))
+	defer pending.cancel()
+
+	resp, ok := wf.exchange(w, cancel.Message, pending)
+	if !ok {
+		return
+	}
+
+	rejected, reason := cancelRejectReason(resp)
 
 	wf.templates.ExecuteTemplate(w, "cancelOrder.html",
 		struct {
 			Success  bool
+			Rejected bool
+			Reason   string
+			Message  string
+			Response string
+		}{true, rejected, reason, prettyPrintStr(*cancel.Message), prettyPrintStr(resp)})
+
+}
+
+func (wf website_frontend) cancelAllOrders(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/cancel", http.StatusSeeOther)
+		return
+	}
+
+	clordID := r.FormValue("cloid")
+	subId := r.FormValue("subID")
+	ticker := r.FormValue("Ticker")
+
+	requestType := enum.MassCancelRequestType_CANCEL_ALL_ORDERS
+	if ticker != "" {
+		requestType = enum.MassCancelRequestType_CANCEL_ORDERS_FOR_A_SECURITY
+	}
+
+	cancel := fix50omc.New(field.NewClOrdID(clordID), field.NewMassCancelRequestType(requestType), field.NewTransactTime(time.Now()))
+
+	if ticker != "" {
+		cancel.SetSymbol(ticker)
+	}
+
+	switch r.FormValue("Side") {
+	case "BUY":
+		cancel.SetSide(enum.Side_BUY)
+	case "SELL":
+		cancel.SetSide(enum.Side_SELL)
+	}
+
+	cancel.Header.Set(field.NewSenderSubID(subId))
+	cancel.Header.Set(field.NewSenderCompID("Client")) // make sure these are correct!
+	cancel.Header.Set(field.NewTargetCompID("Exchange"))
+
+	pending := wf.responses.expect(clOrdIDKey(subId, clordID))
+	defer pending.cancel()
+
+	resp, ok := wf.exchange(w, cancel.Message, pending)
+	if !ok {
+		return
+	}
+
+	rejected, reason := massCancelRejectReason(resp)
+	cancelled, _ := resp.Body.GetInt(tag.TotalAffectedOrders)
+
+	wf.templates.ExecuteTemplate(w, "cancelAll.html",
+		struct {
+			Rejected  bool
+			Reason    string
+			Cancelled int
+			Message   string
+			Response  string
+		}{rejected, reason, cancelled, prettyPrintStr(*cancel.Message), prettyPrintStr(resp)})
+
+}
+
+func (wf website_frontend) amendOrder(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		wf.templates.ExecuteTemplate(w, "amendOrder.html", nil)
+		return
+	}
+
+	originID := r.FormValue("originalOrderID")
+	clordID := r.FormValue("cloid")
+	subId := r.FormValue("subID")
+	ticker := r.FormValue("Ticker")
+	side := enum.Side_SELL
+
+	if r.FormValue("Side") == "BUY" {
+		side = enum.Side_BUY
+	}
+
+	volume, err := decimal.NewFromString(r.FormValue("Volume"))
+	if err != nil {
+		wf.templates.ExecuteTemplate(w, "amendOrder.html", nil)
+		return
+	}
+
+	price, err := decimal.NewFromString(r.FormValue("Price"))
+	if err != nil {
+		wf.templates.ExecuteTemplate(w, "amendOrder.html", nil)
+		return
+	}
+
+	amend := fix50ocrr.New(
+		field.NewOrigClOrdID(originID),
+		field.NewClOrdID(clordID),
+		field.NewSide(side),
+		field.NewTransactTime(time.Now()),
+		field.NewOrdType(enum.OrdType_LIMIT))
+
+	amend.SetSymbol(ticker)
+	amend.SetOrderQty(volume, scaleOf(volume))
+	amend.SetPrice(price, scaleOf(price))
+	amend.Header.Set(field.NewSenderSubID(subId))
+	amend.Header.Set(field.NewSenderCompID("Client")) // make sure these are correct!
+	amend.Header.Set(field.NewTargetCompID("Exchange"))
+
+	pending := wf.responses.expect(clOrdIDKey(subId, clordID))
+	defer pending.cancel()
+
+	resp, ok := wf.exchange(w, amend.Message, pending)
+	if !ok {
+		return
+	}
+
+	rejected, reason := cancelRejectReason(resp)
+
+	wf.templates.ExecuteTemplate(w, "amendOrder.html",
+		struct {
+			Success  bool
+			Rejected bool
+			Reason   string
 			Message  string
 			Response string
-		}{true, prettyPrintStr(*cancel.Message), prettyPrintStr(resp)})
+		}{true, rejected, reason, prettyPrintStr(*amend.Message), prettyPrintStr(resp)})
 
 }
 
@@ -135,37 +354,53 @@ func (wf website_frontend) placeOrder(w http.ResponseWriter, r *http.Request) {
 	orderDetails.ticker = r.FormValue("Ticker")
 
 	orderDetails.volume, err = decimal.NewFromString(r.FormValue("Volume"))
-	orderDetails.price, err = decimal.NewFromString(r.FormValue("Price"))
 	orderDetails.senderSubId = r.FormValue("subID")
 
 	orderDetails.oid = r.FormValue("oid")
 
-	if err != nil {
-		// make them re-enter the form
-		wf.templates.ExecuteTemplate(w, "PlaceOrder", nil)
-		return
-	}
-
 	if r.FormValue("OrderType") == "Market" {
 		orderDetails.orderType = enum.OrdType_MARKET
 	} else {
 		orderDetails.orderType = enum.OrdType_LIMIT
 	}
 
+	// only limit orders need a price
+	if err == nil && orderDetails.orderType == enum.OrdType_LIMIT {
+		orderDetails.price, err = decimal.NewFromString(r.FormValue("Price"))
+	}
+
+	if err != nil {
+		// make them re-enter the form
+		wf.templates.ExecuteTemplate(w, "placeOrder.html", nil)
+		return
+	}
+
 	if r.FormValue("Side") == "BUY" {
 		orderDetails.side = enum.Side_BUY
 	} else {
 		orderDetails.side = enum.Side_SELL
 	}
 
-	msg := formFixMessage(orderDetails)
+	orderDetails.timeInForce = enum.TimeInForce(r.FormValue("TimeInForce"))
 
-	err = quickfix.Send(msg)
-	if err != nil {
-		log.Fatalf("Failed To Send Message %s \n\r", err)
+	if orderDetails.timeInForce == enum.TimeInForce_GOOD_TILL_DATE {
+		// datetime-local inputs have no seconds or zone, times are entered in UTC
+		orderDetails.expireTime, err = time.Parse("2006-01-02T15:04", r.FormValue("ExpireTime"))
+		if err != nil {
+			wf.templates.ExecuteTemplate(w, "placeOrder.html", nil)
+			return
+		}
 	}
 
-	resp := <-wf.msgs
+	msg := formFixMessage(orderDetails)
+
+	pending := wf.responses.expect(clOrdIDKey(orderDetails.senderSubId, orderDetails.oid))
+	defer pending.cancel()
+
+	resp, ok := wf.exchange(w, msg, pending)
+	if !ok {
+		return
+	}
 
 	wf.templates.ExecuteTemplate(w, "placeOrder.html",
 		struct {
@@ -223,12 +458,13 @@ func (wf website_frontend) orderStatus(w http.ResponseWriter, r *http.Request) {
 	status.Header.Set(field.NewSenderCompID("Client")) // make sure these are correct!
 	status.Header.Set(field.NewTargetCompID("Exchange"))
 
-	err := quickfix.Send(status)
-	if err != nil {
-		log.Fatalf("Failed to Send Message %s \n\r", err)
-	}
+	pending := wf.responses.expect(ordStatusReqIDKey(subId, originalOrderID))
+	defer pending.cancel()
 
-	resp := <-wf.msgs
+	resp, ok := wf.exchange(w, status.Message, pending)
+	if !ok {
+		return
+	}
 
 	wf.templates.ExecuteTemplate(w, "orderStatus.html",
 		struct {
@@ -239,17 +475,138 @@ func (wf website_frontend) orderStatus(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func newWebsiteFrontend(msg_chan chan quickfix.Message) website_frontend {
+// findBook sends the symbol picked on the menu to its book page.
+func (wf website_frontend) findBook(w http.ResponseWriter, r *http.Request) {
+
+	symbol := strings.ToUpper(strings.TrimSpace(r.FormValue("Ticker")))
+	if symbol == "" {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	target := "/book/" + url.PathEscape(symbol)
+	if subId := r.FormValue("subID"); subId != "" {
+		target += "?subID=" + url.QueryEscape(subId)
+	}
+
+	http.Redirect(w, r, target, http.StatusSeeOther)
+
+}
+
+// book shows the depth, recent trades and the user's working orders for a
+// symbol, and subscribes to its market data the first time it is opened.
+func (wf website_frontend) book(w http.ResponseWriter, r *http.Request) {
+
+	symbol := r.PathValue("symbol")
+	subId := r.FormValue("subID")
+
+	// OnLogon subscribes to every book once the session is back
+	wf.market.subscribe(symbol, wf.session.connected())
+	if wf.session.connected() {
+		wf.orders.seed(subId)
+	}
+
+	wf.templates.ExecuteTemplate(w, "book.html",
+		struct {
+			Symbol string
+			SubID  string
+		}{symbol, subId})
+
+}
+
+type bookView struct {
+	Symbol   string         `json:"symbol"`
+	Bids     []bookLevel    `json:"bids"`
+	Asks     []bookLevel    `json:"asks"`
+	Trades   []bookTrade    `json:"trades"`
+	Orders   []workingOrder `json:"orders"`
+	Rejected string         `json:"rejected,omitempty"`
+}
+
+// bookView is everything the book page shows for symbol and user.
+func (wf website_frontend) bookView(symbol, user string) bookView {
+
+	view := bookView{Symbol: symbol}
+
+	view.Bids, view.Asks, view.Trades, view.Rejected = wf.market.view(symbol)
+	view.Orders = wf.orders.view(user, symbol)
+
+	if !wf.session.connected() && view.Rejected == "" {
+		view.Rejected = "Not Connected To The Exchange"
+	}
+
+	return view
+}
+
+// bookStream sends the book page its view as Server-Sent Events, again
+// every time it changes, until the browser goes away.
+func (wf website_frontend) bookStream(w http.ResponseWriter, r *http.Request) {
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming Not Supported", http.StatusInternalServerError)
+		return
+	}
+
+	symbol := r.PathValue("symbol")
+	user := r.FormValue("subID")
+
+	listener := wf.changes.listen()
+	defer wf.changes.stopListening(listener)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	// most changes are to other books, only send the ones to this one
+	var last []byte
+	send := func() {
+		data, _ := json.Marshal(wf.bookView(symbol, user))
+		if bytes.Equal(data, last) {
+			return
+		}
+		last = data
+
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+
+	send()
+
+	for {
+		select {
+		case <-listener:
+			send()
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+}
+
+func newWebsiteFrontend(app Client) website_frontend {
 	wf := website_frontend{}
-	wf.msgs = msg_chan
+	wf.responses = app.responses
+	wf.session = app.session
+	wf.market = app.market
+	wf.orders = app.orders
+	wf.feed = app.feed
+	wf.changes = app.changes
 
 	wf.templates = template.Must(template.ParseFS(templateFS, "templates/*.html"))
 
 	http.HandleFunc("/", wf.root)
 	http.HandleFunc("/place", wf.placeOrder)
 	http.HandleFunc("/cancel", wf.cancelOrder)
+	http.HandleFunc("/cancelAll", wf.cancelAllOrders)
+	http.HandleFunc("/amend", wf.amendOrder)
 	http.HandleFunc("/status", wf.orderStatus)
 	http.HandleFunc("/slides", wf.getSlides)
+	http.HandleFunc("/book", wf.findBook)
+	http.HandleFunc("GET /book/{symbol}", wf.book)
+	http.HandleFunc("GET /book/{symbol}/stream", wf.bookStream)
+	http.HandleFunc("GET /activity", wf.activity)
+	http.HandleFunc("GET /inbox", wf.inbox)
+	http.HandleFunc("GET /activity/stream", wf.activityStream)
 
 	return wf
 
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+)
+
+// The admin interface is plain HTTP returning JSON, meant to be reached
+// from the exchange's own host only:
+//
+//	GET  /killswitch        lists the engaged kill switches
+//	POST /killswitch        engages one, from compID, subID, by, reason and cancel
+//	POST /killswitch/reset  resets one, from compID, subID and by
+//	GET  /phase             shows the trading phase
+//	POST /phase/halt        halts trading, from by
+//	POST /phase/resume      goes back to the schedule, from by
+
+type killSwitchReply struct {
+	KillSwitch *killSwitch `json:"killSwitch,omitempty"`
+	Cancelled  int         `json:"cancelled,omitempty"`
+	Reset      bool        `json:"reset,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+type phaseReply struct {
+	Phase    string     `json:"phase,omitempty"`
+	Halted   bool       `json:"halted"`
+	HaltedBy string     `json:"haltedBy,omitempty"`
+	HaltedAt *time.Time `json:"haltedAt,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+func (e *Server) serveAdmin(addr string) {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/killswitch", e.adminKillSwitch)
+	mux.HandleFunc("/killswitch/reset", e.adminResetKillSwitch)
+	mux.HandleFunc("/phase", e.adminPhase)
+	mux.HandleFunc("/phase/halt", e.adminHalt)
+	mux.HandleFunc("/phase/resume", e.adminResume)
+
+	fmt.Printf("Admin Interface Listening On %s \n\r", addr)
+
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		fmt.Printf("Admin Interface Stopped: %s \n\r", err)
+	}
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (e *Server) adminKillSwitch(w http.ResponseWriter, r *http.Request) {
+
+	switch r.Method {
+	case http.MethodGet:
+		writeAdminJSON(w, http.StatusOK, e.kills.list())
+		return
+	case http.MethodPost:
+	default:
+		writeAdminJSON(w, http.StatusMethodNotAllowed, killSwitchReply{Error: "Use GET Or POST"})
+		return
+	}
+
+	ks := killSwitch{
+		CompID:      r.FormValue("compID"),
+		SubID:       r.FormValue("subID"),
+		Reason:      r.FormValue("reason"),
+		TriggeredBy: r.FormValue("by"),
+		TriggeredAt: time.Now().UTC(),
+	}
+
+	if ks.CompID == "" || ks.TriggeredBy == "" {
+		writeAdminJSON(w, http.StatusBadRequest, killSwitchReply{Error: "compID And by Are Required"})
+		return
+	}
+
+	ks, err := e.kills.engage(ks)
+	if err != nil {
+		// the switch is engaged even if it could not be saved
+		fmt.Printf("Failed To Save Kill Switches: %s \n\r", err)
+	}
+
+	reply := killSwitchReply{KillSwitch: &ks}
+
+	if cancel := r.FormValue("cancel"); cancel == "Y" || cancel == "true" {
+		reply.Cancelled = e.killSwitchCancel(ks)
+	}
+
+	writeAdminJSON(w, http.StatusOK, reply)
+}
+
+func (e *Server) adminResetKillSwitch(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		writeAdminJSON(w, http.StatusMethodNotAllowed, killSwitchReply{Error: "Use POST"})
+		return
+	}
+
+	compID, subID, by := r.FormValue("compID"), r.FormValue("subID"), r.FormValue("by")
+
+	if compID == "" || by == "" {
+		writeAdminJSON(w, http.StatusBadRequest, killSwitchReply{Error: "compID And by Are Required"})
+		return
+	}
+
+	reset, err := e.kills.reset(compID, subID, by)
+	if err != nil {
+		fmt.Printf("Failed To Save Kill Switches: %s \n\r", err)
+	}
+
+	if !reset {
+		writeAdminJSON(w, http.StatusNotFound, killSwitchReply{Error: "No Such Kill Switch"})
+		return
+	}
+
+	writeAdminJSON(w, http.StatusOK, killSwitchReply{Reset: true})
+}
+
+// killSwitchCancel cancels every resting order ks covers, telling each
+// order's session, and returns how many there were.
+func (e *Server) killSwitchCancel(ks killSwitch) int {
+
+	req := &massCancelRequest{orderScope: orderScope{compID: ks.CompID, user: ks.SubID}}
+	req.reply = make(chan marketResult, 1)
+	e.massCancelChannel <- req
+
+	res := <-req.reply
+
+	for i := range res.orders {
+		order := &res.orders[i]
+
+		execReport := e.execReport(order, enum.ExecType_CANCELED, order.sessionID)
+		execReport.Body.Set(field.NewText("Order has Been Cancelled By Kill Switch"))
+
+		e.send(execReport, order.sessionID)
+	}
+
+	fmt.Printf("Kill Switch For %s Cancelled %d Order(s) \n\r", ks, len(res.orders))
+
+	return len(res.orders)
+}
+
+func (e *Server) adminPhase(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		writeAdminJSON(w, http.StatusMethodNotAllowed, phaseReply{Error: "Use GET"})
+		return
+	}
+
+	writeAdminJSON(w, http.StatusOK, e.session.describe())
+}
+
+func (e *Server) adminHalt(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		writeAdminJSON(w, http.StatusMethodNotAllowed, phaseReply{Error: "Use POST"})
+		return
+	}
+
+	by := r.FormValue("by")
+	if by == "" {
+		writeAdminJSON(w, http.StatusBadRequest, phaseReply{Error: "by Is Required"})
+		return
+	}
+
+	e.session.halt(by)
+
+	writeAdminJSON(w, http.StatusOK, e.session.describe())
+}
+
+func (e *Server) adminResume(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		writeAdminJSON(w, http.StatusMethodNotAllowed, phaseReply{Error: "Use POST"})
+		return
+	}
+
+	by := r.FormValue("by")
+	if by == "" {
+		writeAdminJSON(w, http.StatusBadRequest, phaseReply{Error: "by Is Required"})
+		return
+	}
+
+	if !e.session.resume(by) {
+		writeAdminJSON(w, http.StatusConflict, phaseReply{Error: "Trading Is Not Halted"})
+		return
+	}
+
+	writeAdminJSON(w, http.StatusOK, e.session.describe())
+}
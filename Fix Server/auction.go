@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// During an auction the book only collects orders, and may end up crossed.
+// When the auction ends everything that crosses trades at a single price,
+// the equilibrium price, which is the one that trades the most volume.
+
+// auctionIndication is where the book would uncross if the auction ended
+// now. buyVolume and sellVolume are how much each side wants to trade at
+// price, of which volume can be matched. A zero volume means the book is
+// not crossed.
+type auctionIndication struct {
+	price      decimal.Decimal
+	volume     decimal.Decimal
+	buyVolume  decimal.Decimal
+	sellVolume decimal.Decimal
+}
+
+func (ai auctionIndication) equal(other auctionIndication) bool {
+	return ai.price.Equal(other.price) && ai.volume.Equal(other.volume) &&
+		ai.buyVolume.Equal(other.buyVolume) && ai.sellVolume.Equal(other.sellVolume)
+}
+
+// surplus is how much of the larger side would be left over.
+func (ai auctionIndication) surplus() decimal.Decimal {
+	return ai.buyVolume.Sub(ai.sellVolume).Abs()
+}
+
+// auctionReport tells the server about an auction in one symbol: while it
+// runs, where it would uncross, and once it has uncrossed, what traded and
+// the stops that triggered.
+type auctionReport struct {
+	symbol     string
+	indication auctionIndication
+	uncrossed  bool
+	trades     []Trade
+	released   []stopRelease
+}
+
+// equilibrium works out the price the book would uncross at. Of the prices
+// orders are resting at, it picks the one that trades the most volume, then
+// the one leaving the smallest surplus. If every such price leaves buyers
+// over it picks the highest, if every one leaves sellers over the lowest,
+// and otherwise the one closest to the last trade, or the lowest if nothing
+// has traded yet. Hidden iceberg quantity takes part in full.
+func (b *orderBook) equilibrium() auctionIndication {
+
+	var best []auctionIndication
+
+	for _, price := range b.auctionPrices() {
+		ai := auctionIndication{price: price, buyVolume: decimal.Zero, sellVolume: decimal.Zero}
+
+		for _, bid := range b.bids {
+			if bid.price.GreaterThanOrEqual(price) {
+				ai.buyVolume = ai.buyVolume.Add(bid.remaining())
+			}
+		}
+		for _, ask := range b.asks {
+			if ask.price.LessThanOrEqual(price) {
+				ai.sellVolume = ai.sellVolume.Add(ask.remaining())
+			}
+		}
+		ai.volume = decimal.Min(ai.buyVolume, ai.sellVolume)
+
+		if !ai.volume.IsPositive() {
+			continue
+		}
+
+		switch {
+		case len(best) == 0 || ai.volume.GreaterThan(best[0].volume):
+			best = []auctionIndication{ai}
+		case ai.volume.Equal(best[0].volume):
+			switch ai.surplus().Cmp(best[0].surplus()) {
+			case -1:
+				best = []auctionIndication{ai}
+			case 0:
+				best = append(best, ai)
+			}
+		}
+	}
+
+	if len(best) == 0 {
+		return auctionIndication{}
+	}
+
+	// prices are in ascending order, so are the candidates
+	buyPressure, sellPressure := true, true
+	for _, ai := range best {
+		buyPressure = buyPressure && ai.buyVolume.GreaterThan(ai.sellVolume)
+		sellPressure = sellPressure && ai.sellVolume.GreaterThan(ai.buyVolume)
+	}
+
+	switch {
+	case buyPressure:
+		return best[len(best)-1]
+	case sellPressure || b.lastPx.IsZero():
+		return best[0]
+	}
+
+	closest := best[0]
+	for _, ai := range best[1:] {
+		if ai.price.Sub(b.lastPx).Abs().LessThan(closest.price.Sub(b.lastPx).Abs()) {
+			closest = ai
+		}
+	}
+	return closest
+}
+
+// auctionPrices is every price an order is resting at, lowest first.
+func (b *orderBook) auctionPrices() []decimal.Decimal {
+	var prices []decimal.Decimal
+
+	// asks are already lowest first and bids highest first, so merging
+	// them from opposite ends keeps the prices in order
+	a, i := 0, len(b.bids)-1
+	for a < len(b.asks) || i >= 0 {
+		var price decimal.Decimal
+		if i < 0 || (a < len(b.asks) && b.asks[a].price.LessThan(b.bids[i].price)) {
+			price = b.asks[a].price
+			a++
+		} else {
+			price = b.bids[i].price
+			i--
+		}
+
+		if n := len(prices); n == 0 || !prices[n-1].Equal(price) {
+			prices = append(prices, price)
+		}
+	}
+
+	return prices
+}
+
+// uncross trades everything that crosses at the equilibrium price, best
+// priced and then oldest orders first. The older order of each pair is
+// reported as the passive side.
+func (b *orderBook) uncross() (auctionIndication, []Trade) {
+
+	ai := b.equilibrium()
+	if !ai.volume.IsPositive() {
+		return ai, nil
+	}
+
+	var trades []Trade
+	left := ai.volume
+
+	for left.IsPositive() {
+		bid, ask := b.bids[0], b.asks[0]
+
+		volume := decimal.Min(left, bid.remaining(), ask.remaining())
+		bid.fill(volume, ai.price)
+		ask.fill(volume, ai.price)
+		left = left.Sub(volume)
+
+		// an iceberg that traded shows a fresh slice of what it has left
+		bid.replenish()
+		ask.replenish()
+
+		aggressor, passive := bid, ask
+		if bid.seq < ask.seq {
+			aggressor, passive = ask, bid
+		}
+
+		trades = append(trades, Trade{
+			symbol:    b.symbol,
+			price:     ai.price,
+			volume:    volume,
+			aggressor: *aggressor,
+			passive:   *passive,
+		})
+		b.prints = append(b.prints, trades[len(trades)-1])
+
+		if bid.remaining().IsZero() {
+			b.bids = b.bids[1:]
+		}
+		if ask.remaining().IsZero() {
+			b.asks = b.asks[1:]
+		}
+	}
+
+	b.lastPx = ai.price
+
+	return ai, trades
+}
+
+// changePhase moves book into phase. Leaving an auction for anything but a
+// halt uncrosses the book, and the trades are reported to the server along
+// with any stops they trigger once continuous trading starts.
+func (m *market) changePhase(book *orderBook, phase TradingPhase) {
+
+	book.phase = phase
+	book.indicated = auctionIndication{}
+
+	if phase.isAuction() || phase == PHASE_HALTED {
+		return
+	}
+
+	ai, trades := book.uncross()
+	if len(trades) == 0 {
+		return
+	}
+
+	fmt.Printf("Auction In %s Uncrossed At %s, %s Traded In %d Trade(s) \n\r", book.symbol, ai.price, ai.volume, len(trades))
+
+	m.recordTrades(book, trades)
+
+	m.auctionChannel <- &auctionReport{
+		symbol:     book.symbol,
+		indication: ai,
+		uncrossed:  true,
+		trades:     trades,
+		released:   m.releaseStops(book),
+	}
+}
+
+// indicate tells the server where an auction would uncross, whenever that
+// changes.
+func (m *market) indicate(book *orderBook) {
+
+	if !book.phase.isAuction() {
+		return
+	}
+
+	ai := book.equilibrium()
+	if ai.equal(book.indicated) {
+		return
+	}
+	book.indicated = ai
+
+	m.auctionChannel <- &auctionReport{symbol: book.symbol, indication: ai}
+}
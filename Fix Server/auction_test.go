@@ -0,0 +1,151 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// auctionBook is a book in the opening auction holding orders, which rest
+// without trading.
+func auctionBook(lastPx int64, orders ...*SingleOrder) *orderBook {
+	book := newOrderBook("AAPL")
+	book.phase = PHASE_OPENING_AUCTION
+	book.lastPx = decimal.NewFromInt(lastPx)
+
+	for _, so := range orders {
+		book.add(so)
+	}
+
+	return book
+}
+
+func TestAuctionEquilibrium(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		lastPx int64
+		orders []*SingleOrder
+
+		// want price, volume, buyVolume and sellVolume
+		want [4]int64
+	}{
+		{
+			name:   "not crossed",
+			orders: []*SingleOrder{testOrder("b1", BUY, 99, 10), testOrder("s1", SELL, 100, 10)},
+			want:   [4]int64{0, 0, 0, 0},
+		},
+		{
+			name:   "most volume",
+			orders: []*SingleOrder{testOrder("b1", BUY, 101, 10), testOrder("s1", SELL, 99, 5), testOrder("s2", SELL, 101, 10)},
+			want:   [4]int64{101, 10, 10, 15},
+		},
+		{
+			name:   "volume tie goes to the smallest surplus",
+			orders: []*SingleOrder{testOrder("b1", BUY, 101, 10), testOrder("b2", BUY, 100, 5), testOrder("s1", SELL, 100, 10)},
+			want:   [4]int64{101, 10, 10, 10},
+		},
+		{
+			name:   "tie with buyers over takes the highest price",
+			orders: []*SingleOrder{testOrder("b1", BUY, 101, 20), testOrder("s1", SELL, 99, 5), testOrder("s2", SELL, 100, 5)},
+			want:   [4]int64{101, 10, 20, 10},
+		},
+		{
+			name:   "tie with sellers over takes the lowest price",
+			orders: []*SingleOrder{testOrder("b1", BUY, 101, 5), testOrder("b2", BUY, 100, 5), testOrder("s1", SELL, 99, 20)},
+			want:   [4]int64{99, 10, 10, 20},
+		},
+		{
+			name:   "balanced tie without a last trade takes the lowest price",
+			orders: []*SingleOrder{testOrder("b1", BUY, 101, 10), testOrder("s1", SELL, 99, 10)},
+			want:   [4]int64{99, 10, 10, 10},
+		},
+		{
+			name:   "balanced tie takes the price closest to the last trade",
+			lastPx: 102,
+			orders: []*SingleOrder{testOrder("b1", BUY, 101, 10), testOrder("s1", SELL, 99, 10)},
+			want:   [4]int64{101, 10, 10, 10},
+		},
+		{
+			name:   "hidden iceberg quantity counts",
+			orders: []*SingleOrder{icebergOrder("b1", BUY, 100, 20, 5), testOrder("s1", SELL, 100, 15)},
+			want:   [4]int64{100, 15, 20, 15},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ai := auctionBook(tt.lastPx, tt.orders...).equilibrium()
+
+			got := [4]int64{ai.price.IntPart(), ai.volume.IntPart(), ai.buyVolume.IntPart(), ai.sellVolume.IntPart()}
+			if got != tt.want {
+				t.Errorf("equilibrium = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuctionUncross(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		orders []*SingleOrder
+		price  int64
+		fills  []testFill
+		bids   []string
+		asks   []string
+	}{
+		{
+			name:   "not crossed",
+			orders: []*SingleOrder{testOrder("s1", SELL, 100, 10), testOrder("b1", BUY, 99, 10)},
+			bids:   []string{"b1"},
+			asks:   []string{"s1"},
+		},
+		{
+			name:   "buy imbalance is left resting",
+			orders: []*SingleOrder{testOrder("s1", SELL, 99, 5), testOrder("s2", SELL, 100, 5), testOrder("b1", BUY, 101, 20)},
+			price:  101,
+			fills:  []testFill{{"s1", 101, 5}, {"s2", 101, 5}},
+			bids:   []string{"b1"},
+		},
+		{
+			name:   "sell imbalance is left resting",
+			orders: []*SingleOrder{testOrder("s1", SELL, 99, 20), testOrder("b1", BUY, 101, 5), testOrder("b2", BUY, 100, 5)},
+			price:  99,
+			fills:  []testFill{{"s1", 99, 5}, {"s1", 99, 5}},
+			asks:   []string{"s1"},
+		},
+		{
+			name:   "orders outside the price don't trade",
+			orders: []*SingleOrder{testOrder("s1", SELL, 100, 10), testOrder("s2", SELL, 103, 10), testOrder("b1", BUY, 101, 10), testOrder("b2", BUY, 98, 10)},
+			price:  100,
+			fills:  []testFill{{"s1", 100, 10}},
+			bids:   []string{"b2"},
+			asks:   []string{"s2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := auctionBook(0, tt.orders...)
+			ai, trades := book.uncross()
+
+			if got := ai.price.IntPart(); got != tt.price {
+				t.Errorf("price = %d, want %d", got, tt.price)
+			}
+			if got := fillsOf(trades); !reflect.DeepEqual(got, tt.fills) {
+				t.Errorf("fills = %v, want %v", got, tt.fills)
+			}
+			if got := queueOf(book.bids); !reflect.DeepEqual(got, tt.bids) {
+				t.Errorf("bids = %v, want %v", got, tt.bids)
+			}
+			if got := queueOf(book.asks); !reflect.DeepEqual(got, tt.asks) {
+				t.Errorf("asks = %v, want %v", got, tt.asks)
+			}
+			if len(trades) > 0 && !book.lastPx.Equal(ai.price) {
+				t.Errorf("lastPx = %s, want %s", book.lastPx, ai.price)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/quickfix"
+
+	fix42ss "github.com/quickfixgo/fix42/securitystatus"
+	fix43ss "github.com/quickfixgo/fix43/securitystatus"
+	fix44ss "github.com/quickfixgo/fix44/securitystatus"
+	fix50ss "github.com/quickfixgo/fix50/securitystatus"
+)
+
+// While an auction runs, every session is sent an unsolicited
+// SecurityStatus as a price indication whenever the price or volume the
+// symbol would uncross at changes. LastPx is the indicative price, and
+// BuyVolume and SellVolume what each side wants to trade at it.
+// SecurityStatus only exists from FIX 4.2.
+
+// reportAuctions publishes each indication from the market, and once an
+// auction uncrosses reports its trades to the sessions that own them.
+func (e *Server) reportAuctions() {
+	for report := range e.auctionChannel {
+		if report.uncrossed {
+			e.sendTrades(report.trades)
+			e.sendReleases(report.released)
+			continue
+		}
+
+		ai := report.indication
+		if ai.volume.IsPositive() {
+			fmt.Printf("Indicative Price For %s Is %s, Volume %s \n\r", report.symbol, ai.price, ai.volume)
+		} else {
+			fmt.Printf("No Indicative Price For %s \n\r", report.symbol)
+		}
+
+		for _, sessionID := range e.loggedOnSessions() {
+			if hasTradingSessionStatus(sessionID) {
+				e.send(securityStatus(report.symbol, ai, e.instruments.priceScale(report.symbol), sessionID), sessionID)
+			}
+		}
+	}
+}
+
+// securityStatus builds a price indication for symbol, with prices given
+// scale decimal places, in the FIX version spoken by sessionID. A book that
+// isn't crossed has no indicative price.
+func securityStatus(symbol string, ai auctionIndication, scale int32, sessionID quickfix.SessionID) *quickfix.Message {
+
+	var msg *quickfix.Message
+
+	switch sessionID.BeginString {
+	case quickfix.BeginStringFIX42:
+		msg = fix42ss.New(field.NewSymbol(symbol)).ToMessage()
+	case quickfix.BeginStringFIX43:
+		msg = fix43ss.New().ToMessage()
+	case quickfix.BeginStringFIX44:
+		msg = fix44ss.New().ToMessage()
+	default:
+		msg = fix50ss.New().ToMessage()
+	}
+
+	msg.Body.Set(field.NewSymbol(symbol))
+	msg.Body.Set(field.NewTradingSessionID(enum.TradingSessionID_DAY))
+	msg.Body.Set(field.NewUnsolicitedIndicator(true))
+	msg.Body.Set(field.NewSecurityTradingStatus(enum.SecurityTradingStatus_PRICE_INDICATION))
+
+	if !ai.volume.IsPositive() {
+		msg.Body.Set(field.NewText("No Indicative Price"))
+		return msg
+	}
+
+	msg.Body.Set(field.NewLastPx(ai.price, scale))
+	msg.Body.Set(field.NewBuyVolume(ai.buyVolume, decimalPlaces(ai.buyVolume)))
+	msg.Body.Set(field.NewSellVolume(ai.sellVolume, decimalPlaces(ai.sellVolume)))
+	msg.Body.Set(field.NewText(fmt.Sprintf("Indicative Price %s, Volume %s", ai.price, ai.volume)))
+
+	return msg
+}
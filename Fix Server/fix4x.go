@@ -0,0 +1,84 @@
+package main
+
+import (
+	"github.com/quickfixgo/quickfix"
+
+	fix40nos "github.com/quickfixgo/fix40/newordersingle"
+	fix40cxl "github.com/quickfixgo/fix40/ordercancelrequest"
+	fix40osr "github.com/quickfixgo/fix40/orderstatusrequest"
+	fix41nos "github.com/quickfixgo/fix41/newordersingle"
+	fix41cxl "github.com/quickfixgo/fix41/ordercancelrequest"
+	fix41osr "github.com/quickfixgo/fix41/orderstatusrequest"
+	fix42nos "github.com/quickfixgo/fix42/newordersingle"
+	fix42cxl "github.com/quickfixgo/fix42/ordercancelrequest"
+	fix42osr "github.com/quickfixgo/fix42/orderstatusrequest"
+	fix43nos "github.com/quickfixgo/fix43/newordersingle"
+	fix43cxl "github.com/quickfixgo/fix43/ordercancelrequest"
+	fix43osr "github.com/quickfixgo/fix43/orderstatusrequest"
+	fix44nos "github.com/quickfixgo/fix44/newordersingle"
+	fix44cxl "github.com/quickfixgo/fix44/ordercancelrequest"
+	fix44osr "github.com/quickfixgo/fix44/orderstatusrequest"
+)
+
+// Order entry for the FIX.4.0 - FIX.4.4 sessions. Each message is read into
+// the same internal model as FIX 5.0 and answered in the session's version.
+
+func (e *Server) OnFIX40NewOrderSingle(msg fix40nos.NewOrderSingle, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onNewOrderSingle(msg, sessionID)
+}
+
+func (e *Server) onFIX40OrderCancelRequest(msg fix40cxl.OrderCancelRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onOrderCancelRequest(msg, sessionID)
+}
+
+func (e *Server) onFIX40OrderStatusRequest(msg fix40osr.OrderStatusRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onOrderStatusRequest(msg, sessionID)
+}
+
+func (e *Server) OnFIX41NewOrderSingle(msg fix41nos.NewOrderSingle, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onNewOrderSingle(msg, sessionID)
+}
+
+func (e *Server) onFIX41OrderCancelRequest(msg fix41cxl.OrderCancelRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onOrderCancelRequest(msg, sessionID)
+}
+
+func (e *Server) onFIX41OrderStatusRequest(msg fix41osr.OrderStatusRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onOrderStatusRequest(msg, sessionID)
+}
+
+func (e *Server) OnFIX42NewOrderSingle(msg fix42nos.NewOrderSingle, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onNewOrderSingle(msg, sessionID)
+}
+
+func (e *Server) onFIX42OrderCancelRequest(msg fix42cxl.OrderCancelRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onOrderCancelRequest(msg, sessionID)
+}
+
+func (e *Server) onFIX42OrderStatusRequest(msg fix42osr.OrderStatusRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onOrderStatusRequest(msg, sessionID)
+}
+
+func (e *Server) OnFIX43NewOrderSingle(msg fix43nos.NewOrderSingle, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onNewOrderSingle(msg, sessionID)
+}
+
+func (e *Server) onFIX43OrderCancelRequest(msg fix43cxl.OrderCancelRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onOrderCancelRequest(msg, sessionID)
+}
+
+func (e *Server) onFIX43OrderStatusRequest(msg fix43osr.OrderStatusRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onOrderStatusRequest(msg, sessionID)
+}
+
+func (e *Server) OnFIX44NewOrderSingle(msg fix44nos.NewOrderSingle, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onNewOrderSingle(msg, sessionID)
+}
+
+func (e *Server) onFIX44OrderCancelRequest(msg fix44cxl.OrderCancelRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onOrderCancelRequest(msg, sessionID)
+}
+
+func (e *Server) onFIX44OrderStatusRequest(msg fix44osr.OrderStatusRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onOrderStatusRequest(msg, sessionID)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/quickfix"
+	"github.com/shopspring/decimal"
+)
+
+// InstrumentStatus is whether an instrument may be traded.
+type InstrumentStatus string
+
+const (
+	INSTRUMENT_ACTIVE InstrumentStatus = "Active"
+	INSTRUMENT_HALTED InstrumentStatus = "Halted"
+)
+
+// instrument is the reference data for one tradable symbol. Prices must be
+// a multiple of TickSize and quantities a multiple of LotSize.
+type instrument struct {
+	Symbol   string           `json:"symbol"`
+	TickSize decimal.Decimal  `json:"tickSize"`
+	LotSize  decimal.Decimal  `json:"lotSize"`
+	Currency string           `json:"currency"`
+	Status   InstrumentStatus `json:"status"`
+}
+
+// instrumentMaster is every symbol the exchange lists. It is read once at
+// startup and never changes, so it needs no locking.
+//
+// A nil instrumentMaster lists every symbol, with no tick or lot size.
+type instrumentMaster struct {
+	bySymbol map[string]instrument
+	symbols  []string
+}
+
+// loadInstruments reads a JSON array of instruments from path.
+func loadInstruments(path string) (*instrumentMaster, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var listed []instrument
+	if err := json.Unmarshal(data, &listed); err != nil {
+		return nil, fmt.Errorf("corrupt instruments: %w", err)
+	}
+
+	im := &instrumentMaster{bySymbol: make(map[string]instrument)}
+
+	for _, inst := range listed {
+		if inst.Symbol == "" {
+			return nil, fmt.Errorf("instrument without a symbol")
+		}
+		if _, exists := im.bySymbol[inst.Symbol]; exists {
+			return nil, fmt.Errorf("instrument %s listed twice", inst.Symbol)
+		}
+		if inst.TickSize.IsNegative() || inst.LotSize.IsNegative() {
+			return nil, fmt.Errorf("instrument %s has a negative tick or lot size", inst.Symbol)
+		}
+
+		switch inst.Status {
+		case "":
+			inst.Status = INSTRUMENT_ACTIVE
+		case INSTRUMENT_ACTIVE, INSTRUMENT_HALTED:
+		default:
+			return nil, fmt.Errorf("instrument %s has unknown status %s", inst.Symbol, inst.Status)
+		}
+
+		im.bySymbol[inst.Symbol] = inst
+		im.symbols = append(im.symbols, inst.Symbol)
+	}
+
+	sort.Strings(im.symbols)
+
+	fmt.Printf("Loaded %d Instrument(s) From %s \n\r", len(im.symbols), path)
+
+	return im, nil
+}
+
+// lookup returns the instrument for symbol, and false if it isn't listed.
+func (im *instrumentMaster) lookup(symbol string) (instrument, bool) {
+	if im == nil {
+		return instrument{Symbol: symbol, Status: INSTRUMENT_ACTIVE}, symbol != ""
+	}
+	inst, ok := im.bySymbol[symbol]
+	return inst, ok
+}
+
+// list returns every listed instrument in symbol order. Without an
+// instrument master nothing is listed.
+func (im *instrumentMaster) list() []instrument {
+	if im == nil {
+		return nil
+	}
+
+	listed := make([]instrument, 0, len(im.symbols))
+	for _, symbol := range im.symbols {
+		listed = append(listed, im.bySymbol[symbol])
+	}
+	return listed
+}
+
+// onTick reports whether price is a whole number of ticks.
+func (inst instrument) onTick(price decimal.Decimal) bool {
+	return !inst.TickSize.IsPositive() || price.Mod(inst.TickSize).IsZero()
+}
+
+// inLots reports whether quantity is a whole number of lots.
+func (inst instrument) inLots(quantity decimal.Decimal) bool {
+	return !inst.LotSize.IsPositive() || quantity.Mod(inst.LotSize).IsZero()
+}
+
+// priceScale is how many decimal places prices in symbol are sent with, as
+// many as its tick size has. Without a tick size they are sent with 2.
+func (im *instrumentMaster) priceScale(symbol string) int32 {
+	if inst, ok := im.lookup(symbol); ok && inst.TickSize.IsPositive() {
+		return decimalPlaces(inst.TickSize)
+	}
+	return 2
+}
+
+// decimalPlaces is how many decimal places d needs, none for a whole number.
+func decimalPlaces(d decimal.Decimal) int32 {
+	places := int32(0)
+	for !d.Equal(d.Truncate(places)) {
+		places++
+	}
+	return places
+}
+
+// describe is the reference data FIX 4.x has no fields for.
+func (inst instrument) describe() string {
+	tick := "Any"
+	if inst.TickSize.IsPositive() {
+		tick = inst.TickSize.String()
+	}
+	return fmt.Sprintf("Tick Size %s, Status %s", tick, inst.Status)
+}
+
+// validate checks a new order against the instrument it is for. It returns
+// nil if the order may go ahead.
+func (im *instrumentMaster) validate(so *SingleOrder) *orderRejection {
+
+	inst, ok := im.lookup(so.symbol)
+	if !ok {
+		return &orderRejection{enum.OrdRejReason_UNKNOWN_SYMBOL, "Unknown Symbol " + so.symbol}
+	}
+
+	if inst.Status != INSTRUMENT_ACTIVE {
+		return &orderRejection{enum.OrdRejReason_EXCHANGE_CLOSED, so.symbol + " Is " + string(inst.Status)}
+	}
+
+	if !inst.inLots(so.volume) {
+		return &orderRejection{enum.OrdRejReason_INCORRECT_QUANTITY,
+			fmt.Sprintf("Quantity %s Is Not A Multiple Of Lot Size %s", so.volume, inst.LotSize)}
+	}
+
+	if !so.isMarket() && !inst.onTick(so.price) {
+		return &orderRejection{enum.OrdRejReason_INVALID_PRICE_INCREMENT,
+			fmt.Sprintf("Price %s Is Not A Multiple Of Tick Size %s", so.price, inst.TickSize)}
+	}
+
+	if (so.orderType == STOP || so.orderType == STOP_LIMIT) && !inst.onTick(so.stopPx) {
+		return &orderRejection{enum.OrdRejReason_INVALID_PRICE_INCREMENT,
+			fmt.Sprintf("StopPx %s Is Not A Multiple Of Tick Size %s", so.stopPx, inst.TickSize)}
+	}
+
+	return nil
+}
+
+// validateReplace checks the price and quantity a replace amends an order
+// to against the instrument, in the same way validate checks a new order.
+func (im *instrumentMaster) validateReplace(req *replaceRequest) *orderRejection {
+	return im.validate(&SingleOrder{symbol: req.symbol, orderType: req.orderType, price: req.price, stopPx: req.stopPx, volume: req.volume})
+}
+
+// ordRejReasonFor maps an OrdRejReason onto one beginString knows about.
+// Older versions only have the first few reasons, anything newer is sent as
+// an exchange option.
+func ordRejReasonFor(reason enum.OrdRejReason, beginString string) enum.OrdRejReason {
+
+	highest := map[string]int{
+		quickfix.BeginStringFIX40: 4,
+		quickfix.BeginStringFIX41: 6,
+		quickfix.BeginStringFIX42: 8,
+		quickfix.BeginStringFIX43: 12,
+		quickfix.BeginStringFIX44: 15,
+	}
+
+	limit, old := highest[beginString]
+	if !old {
+		return reason
+	}
+
+	value, _ := strconv.Atoi(string(reason))
+	if value <= limit || (reason == enum.OrdRejReason_OTHER && beginString == quickfix.BeginStringFIX44) {
+		return reason
+	}
+
+	return enum.OrdRejReason_BROKER
+}
+
+// instrumentSettings loads the instrument master named by InstrumentsPath.
+// Without one every symbol can be traded.
+func instrumentSettings(settings *quickfix.SessionSettings) (*instrumentMaster, error) {
+
+	if !settings.HasSetting("InstrumentsPath") {
+		fmt.Println("No InstrumentsPath Configured, Any Symbol Can Be Traded")
+		return nil, nil
+	}
+
+	path, _ := settings.Setting("InstrumentsPath")
+
+	return loadInstruments(path)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/quickfixgo/enum"
+	"github.com/shopspring/decimal"
+)
+
+func testInstruments() *instrumentMaster {
+	return &instrumentMaster{
+		bySymbol: map[string]instrument{
+			"AAPL": {Symbol: "AAPL", TickSize: decimal.RequireFromString("0.01"), LotSize: decimal.NewFromInt(10), Status: INSTRUMENT_ACTIVE},
+			"MSFT": {Symbol: "MSFT", TickSize: decimal.RequireFromString("0.05"), LotSize: decimal.NewFromInt(1), Status: INSTRUMENT_HALTED},
+		},
+		symbols: []string{"AAPL", "MSFT"},
+	}
+}
+
+func TestInstrumentValidate(t *testing.T) {
+
+	order := func(symbol string, orderType OrderType, price, volume, stopPx string) *SingleOrder {
+		so := &SingleOrder{id: "1", user: "alice", symbol: symbol, side: BUY, orderType: orderType, volume: decimal.RequireFromString(volume)}
+		if price != "" {
+			so.price = decimal.RequireFromString(price)
+		}
+		if stopPx != "" {
+			so.stopPx = decimal.RequireFromString(stopPx)
+		}
+		return so
+	}
+
+	tests := []struct {
+		name   string
+		order  *SingleOrder
+		reason enum.OrdRejReason
+	}{
+		{"on tick and in lots", order("AAPL", LIMIT, "100.25", "30", ""), ""},
+		{"unknown symbol", order("ZZZZ", LIMIT, "100", "10", ""), enum.OrdRejReason_UNKNOWN_SYMBOL},
+		{"halted", order("MSFT", LIMIT, "100", "10", ""), enum.OrdRejReason_EXCHANGE_CLOSED},
+		{"off lot", order("AAPL", LIMIT, "100", "25", ""), enum.OrdRejReason_INCORRECT_QUANTITY},
+		{"off tick", order("AAPL", LIMIT, "100.255", "10", ""), enum.OrdRejReason_INVALID_PRICE_INCREMENT},
+		{"market order has no price to check", order("AAPL", MARKET, "", "10", ""), ""},
+		{"stop off tick", order("AAPL", STOP, "", "10", "99.999"), enum.OrdRejReason_INVALID_PRICE_INCREMENT},
+		{"stop-limit on tick", order("AAPL", STOP_LIMIT, "100.10", "10", "99.90"), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got enum.OrdRejReason
+			if rejection := testInstruments().validate(tt.order); rejection != nil {
+				got = rejection.reason
+			}
+			if got != tt.reason {
+				t.Errorf("reason = %q, want %q", got, tt.reason)
+			}
+		})
+	}
+}
+
+func TestInstrumentValidateReplace(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		symbol string
+		price  string
+		volume string
+		reason enum.OrdRejReason
+	}{
+		{"on tick and in lots", "AAPL", "100.01", "20", ""},
+		{"off tick", "AAPL", "100.001", "20", enum.OrdRejReason_INVALID_PRICE_INCREMENT},
+		{"off lot", "AAPL", "100", "15", enum.OrdRejReason_INCORRECT_QUANTITY},
+		{"halted", "MSFT", "100", "10", enum.OrdRejReason_EXCHANGE_CLOSED},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &replaceRequest{
+				cancelRequest: cancelRequest{user: "alice", symbol: tt.symbol, side: BUY, id: "2", origID: "1"},
+				price:         decimal.RequireFromString(tt.price),
+				volume:        decimal.RequireFromString(tt.volume),
+			}
+
+			var got enum.OrdRejReason
+			if rejection := testInstruments().validateReplace(req); rejection != nil {
+				got = rejection.reason
+			}
+			if got != tt.reason {
+				t.Errorf("reason = %q, want %q", got, tt.reason)
+			}
+		})
+	}
+}
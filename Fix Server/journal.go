@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/quickfixgo/quickfix"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	JOURNAL_ACCEPT  = "accept"
+	JOURNAL_FILL    = "fill"
+	JOURNAL_CANCEL  = "cancel"
+	JOURNAL_REPLACE = "replace"
+	JOURNAL_EXPIRE  = "expire"
+	JOURNAL_TRIGGER = "trigger"
+)
+
+// journalOrder is how an order is written to disk.
+type journalOrder struct {
+	ID          string             `json:"id"`
+	OrderID     string             `json:"orderId"`
+	User        string             `json:"user"`
+	Symbol      string             `json:"symbol"`
+	Volume      decimal.Decimal    `json:"volume"`
+	Price       decimal.Decimal    `json:"price"`
+	Side        Side               `json:"side"`
+	OrderType   OrderType          `json:"orderType"`
+	TimeInForce TimeInForce        `json:"timeInForce"`
+	ExpireTime  time.Time          `json:"expireTime"`
+	StopPx      decimal.Decimal    `json:"stopPx"`
+	Triggered   bool               `json:"triggered"`
+	DisplayQty  decimal.Decimal    `json:"displayQty"`
+	Shown       decimal.Decimal    `json:"shown"`
+	State       OrderState         `json:"state"`
+	Filled      decimal.Decimal    `json:"filled"`
+	Notional    decimal.Decimal    `json:"notional"`
+	LastQty     decimal.Decimal    `json:"lastQty"`
+	LastPx      decimal.Decimal    `json:"lastPx"`
+	Seq         uint64             `json:"seq"`
+	SessionID   quickfix.SessionID `json:"sessionId"`
+}
+
+// journalEvent records the state of an order straight after something
+// happened to it. OrigID is the name the order had before a replace.
+type journalEvent struct {
+	Type   string       `json:"type"`
+	Order  journalOrder `json:"order"`
+	OrigID string       `json:"origId,omitempty"`
+}
+
+// journalClOrdID is a ClOrdID an order was accepted under. Each one is kept
+// long after its order is done, so it can't be used again.
+type journalClOrdID struct {
+	Symbol string `json:"symbol"`
+	User   string `json:"user"`
+	ID     string `json:"id"`
+}
+
+// journalSnapshot is every open order at the time it was taken, which
+// replaces all the events written before it, along with the last trade
+// price of each symbol and every ClOrdID used so far.
+type journalSnapshot struct {
+	Taken      time.Time                  `json:"taken"`
+	OrderID    int64                      `json:"orderId"`
+	Orders     []journalOrder             `json:"orders"`
+	ClOrdIDs   []journalClOrdID           `json:"clOrdIds"`
+	LastPrices map[string]decimal.Decimal `json:"lastPrices"`
+}
+
+// journal appends every accepted order, fill, cancel, replace and expiry to
+// journal.log, and every so often compacts the open orders into
+// snapshot.json so the log doesn't grow forever. Replaying the snapshot and
+// then the log rebuilds the books after a restart. Every event is synced to
+// disk before record returns, so nothing the exchange has acknowledged is
+// lost in a crash.
+//
+// A nil journal records nothing, for running without persistence.
+type journal struct {
+	mu            sync.Mutex
+	dir           string
+	file          *os.File
+	events        int
+	snapshotEvery int
+
+	// mirror of the open orders and used ClOrdIDs, so snapshots never have
+	// to ask the books
+	open       map[string]journalOrder
+	clOrdIDs   map[string]journalClOrdID
+	orderID    int64
+	lastPrices map[string]decimal.Decimal
+}
+
+func journalKey(symbol, user, id string) string {
+	return symbol + "\x00" + user + "\x00" + id
+}
+
+func toJournal(so *SingleOrder) journalOrder {
+	return journalOrder{
+		ID:          so.id,
+		OrderID:     so.orderID,
+		User:        so.user,
+		Symbol:      so.symbol,
+		Volume:      so.volume,
+		Price:       so.price,
+		Side:        so.side,
+		OrderType:   so.orderType,
+		TimeInForce: so.timeInForce,
+		ExpireTime:  so.expireTime,
+		StopPx:      so.stopPx,
+		Triggered:   so.triggered,
+		DisplayQty:  so.displayQty,
+		Shown:       so.shown,
+		State:       so.state,
+		Filled:      so.filled,
+		Notional:    so.notional,
+		LastQty:     so.lastQty,
+		LastPx:      so.lastPx,
+		Seq:         so.seq,
+		SessionID:   so.sessionID,
+	}
+}
+
+func (jo journalOrder) toOrder() *SingleOrder {
+	return &SingleOrder{
+		id:          jo.ID,
+		orderID:     jo.OrderID,
+		user:        jo.User,
+		symbol:      jo.Symbol,
+		volume:      jo.Volume,
+		price:       jo.Price,
+		side:        jo.Side,
+		orderType:   jo.OrderType,
+		timeInForce: jo.TimeInForce,
+		expireTime:  jo.ExpireTime,
+		stopPx:      jo.StopPx,
+		triggered:   jo.Triggered,
+		displayQty:  jo.DisplayQty,
+		shown:       jo.Shown,
+		state:       jo.State,
+		filled:      jo.Filled,
+		notional:    jo.Notional,
+		lastQty:     jo.LastQty,
+		lastPx:      jo.LastPx,
+		seq:         jo.Seq,
+		sessionID:   jo.SessionID,
+	}
+}
+
+// openJournal loads whatever is in dir and opens the log for appending.
+func openJournal(dir string, snapshotEvery int) (*journal, error) {
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	j := &journal{
+		dir:           dir,
+		snapshotEvery: snapshotEvery,
+		open:          make(map[string]journalOrder),
+		clOrdIDs:      make(map[string]journalClOrdID),
+		lastPrices:    make(map[string]decimal.Decimal),
+	}
+
+	if err := j.loadSnapshot(); err != nil {
+		return nil, err
+	}
+
+	if err := j.replay(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, "journal.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	j.file = file
+
+	fmt.Printf("Journal Restored %d Open Order(s) From %s \n\r", len(j.open), dir)
+
+	return j, nil
+}
+
+func (j *journal) loadSnapshot() error {
+
+	data, err := os.ReadFile(filepath.Join(j.dir, "snapshot.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snap journalSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("corrupt snapshot: %w", err)
+	}
+
+	j.orderID = snap.OrderID
+	for symbol, lastPx := range snap.LastPrices {
+		j.lastPrices[symbol] = lastPx
+	}
+	for _, jo := range snap.Orders {
+		j.open[journalKey(jo.Symbol, jo.User, jo.ID)] = jo
+	}
+	for _, used := range snap.ClOrdIDs {
+		j.clOrdIDs[journalKey(used.Symbol, used.User, used.ID)] = used
+	}
+
+	return nil
+}
+
+func (j *journal) replay() error {
+
+	file, err := os.Open(filepath.Join(j.dir, "journal.log"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		var ev journalEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			// a half written last line from a crash, everything before it is good
+			fmt.Printf("Skipping Unreadable Journal Entry: %s \n\r", err)
+			continue
+		}
+		j.apply(ev)
+	}
+
+	return scanner.Err()
+}
+
+// apply updates the open order mirror with one event.
+func (j *journal) apply(ev journalEvent) {
+
+	jo := ev.Order
+
+	if ev.Type == JOURNAL_REPLACE {
+		delete(j.open, journalKey(jo.Symbol, jo.User, ev.OrigID))
+	}
+
+	key := journalKey(jo.Symbol, jo.User, jo.ID)
+	j.clOrdIDs[key] = journalClOrdID{jo.Symbol, jo.User, jo.ID}
+
+	if jo.State.isOpen() {
+		j.open[key] = jo
+	} else {
+		delete(j.open, key)
+	}
+
+	if ev.Type == JOURNAL_FILL {
+		j.lastPrices[jo.Symbol] = jo.LastPx
+	}
+
+	var id int64
+	if _, err := fmt.Sscan(jo.OrderID, &id); err == nil && id > j.orderID {
+		j.orderID = id
+	}
+}
+
+// restored returns the open orders in the order they were queued, along
+// with every ClOrdID used, the highest OrderID handed out so far and each
+// symbol's last trade price.
+func (j *journal) restored() ([]*SingleOrder, []journalClOrdID, int64, map[string]decimal.Decimal) {
+
+	if j == nil {
+		return nil, nil, 0, nil
+	}
+
+	orders := make([]*SingleOrder, 0, len(j.open))
+	for _, jo := range j.open {
+		orders = append(orders, jo.toOrder())
+	}
+
+	sort.Slice(orders, func(a, b int) bool { return orders[a].seq < orders[b].seq })
+
+	return orders, j.usedClOrdIDs(), j.orderID, j.lastPrices
+}
+
+// usedClOrdIDs lists every ClOrdID in the journal.
+func (j *journal) usedClOrdIDs() []journalClOrdID {
+	used := make([]journalClOrdID, 0, len(j.clOrdIDs))
+	for _, clOrdID := range j.clOrdIDs {
+		used = append(used, clOrdID)
+	}
+	return used
+}
+
+// record appends an event for so, which must be the order's state straight
+// after the event. origID is only used by replaces.
+func (j *journal) record(eventType string, so *SingleOrder, origID string) {
+
+	if j == nil {
+		return
+	}
+
+	ev := journalEvent{Type: eventType, Order: toJournal(so), OrigID: origID}
+
+	line, err := json.Marshal(ev)
+	if err != nil {
+		fmt.Printf("Failed To Encode Journal Entry: %s \n\r", err)
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		fmt.Printf("Failed To Write Journal Entry: %s \n\r", err)
+		return
+	}
+
+	if err := j.file.Sync(); err != nil {
+		fmt.Printf("Failed To Sync Journal: %s \n\r", err)
+	}
+
+	j.apply(ev)
+	j.events++
+
+	if j.snapshotEvery > 0 && j.events >= j.snapshotEvery {
+		j.compact()
+	}
+}
+
+// compact writes the open orders to a new snapshot and empties the log.
+// The caller must hold j.mu.
+func (j *journal) compact() {
+
+	snap := journalSnapshot{Taken: time.Now(), OrderID: j.orderID, Orders: []journalOrder{}, ClOrdIDs: j.usedClOrdIDs(), LastPrices: j.lastPrices}
+	for _, jo := range j.open {
+		snap.Orders = append(snap.Orders, jo)
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		fmt.Printf("Failed To Encode Snapshot: %s \n\r", err)
+		return
+	}
+
+	tmp := filepath.Join(j.dir, "snapshot.json.tmp")
+	if err := writeFileSync(tmp, data); err != nil {
+		fmt.Printf("Failed To Write Snapshot: %s \n\r", err)
+		return
+	}
+
+	// once the snapshot is in place the log is no longer needed. If we die
+	// before truncating it, replaying it on top of the snapshot is harmless.
+	if err := os.Rename(tmp, filepath.Join(j.dir, "snapshot.json")); err != nil {
+		fmt.Printf("Failed To Replace Snapshot: %s \n\r", err)
+		return
+	}
+
+	if err := j.file.Truncate(0); err != nil {
+		fmt.Printf("Failed To Truncate Journal: %s \n\r", err)
+		return
+	}
+
+	j.events = 0
+	fmt.Printf("Journal Compacted, %d Open Order(s) \n\r", len(snap.Orders))
+}
+
+// compactEvery snapshots the journal on a timer, as well as after every
+// snapshotEvery events.
+func (j *journal) compactEvery(interval time.Duration) {
+
+	if j == nil || interval <= 0 {
+		return
+	}
+
+	for range time.Tick(interval) {
+		j.mu.Lock()
+		if j.events > 0 {
+			j.compact()
+		}
+		j.mu.Unlock()
+	}
+}
+
+// close takes a final snapshot so the next start has nothing to replay.
+func (j *journal) close() {
+
+	if j == nil {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.compact()
+	j.file.Close()
+}
+
+func writeFileSync(name string, data []byte) error {
+
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// restingOrder is what a restored order is checked against.
+type restingOrder struct {
+	id        string
+	price     int64
+	remaining int64
+	displayed int64
+}
+
+func restingOrders(orders []*SingleOrder) []restingOrder {
+	var resting []restingOrder
+	for _, so := range orders {
+		resting = append(resting, restingOrder{so.id, so.price.IntPart(), so.remaining().IntPart(), so.displayed().IntPart()})
+	}
+	return resting
+}
+
+func TestJournalRestore(t *testing.T) {
+
+	tests := []struct {
+		name string
+		stop func(j *journal)
+	}{
+		// a crash, so the next start replays the log
+		{"replayed from the log", func(j *journal) { j.file.Close() }},
+		// a clean shutdown, which leaves just a snapshot
+		{"restored from the snapshot", func(j *journal) { j.close() }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			j, err := openJournal(dir, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m := &market{journal: j, closeTime: 24 * time.Hour}
+			book := newOrderBook("AAPL")
+
+			place := func(so *SingleOrder) {
+				so.state = ORDER_PENDING_NEW
+				so.timeInForce = TIF_GTC
+				m.newOrder(book, &newOrderRequest{order: so, reply: make(chan marketResult, 1)})
+			}
+
+			place(testOrder("s1", SELL, 100, 10))
+			place(testOrder("s2", SELL, 100, 10))
+			place(icebergOrder("s3", SELL, 100, 10, 3))
+			place(testOrder("s4", SELL, 100, 10))
+			place(testOrder("s5", SELL, 101, 5))
+			// never trades, so it is restored just as it was accepted
+			place(icebergOrder("s6", SELL, 102, 10, 4))
+
+			// fills s1 in part
+			place(testOrder("b1", BUY, 100, 4))
+
+			m.cancel(book, &cancelRequest{user: "alice", symbol: "AAPL", side: SELL, id: "c1", origID: "s2", reply: make(chan marketResult, 1)})
+
+			// fills the rest of s1 and the iceberg's slice, which refills behind s4
+			place(testOrder("b2", BUY, 100, 9))
+
+			m.replace(book, &replaceRequest{
+				cancelRequest: cancelRequest{user: "alice", symbol: "AAPL", side: SELL, id: "s5r", origID: "s5", reply: make(chan marketResult, 1)},
+				price:         decimal.NewFromInt(101),
+				volume:        decimal.NewFromInt(3),
+			})
+
+			want := []restingOrder{{"s4", 100, 10, 10}, {"s3", 100, 7, 3}, {"s5r", 101, 3, 3}, {"s6", 102, 10, 4}}
+			if got := restingOrders(book.asks); !reflect.DeepEqual(got, want) {
+				t.Fatalf("asks before the restart = %v, want %v", got, want)
+			}
+			if len(book.orders) != len(want) {
+				t.Fatalf("book holds %d orders before the restart, want the %d open ones", len(book.orders), len(want))
+			}
+
+			tt.stop(j)
+
+			j, err = openJournal(dir, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer j.close()
+
+			restored := &market{journal: j, books: make(map[string]*symbolBook)}
+			restored.restore()
+
+			sb, ok := restored.books["AAPL"]
+			if !ok {
+				t.Fatal("no book restored for AAPL")
+			}
+
+			if got := restingOrders(sb.book.asks); !reflect.DeepEqual(got, want) {
+				t.Errorf("asks = %v, want %v", got, want)
+			}
+			if len(sb.book.bids) != 0 {
+				t.Errorf("restored %d bids, want none", len(sb.book.bids))
+			}
+			if !sb.book.lastPx.Equal(decimal.NewFromInt(100)) {
+				t.Errorf("lastPx = %s, want 100", sb.book.lastPx)
+			}
+			if got, want := restored.orderID.Load(), m.orderID.Load(); got != want {
+				t.Errorf("last OrderID = %d, want %d", got, want)
+			}
+			if _, ok := sb.book.find("alice", "s2"); ok {
+				t.Error("cancelled order restored")
+			}
+
+			// filled, cancelled and replaced orders are gone, but their
+			// ClOrdIDs still can't be used again
+			for _, id := range []string{"s1", "s2", "s5", "b1", "b2", "s5r"} {
+				if !sb.book.taken("alice", id) {
+					t.Errorf("ClOrdID %s free to use again after the restart", id)
+				}
+			}
+		})
+	}
+}
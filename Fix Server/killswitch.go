@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// killSwitch stops a firm, or one user at a firm, from entering orders. An
+// empty SubID covers every user of the SenderCompID.
+type killSwitch struct {
+	CompID      string    `json:"compId"`
+	SubID       string    `json:"subId,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	TriggeredBy string    `json:"triggeredBy"`
+	TriggeredAt time.Time `json:"triggeredAt"`
+}
+
+func (ks killSwitch) String() string {
+	who := ks.CompID
+	if ks.SubID != "" {
+		who += "/" + ks.SubID
+	}
+	return who
+}
+
+// killSwitches are the switches currently engaged. They are written to path
+// whenever they change so they stay engaged across a restart, unless path
+// is empty.
+type killSwitches struct {
+	mu      sync.Mutex
+	path    string
+	engaged map[string]killSwitch
+}
+
+// killSwitchText is the Text rejected orders are given.
+func killSwitchText(ks *killSwitch) string {
+	return fmt.Sprintf("Kill Switch Engaged For %s By %s At %s", ks, ks.TriggeredBy, ks.TriggeredAt.Format(time.RFC3339))
+}
+
+func killSwitchKey(compID, subID string) string {
+	return compID + "\x00" + subID
+}
+
+// loadKillSwitches reads the switches that were engaged when the exchange
+// last stopped.
+func loadKillSwitches(path string) (*killSwitches, error) {
+
+	k := &killSwitches{path: path, engaged: make(map[string]killSwitch)}
+
+	if path == "" {
+		return k, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var engaged []killSwitch
+	if err := json.Unmarshal(data, &engaged); err != nil {
+		return nil, fmt.Errorf("corrupt kill switches: %w", err)
+	}
+
+	for _, ks := range engaged {
+		k.engaged[killSwitchKey(ks.CompID, ks.SubID)] = ks
+		fmt.Printf("Kill Switch For %s Still Engaged, Triggered By %s At %s \n\r", ks, ks.TriggeredBy, ks.TriggeredAt.Format(time.RFC3339))
+	}
+
+	return k, nil
+}
+
+// blocking returns the switch that stops subID at compID entering orders,
+// or nil if there isn't one. A switch on the whole firm wins over one on
+// the user. Nil killSwitches block no one.
+func (k *killSwitches) blocking(compID, subID string) *killSwitch {
+
+	if k == nil {
+		return nil
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if ks, ok := k.engaged[killSwitchKey(compID, "")]; ok {
+		return &ks
+	}
+	if ks, ok := k.engaged[killSwitchKey(compID, subID)]; ok {
+		return &ks
+	}
+	return nil
+}
+
+// engage blocks ks.CompID/ks.SubID. Engaging a switch that is already
+// engaged keeps the original trigger.
+func (k *killSwitches) engage(ks killSwitch) (killSwitch, error) {
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key := killSwitchKey(ks.CompID, ks.SubID)
+	if existing, ok := k.engaged[key]; ok {
+		return existing, nil
+	}
+
+	k.engaged[key] = ks
+	fmt.Printf("Kill Switch For %s Engaged By %s \n\r", ks, ks.TriggeredBy)
+
+	return ks, k.save()
+}
+
+// reset lets compID/subID enter orders again. It returns false if there was
+// no such switch.
+func (k *killSwitches) reset(compID, subID, by string) (bool, error) {
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key := killSwitchKey(compID, subID)
+	ks, ok := k.engaged[key]
+	if !ok {
+		return false, nil
+	}
+
+	delete(k.engaged, key)
+	fmt.Printf("Kill Switch For %s Reset By %s \n\r", ks, by)
+
+	return true, k.save()
+}
+
+// list returns the engaged switches, oldest first.
+func (k *killSwitches) list() []killSwitch {
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.sorted()
+}
+
+// sorted returns the engaged switches, oldest first. The caller must hold
+// k.mu.
+func (k *killSwitches) sorted() []killSwitch {
+	engaged := make([]killSwitch, 0, len(k.engaged))
+	for _, ks := range k.engaged {
+		engaged = append(engaged, ks)
+	}
+
+	sort.Slice(engaged, func(a, b int) bool { return engaged[a].TriggeredAt.Before(engaged[b].TriggeredAt) })
+
+	return engaged
+}
+
+// save writes the engaged switches to disk. The caller must hold k.mu.
+func (k *killSwitches) save() error {
+
+	if k.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(k.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(k.path), 0o755); err != nil {
+		return err
+	}
+
+	tmp := k.path + ".tmp"
+	if err := writeFileSync(tmp, data); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, k.path)
+}
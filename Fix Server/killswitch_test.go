@@ -0,0 +1,167 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/quickfixgo/quickfix"
+)
+
+func TestKillSwitchBlocking(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		engaged []killSwitch
+		compID  string
+		subID   string
+		blocked string
+	}{
+		{"nothing engaged", nil, "Client", "bot", ""},
+		{"user blocked", []killSwitch{{CompID: "Client", SubID: "bot"}}, "Client", "bot", "Client/bot"},
+		{"other users of the firm trade on", []killSwitch{{CompID: "Client", SubID: "bot"}}, "Client", "alice", ""},
+		{"whole firm blocked", []killSwitch{{CompID: "Client"}}, "Client", "alice", "Client"},
+		{"firm wins over user", []killSwitch{{CompID: "Client", SubID: "bot"}, {CompID: "Client"}}, "Client", "bot", "Client"},
+		{"other firms trade on", []killSwitch{{CompID: "Client"}}, "Other", "bot", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := loadKillSwitches("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, ks := range tt.engaged {
+				if _, err := k.engage(ks); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got := ""
+			if ks := k.blocking(tt.compID, tt.subID); ks != nil {
+				got = ks.String()
+			}
+			if got != tt.blocked {
+				t.Errorf("blocking(%s, %s) = %q, want %q", tt.compID, tt.subID, got, tt.blocked)
+			}
+		})
+	}
+}
+
+// Engaged switches are still engaged after a restart, and reset ones are not.
+func TestKillSwitchPersistence(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "killswitches.json")
+	at := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+
+	k, err := loadKillSwitches(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.engage(killSwitch{CompID: "Client", SubID: "bot", TriggeredBy: "alice", Reason: "runaway", TriggeredAt: at}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.engage(killSwitch{CompID: "Other", TriggeredBy: "alice", TriggeredAt: at.Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// engaging it again keeps the original trigger
+	again, err := k.engage(killSwitch{CompID: "Client", SubID: "bot", TriggeredBy: "bob", TriggeredAt: at.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.TriggeredBy != "alice" {
+		t.Errorf("re-engaged switch triggered by %s, want alice", again.TriggeredBy)
+	}
+
+	if reset, err := k.reset("Other", "", "alice"); err != nil || !reset {
+		t.Fatalf("reset = %v, %v", reset, err)
+	}
+
+	restarted, err := loadKillSwitches(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks := restarted.blocking("Client", "bot")
+	if ks == nil {
+		t.Fatal("kill switch not engaged after a restart")
+	}
+	if ks.TriggeredBy != "alice" || ks.Reason != "runaway" || !ks.TriggeredAt.Equal(at) {
+		t.Errorf("restored %+v, want the original trigger", *ks)
+	}
+	if restarted.blocking("Other", "bot") != nil {
+		t.Error("reset kill switch engaged after a restart")
+	}
+}
+
+// A kill switch's cancel covers every user of the firm, or just the one.
+func TestOrderScopeFirm(t *testing.T) {
+
+	order := func(compID, user string) *SingleOrder {
+		so := testOrder("1", BUY, 100, 10)
+		so.user = user
+		so.sessionID = quickfix.SessionID{TargetCompID: compID}
+		return so
+	}
+
+	tests := []struct {
+		name   string
+		scope  orderScope
+		order  *SingleOrder
+		covers bool
+	}{
+		{"any user of the firm", orderScope{compID: "Client"}, order("Client", "alice"), true},
+		{"other firm", orderScope{compID: "Client"}, order("Other", "alice"), false},
+		{"the user", orderScope{compID: "Client", user: "bot"}, order("Client", "bot"), true},
+		{"other user of the firm", orderScope{compID: "Client", user: "bot"}, order("Client", "alice"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.scope.covers(tt.order); got != tt.covers {
+				t.Errorf("covers = %v, want %v", got, tt.covers)
+			}
+		})
+	}
+}
+
+// An order let through just before a switch was engaged is still turned
+// away when it reaches its book, so the switch's cancel can't miss it.
+func TestKillSwitchCheckedByTheBook(t *testing.T) {
+
+	kills, err := loadKillSwitches("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &market{kills: kills}
+	book := newOrderBook("AAPL")
+
+	place := func(id string) marketResult {
+		so := testOrder(id, BUY, 100, 10)
+		so.state = ORDER_PENDING_NEW
+		so.timeInForce = TIF_GTC
+		so.sessionID = quickfix.SessionID{TargetCompID: "Client"}
+
+		req := &newOrderRequest{order: so, reply: make(chan marketResult, 1)}
+		m.newOrder(book, req)
+		return <-req.reply
+	}
+
+	if res := place("1"); res.status != NSO_PLACED {
+		t.Fatalf("status = %d before the switch, want NSO_PLACED", res.status)
+	}
+
+	if _, err := kills.engage(killSwitch{CompID: "Client", TriggeredBy: "admin"}); err != nil {
+		t.Fatal(err)
+	}
+
+	res := place("2")
+	if res.status != NSO_FAILED || res.rejection == nil || res.order.state != ORDER_REJECTED {
+		t.Fatalf("status = %d after the switch, want NSO_FAILED with a rejection", res.status)
+	}
+	if _, found := book.find("alice", "2"); found {
+		t.Error("blocked order reached the book")
+	}
+}
@@ -2,23 +2,182 @@ package main
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/quickfix"
 	"github.com/shopspring/decimal"
-
-	fix50nos "github.com/quickfixgo/fix50/newordersingle"
-	fix50cxl "github.com/quickfixgo/fix50/ordercancelrequest"
-	fix50osr "github.com/quickfixgo/fix50/orderstatusrequest"
 )
 
 type market struct {
-	nsoChannel       chan *fix50nos.NewOrderSingle
-	cancelChannel    chan *fix50cxl.OrderCancelRequest
-	queryChannel     chan *fix50osr.OrderStatusRequest
-	respChannel      chan OrderExecutionStatus
-	queryRespChannel chan *SingleOrder
+	nsoChannel        chan *newOrderRequest
+	cancelChannel     chan *cancelRequest
+	replaceChannel    chan *replaceRequest
+	queryChannel      chan *queryRequest
+	massCancelChannel chan *massCancelRequest
+	massStatusChannel chan *massStatusRequest
+
+	// orders that ran out of time, and auctions, for the server to report
+	expiryChannel  chan *SingleOrder
+	auctionChannel chan *auctionReport
+
+	// what each book shows and trades, for market data
+	bookChannel chan *bookUpdate
+
+	// changes of trading phase, from the trading session. phase is the
+	// current one, for books opened later
+	phaseChannel chan TradingPhase
+	phase        TradingPhase
+
+	// Day orders expire at this time of day (UTC)
+	closeTime time.Duration
+
+	// how far through the book market orders may trade, see orderBook
+	protection decimal.Decimal
+
+	// only the routing goroutine touches books, and only a symbol's own
+	// goroutine touches the orderBook inside it
+	books   map[string]*symbolBook
+	orderID atomic.Int64
+	journal *journal
+	risk    *riskEngine
+
+	// checked again as each order reaches its book, so one that was let
+	// through just before a switch was engaged can't slip in behind the
+	// switch's cancels
+	kills *killSwitches
+}
+
+// symbolBook is the single owner of one symbol's order book. Requests for
+// the symbol are handled one at a time, in the order they arrived, while
+// other symbols are handled in parallel by their own goroutines.
+type symbolBook struct {
+	book     *orderBook
+	requests chan any
+}
+
+// marketResult is the market's answer to a single request. order is a copy
+// of the order the request was about, nil if there was no such order.
+// canceled is set when what was left of an IOC, FOK or market order was
+// cancelled after matching, and released lists the stop orders its trades
+// triggered. orders holds every order a mass request affected, and
+// rejection says why a new order was turned away.
+type marketResult struct {
+	status    OrderExecutionStatus
+	order     *SingleOrder
+	trades    []Trade
+	canceled  *SingleOrder
+	released  []stopRelease
+	orders    []SingleOrder
+	rejection *orderRejection
+}
+
+// stopRelease is a stop order entering the book once it has triggered:
+// the order as it triggered, what it traded, and its cancelled remainder
+// if it could not rest.
+type stopRelease struct {
+	order    SingleOrder
+	trades   []Trade
+	canceled *SingleOrder
+}
+
+// Every request carries its own reply channel, so the answer always goes
+// back to the handler that asked no matter how many sessions are busy.
+
+// newOrderRequest asks the market to accept a new order.
+type newOrderRequest struct {
+	order *SingleOrder
+	reply chan marketResult
+}
+
+// cancelRequest asks the market to cancel the order origID. id is the
+// ClOrdID of the cancel request itself.
+type cancelRequest struct {
+	user   string
+	symbol string
+	side   Side
+	id     string
+	origID string
+	reply  chan marketResult
+}
+
+// replaceRequest asks the market to amend the order origID to a new price,
+// stop and volume, after which it is known as id. orderType must be the
+// order's own, it can't be changed.
+type replaceRequest struct {
+	cancelRequest
+	orderType OrderType
+	price     decimal.Decimal
+	stopPx    decimal.Decimal
+	volume    decimal.Decimal
+}
+
+// queryRequest asks the market for the current state of the order id.
+type queryRequest struct {
+	user   string
+	symbol string
+	side   Side
+	id     string
+	reply  chan marketResult
+}
+
+// orderScope picks out the open orders of user, in one symbol or in all of
+// them if symbol is empty, and if bySide is set only those on side. If
+// compID is set only orders entered by that firm are covered, and an empty
+// user then covers every user of the firm.
+type orderScope struct {
+	compID string
+	user   string
+	symbol string
+	side   Side
+	bySide bool
+}
+
+func (s orderScope) covers(so *SingleOrder) bool {
+	if s.compID != "" && so.sessionID.TargetCompID != s.compID {
+		return false
+	}
+	if (s.compID == "" || s.user != "") && so.user != s.user {
+		return false
+	}
+	return so.state.isOpen() && (!s.bySide || so.side == s.side)
+}
 
-	orders map[string][]SingleOrder
+// massCancelRequest asks the market to cancel every order in scope.
+type massCancelRequest struct {
+	orderScope
+	reply chan marketResult
+}
+
+// massStatusRequest asks the market for the state of every order in scope.
+type massStatusRequest struct {
+	orderScope
+	reply chan marketResult
+}
+
+// expireRequest asks a book to expire every order whose time is up.
+type expireRequest struct {
+	now time.Time
+}
+
+// phaseRequest tells a book the market has moved into phase.
+type phaseRequest struct {
+	phase TradingPhase
+}
+
+// publishRequest asks a book to publish its depth to market data.
+type publishRequest struct{}
+
+// bookUpdate is a symbol's displayed depth after a change, best prices
+// first, and the trades since the last update.
+type bookUpdate struct {
+	symbol string
+	bids   []level
+	asks   []level
+	trades []Trade
 }
 
 type Side int
@@ -31,8 +190,10 @@ const (
 type OrderType int
 
 const (
-	LIMIT  = 0
-	MARKET = 1
+	LIMIT      = 0
+	MARKET     = 1
+	STOP       = 2
+	STOP_LIMIT = 3
 )
 
 type OrderExecutionStatus int
@@ -41,129 +202,660 @@ const (
 	NSO_PLACED = iota
 	NSO_FAILED
 	NSO_FAILED_ORDER_EXISTS
+	NSO_FAILED_TOO_LATE
+	NSO_FAILED_PHASE
 	CANCEL_CANCELLED
 	CANCEL_FAILED
 	CANCEL_NO_SUCH_ORDER
 	QUERY_NO_SUCH_ORDER
 	QUERY_ORDER_FOUND
+	REPLACE_REPLACED
+	REPLACE_FAILED
+	REPLACE_FAILED_ORDER_EXISTS
+	REPLACE_NO_SUCH_ORDER
+	REPLACE_FAILED_PHASE
+	REPLACE_FAILED_ORDER_TYPE
+	MASS_CANCEL_CANCELLED
+	MASS_STATUS_FOUND
 )
 
 type SingleOrder struct {
 	id        string
+	orderID   string
 	user      string
 	symbol    string
 	volume    decimal.Decimal
 	price     decimal.Decimal
 	side      Side
 	orderType OrderType
+
+	timeInForce TimeInForce
+	expireTime  time.Time
+
+	// stop and stop-limit orders wait for the last trade to reach stopPx,
+	// then trade as market and limit orders
+	stopPx    decimal.Decimal
+	triggered bool
+
+	// the worst price a market order may trade at, set as it enters the book
+	protectPx decimal.Decimal
+
+	// an iceberg order only shows displayQty at a time, shown is what is left
+	// of the current slice
+	displayQty decimal.Decimal
+	shown      decimal.Decimal
+
+	state     OrderState
+	filled    decimal.Decimal
+	notional  decimal.Decimal
+	lastQty   decimal.Decimal
+	lastPx    decimal.Decimal
+	seq       uint64
+	sessionID quickfix.SessionID
 }
 
-func (m market) listenNSO() {
-	for {
-		fmt.Println("NSO Channel Idle")
+// remaining is the LeavesQty of the order, which is nothing once it is
+// no longer open.
+func (so *SingleOrder) remaining() decimal.Decimal {
+	if !so.state.isOpen() {
+		return decimal.Zero
+	}
+	return so.volume.Sub(so.filled)
+}
 
-		msg := <-m.nsoChannel
+// pendingStop reports whether so is a stop order still waiting to trigger.
+func (so *SingleOrder) pendingStop() bool {
+	return (so.orderType == STOP || so.orderType == STOP_LIMIT) && !so.triggered
+}
 
-		so := SingleOrder{}
-		so.user, _ = msg.GetSenderSubID()
-		so.symbol, _ = msg.GetSymbol()
-		so.volume, _ = msg.GetOrderQty()
-		so.price, _ = msg.GetPrice()
-		so.id, _ = msg.GetClOrdID()
+// isMarket reports whether so trades at any price.
+func (so *SingleOrder) isMarket() bool {
+	return so.orderType == MARKET || so.orderType == STOP
+}
 
-		side, _ := msg.GetSide()
-		if side == enum.Side_BUY {
-			so.side = BUY
-		} else {
-			so.side = SELL
-		}
+// rests reports whether whatever is left of so after matching may wait in
+// the book. Market orders and IOC and FOK orders are cancelled instead.
+func (so *SingleOrder) rests() bool {
+	return so.timeInForce.rests() && !so.isMarket()
+}
 
-		ot, _ := msg.GetOrdType()
-		if ot == enum.OrdType_MARKET {
-			so.orderType = MARKET
-		} else {
-			so.orderType = LIMIT
-		}
+// isIceberg reports whether so hides part of its quantity.
+func (so *SingleOrder) isIceberg() bool {
+	return so.displayQty.IsPositive()
+}
 
-		unique := true
-		for _, order := range m.orders[so.symbol] {
-			if order.id == so.id && so.user == order.user {
-				unique = false
-				m.respChannel <- NSO_FAILED_ORDER_EXISTS
-				fmt.Printf("New Single Order (%s) From %s NOT Placed\n\r", so.id, so.user)
-				break
-			}
+// displayed is how much of so other traders can see, and trade against.
+func (so *SingleOrder) displayed() decimal.Decimal {
+	if so.isIceberg() {
+		return decimal.Min(so.shown, so.remaining())
+	}
+	return so.remaining()
+}
+
+// replenish shows the next slice of an iceberg order from its reserve.
+func (so *SingleOrder) replenish() {
+	if so.isIceberg() {
+		so.shown = decimal.Min(so.displayQty, so.remaining())
+	}
+}
+
+// crosses reports whether so is willing to trade at price. Market orders
+// take any price up to their protection limit.
+func (so *SingleOrder) crosses(price decimal.Decimal) bool {
+	limit := so.price
+
+	if so.isMarket() {
+		if so.protectPx.IsZero() {
+			return true
 		}
+		limit = so.protectPx
+	}
+
+	if so.side == BUY {
+		return limit.GreaterThanOrEqual(price)
+	}
+	return limit.LessThanOrEqual(price)
+}
+
+func (so *SingleOrder) fill(volume, price decimal.Decimal) {
+	so.filled = so.filled.Add(volume)
+	so.notional = so.notional.Add(volume.Mul(price))
+	so.lastQty = volume
+	so.lastPx = price
+
+	if so.filled.GreaterThanOrEqual(so.volume) {
+		so.transition(ORDER_FILLED)
+	} else {
+		so.transition(ORDER_PARTIALLY_FILLED)
+	}
+}
+
+func (so *SingleOrder) avgPx() decimal.Decimal {
+	if so.filled.IsZero() {
+		return decimal.Zero
+	}
+	return so.notional.Div(so.filled)
+}
+
+func (s Side) toFix() enum.Side {
+	if s == BUY {
+		return enum.Side_BUY
+	}
+	return enum.Side_SELL
+}
+
+func (ot OrderType) toFix() enum.OrdType {
+	switch ot {
+	case MARKET:
+		return enum.OrdType_MARKET
+	case STOP:
+		return enum.OrdType_STOP
+	case STOP_LIMIT:
+		return enum.OrdType_STOP_LIMIT
+	}
+	return enum.OrdType_LIMIT
+}
+
+// orderTypeFromFix maps OrdType (40) onto the order types the exchange
+// supports. A missing OrdType means Limit.
+func orderTypeFromFix(ot enum.OrdType) (OrderType, bool) {
+	switch ot {
+	case "", enum.OrdType_LIMIT:
+		return LIMIT, true
+	case enum.OrdType_MARKET:
+		return MARKET, true
+	case enum.OrdType_STOP:
+		return STOP, true
+	case enum.OrdType_STOP_LIMIT:
+		return STOP_LIMIT, true
+	}
+	return LIMIT, false
+}
+
+func sideFromFix(side enum.Side) Side {
+	if side == enum.Side_BUY {
+		return BUY
+	}
+	return SELL
+}
 
-		if unique {
-			fmt.Printf("New Single Order (%s) From %s Placed\n\r", so.id, so.user)
-			m.orders[so.symbol] = append(m.orders[so.symbol], so)
-			m.respChannel <- NSO_PLACED
+// symbolBook returns the owner of symbol's book, opening it if this is the
+// first order for that symbol.
+func (m *market) symbolBook(symbol string) *symbolBook {
+	sb, ok := m.books[symbol]
+	if !ok {
+		sb = &symbolBook{book: newOrderBook(symbol), requests: make(chan any, 64)}
+		sb.book.protection = m.protection
+		sb.book.phase = m.phase
+		m.books[symbol] = sb
+		go m.runBook(sb)
+	}
+	return sb
+}
+
+// booksFor returns symbol's book, or every book if symbol is empty. It
+// does not open a book for a symbol that has never traded.
+func (m *market) booksFor(symbol string) []*symbolBook {
+	if symbol == "" {
+		books := make([]*symbolBook, 0, len(m.books))
+		for _, sb := range m.books {
+			books = append(books, sb)
 		}
+		return books
+	}
 
+	if sb, ok := m.books[symbol]; ok {
+		return []*symbolBook{sb}
 	}
+	return nil
 }
 
-func (m market) listenCancel() {
+// route hands each request to the goroutine that owns its symbol, and
+// once a second asks every book to expire its orders.
+func (m *market) route() {
+	expiry := time.NewTicker(time.Second)
+
 	for {
-		fmt.Println("Cancel Channel Idle!")
-
-		msg := <-m.cancelChannel
-
-		ordId, _ := msg.GetOrigClOrdID()
-		user, _ := msg.GetSenderSubID()
-		ticker, _ := msg.GetSymbol()
-
-		found := false
-		// search through orders and cancel if possible
-		for i, order := range m.orders[ticker] {
-			if ordId == order.id && user == order.user {
-				m.orders[ticker] = append(m.orders[ticker][:i], m.orders[ticker][i+1:]...)
-				fmt.Printf("Canceled Order (%s) by %s \n\r", ordId, user)
-				m.respChannel <- CANCEL_CANCELLED
-				found = true
-				break
+		select {
+		case now := <-expiry.C:
+			for _, sb := range m.books {
+				sb.requests <- &expireRequest{now}
+			}
+		case req := <-m.nsoChannel:
+			m.symbolBook(req.order.symbol).requests <- req
+		// there are no orders to find for a symbol without a book, so
+		// these are answered here rather than opening one
+		case req := <-m.cancelChannel:
+			if sb, ok := m.books[req.symbol]; ok {
+				sb.requests <- req
+			} else {
+				req.reply <- marketResult{status: CANCEL_NO_SUCH_ORDER}
+			}
+		case req := <-m.replaceChannel:
+			if sb, ok := m.books[req.symbol]; ok {
+				sb.requests <- req
+			} else {
+				req.reply <- marketResult{status: REPLACE_NO_SUCH_ORDER}
+			}
+		case req := <-m.queryChannel:
+			if sb, ok := m.books[req.symbol]; ok {
+				sb.requests <- req
+			} else {
+				req.reply <- marketResult{status: QUERY_NO_SUCH_ORDER}
+			}
+		// a mass request is queued on its books here, in turn with every
+		// other request, and only the answers are waited for elsewhere
+		case req := <-m.massCancelChannel:
+			books := m.booksFor(req.symbol)
+			results := fanOut(books, func(reply chan marketResult) any {
+				return &massCancelRequest{req.orderScope, reply}
+			})
+			go m.massCancel(req, results, len(books))
+		case req := <-m.massStatusChannel:
+			books := m.booksFor(req.symbol)
+			results := fanOut(books, func(reply chan marketResult) any {
+				return &massStatusRequest{req.orderScope, reply}
+			})
+			go m.massStatus(req, results, len(books))
+		case phase := <-m.phaseChannel:
+			m.phase = phase
+			for _, sb := range m.books {
+				sb.requests <- &phaseRequest{phase}
 			}
 		}
+	}
+}
 
-		if !found {
-			m.respChannel <- CANCEL_NO_SUCH_ORDER
+func (m *market) runBook(sb *symbolBook) {
+	fmt.Printf("Opened Book For %s \n\r", sb.book.symbol)
+
+	for req := range sb.requests {
+		switch req := req.(type) {
+		case *newOrderRequest:
+			m.newOrder(sb.book, req)
+		case *cancelRequest:
+			m.cancel(sb.book, req)
+		case *replaceRequest:
+			m.replace(sb.book, req)
+		case *queryRequest:
+			m.query(sb.book, req)
+		case *expireRequest:
+			m.expire(sb.book, req.now)
+		case *massCancelRequest:
+			m.cancelAll(sb.book, req)
+		case *massStatusRequest:
+			m.queryAll(sb.book, req)
+		case *phaseRequest:
+			m.changePhase(sb.book, req.phase)
+		case *publishRequest:
 		}
 
+		m.indicate(sb.book)
+		m.publish(sb.book)
 	}
 }
 
-func (m market) listenQuery() {
-	for {
-		fmt.Println("Query Channel Idle!")
-		msg := <-m.queryChannel
-
-		ordId, _ := msg.GetOrdStatusReqID()
-		user, _ := msg.GetSenderSubID()
-		ticker, _ := msg.GetSymbol()
-
-		found := false
-		// search through orders and cancel if possible
-		for _, order := range m.orders[ticker] {
-			if ordId == order.id && user == order.user {
-				fmt.Printf("Queried Order (%s) by %s \n\r", ordId, user)
-				m.respChannel <- QUERY_ORDER_FOUND
-				m.queryRespChannel <- &order
-				found = true
-				break
+func (m *market) newOrder(book *orderBook, req *newOrderRequest) {
+	so := req.order
+
+	// the risk checks counted the order before it was handed over, from
+	// here on the journal's events do
+	defer m.risk.settle(so)
+
+	if ks := m.kills.blocking(so.sessionID.TargetCompID, so.user); ks != nil {
+		so.orderID = "NONE"
+		so.transition(ORDER_REJECTED)
+		req.reply <- marketResult{status: NSO_FAILED, order: so, rejection: &orderRejection{enum.OrdRejReason_BROKER, killSwitchText(ks)}}
+		fmt.Printf("New Single Order (%s) From %s NOT Placed, Kill Switch Engaged\n\r", so.id, so.user)
+		return
+	}
+
+	if book.taken(so.user, so.id) {
+		so.orderID = "NONE"
+		so.transition(ORDER_REJECTED)
+		req.reply <- marketResult{status: NSO_FAILED_ORDER_EXISTS, order: so}
+		fmt.Printf("New Single Order (%s) From %s NOT Placed\n\r", so.id, so.user)
+		return
+	}
+
+	if refused := book.phase.refuse(so); refused != "" {
+		so.orderID = "NONE"
+		so.transition(ORDER_REJECTED)
+		req.reply <- marketResult{status: NSO_FAILED_PHASE, order: so, rejection: &orderRejection{enum.OrdRejReason_EXCHANGE_CLOSED, refused}}
+		fmt.Printf("New Single Order (%s) From %s NOT Placed, %s\n\r", so.id, so.user, refused)
+		return
+	}
+
+	now := time.Now()
+
+	if so.timeInForce == TIF_GTD && !so.expireTime.After(now) {
+		so.orderID = "NONE"
+		so.transition(ORDER_REJECTED)
+		req.reply <- marketResult{status: NSO_FAILED_TOO_LATE, order: so}
+		fmt.Printf("New Single Order (%s) From %s NOT Placed, Already Expired\n\r", so.id, so.user)
+		return
+	}
+
+	if so.timeInForce == TIF_DAY {
+		so.expireTime = dayEnd(now, m.closeTime)
+	}
+
+	so.orderID = strconv.FormatInt(m.orderID.Add(1), 10)
+	so.transition(ORDER_NEW)
+	placed := *so
+
+	trades := book.add(so)
+	fmt.Printf("New Single Order (%s) From %s Placed, %d Trade(s)\n\r", so.id, so.user, len(trades))
+
+	// the book only gives the order its place in the queue, and an iceberg
+	// its first slice, once it is added
+	placed.seq = so.seq
+	placed.shown = so.shown
+	m.record(book, JOURNAL_ACCEPT, &placed, "")
+	m.recordTrades(book, trades)
+
+	req.reply <- marketResult{
+		status:   NSO_PLACED,
+		order:    &placed,
+		trades:   trades,
+		canceled: m.cancelUnrested(book, so),
+		released: m.releaseStops(book),
+	}
+}
+
+func (m *market) cancel(book *orderBook, req *cancelRequest) {
+	order, found := book.find(req.user, req.origID)
+
+	switch {
+	case !found:
+		req.reply <- marketResult{status: CANCEL_NO_SUCH_ORDER}
+	case !order.transition(ORDER_CANCELED):
+		// already filled or cancelled, there is nothing left to cancel
+		current := *order
+		req.reply <- marketResult{status: CANCEL_FAILED, order: &current}
+	default:
+		book.remove(order)
+		fmt.Printf("Canceled Order (%s) by %s \n\r", req.origID, req.user)
+		canceled := *order
+		m.record(book, JOURNAL_CANCEL, &canceled, "")
+		req.reply <- marketResult{status: CANCEL_CANCELLED, order: &canceled}
+	}
+}
+
+func (m *market) replace(book *orderBook, req *replaceRequest) {
+	order, found := book.find(req.user, req.origID)
+
+	if !found {
+		req.reply <- marketResult{status: REPLACE_NO_SUCH_ORDER}
+		return
+	}
+
+	current := *order
+
+	if book.taken(req.user, req.id) && req.id != req.origID {
+		req.reply <- marketResult{status: REPLACE_FAILED_ORDER_EXISTS, order: &current}
+		return
+	}
+
+	// an order can't be amended once it is done, or cut below what has already traded
+	if !order.state.isOpen() || req.volume.LessThanOrEqual(order.filled) {
+		req.reply <- marketResult{status: REPLACE_FAILED, order: &current}
+		return
+	}
+
+	if req.orderType != order.orderType {
+		refused := &orderRejection{enum.OrdRejReason_BROKER, "OrdType Can Not Be Changed"}
+		req.reply <- marketResult{status: REPLACE_FAILED_ORDER_TYPE, order: &current, rejection: refused}
+		return
+	}
+
+	if !book.phase.acceptsOrders() {
+		refused := &orderRejection{enum.OrdRejReason_EXCHANGE_CLOSED, "Market Is " + book.phase.String()}
+		req.reply <- marketResult{status: REPLACE_FAILED_PHASE, order: &current, rejection: refused}
+		return
+	}
+
+	var trades []Trade
+
+	requeue := book.replace(order, req.id, req.price, req.stopPx, req.volume)
+	replaced := *order
+
+	if requeue {
+		trades = book.enter(order)
+	}
+
+	journaled := replaced
+	journaled.seq = order.seq
+	journaled.shown = order.shown
+	m.record(book, JOURNAL_REPLACE, &journaled, req.origID)
+	m.recordTrades(book, trades)
+
+	fmt.Printf("Replaced Order (%s) With (%s) by %s, %d Trade(s) \n\r", req.origID, req.id, req.user, len(trades))
+
+	req.reply <- marketResult{status: REPLACE_REPLACED, order: &replaced, trades: trades, released: m.releaseStops(book)}
+}
+
+// fanOut hands each book its own share of a mass request, made by share,
+// returning the channel the books answer on.
+func fanOut(books []*symbolBook, share func(reply chan marketResult) any) chan marketResult {
+	results := make(chan marketResult, len(books))
+
+	for _, sb := range books {
+		sb.requests <- share(results)
+	}
+
+	return results
+}
+
+// gather waits for count books to answer a mass request, and returns the
+// orders they answer with, by symbol and then in the order they were
+// queued.
+func gather(results chan marketResult, count int) []SingleOrder {
+	var orders []SingleOrder
+	for range count {
+		res := <-results
+		orders = append(orders, res.orders...)
+	}
+
+	sort.SliceStable(orders, func(a, b int) bool { return orders[a].symbol < orders[b].symbol })
+
+	return orders
+}
+
+func (m *market) massCancel(req *massCancelRequest, results chan marketResult, count int) {
+	canceled := gather(results, count)
+
+	fmt.Printf("Mass Cancelled %d Order(s) For %s \n\r", len(canceled), req.user)
+
+	req.reply <- marketResult{status: MASS_CANCEL_CANCELLED, orders: canceled}
+}
+
+func (m *market) massStatus(req *massStatusRequest, results chan marketResult, count int) {
+	found := gather(results, count)
+
+	fmt.Printf("Mass Status Found %d Order(s) For %s \n\r", len(found), req.user)
+
+	req.reply <- marketResult{status: MASS_STATUS_FOUND, orders: found}
+}
+
+// cancelAll cancels the orders in book that req covers.
+func (m *market) cancelAll(book *orderBook, req *massCancelRequest) {
+	orders := book.inScope(req.orderScope)
+
+	canceled := make([]SingleOrder, 0, len(orders))
+	for _, order := range orders {
+		book.remove(order)
+		order.transition(ORDER_CANCELED)
+
+		m.record(book, JOURNAL_CANCEL, order, "")
+		canceled = append(canceled, *order)
+	}
+
+	req.reply <- marketResult{status: MASS_CANCEL_CANCELLED, orders: canceled}
+}
+
+// queryAll copies the orders in book that req covers.
+func (m *market) queryAll(book *orderBook, req *massStatusRequest) {
+	orders := book.inScope(req.orderScope)
+
+	found := make([]SingleOrder, 0, len(orders))
+	for _, order := range orders {
+		found = append(found, *order)
+	}
+
+	req.reply <- marketResult{status: MASS_STATUS_FOUND, orders: found}
+}
+
+func (m *market) query(book *orderBook, req *queryRequest) {
+	order, found := book.find(req.user, req.id)
+
+	if !found {
+		req.reply <- marketResult{status: QUERY_NO_SUCH_ORDER}
+		return
+	}
+
+	fmt.Printf("Queried Order (%s) by %s \n\r", req.id, req.user)
+	queried := *order
+	req.reply <- marketResult{status: QUERY_ORDER_FOUND, order: &queried}
+}
+
+// cancelUnrested cancels whatever is left of so once it has matched if it
+// is not allowed to rest, returning a copy of the cancelled order.
+func (m *market) cancelUnrested(book *orderBook, so *SingleOrder) *SingleOrder {
+	if so.pendingStop() || !so.remaining().IsPositive() || so.rests() {
+		return nil
+	}
+
+	so.transition(ORDER_CANCELED)
+	fmt.Printf("%s Order (%s) From %s Cancelled, %s Unfilled\n\r", so.timeInForce, so.id, so.user, so.volume.Sub(so.filled))
+
+	canceled := *so
+	m.record(book, JOURNAL_CANCEL, &canceled, "")
+	return &canceled
+}
+
+// releaseStops enters every stop order the last trade price has reached,
+// and then any stops that their trades reach in turn. Stops only trigger in
+// continuous trading.
+func (m *market) releaseStops(book *orderBook) []stopRelease {
+	var released []stopRelease
+
+	if book.phase != PHASE_CONTINUOUS {
+		return nil
+	}
+
+	for stops := book.triggered(); len(stops) > 0; stops = book.triggered() {
+		triggerPx := book.lastPx
+
+		for _, so := range stops {
+			triggered := *so
+
+			trades := book.enter(so)
+			fmt.Printf("Stop Order (%s) From %s Triggered At %s, %d Trade(s)\n\r", so.id, so.user, triggerPx, len(trades))
+
+			// entering gives the order its new place in the queue
+			triggered.seq = so.seq
+			m.record(book, JOURNAL_TRIGGER, &triggered, "")
+			m.recordTrades(book, trades)
+
+			released = append(released, stopRelease{
+				order:    triggered,
+				trades:   trades,
+				canceled: m.cancelUnrested(book, so),
+			})
+		}
+	}
+
+	return released
+}
+
+func (m *market) expire(book *orderBook, now time.Time) {
+	for _, order := range book.expire(now) {
+		order.transition(ORDER_EXPIRED)
+		fmt.Printf("Order (%s) From %s Expired \n\r", order.id, order.user)
+
+		expired := *order
+		m.record(book, JOURNAL_EXPIRE, &expired, "")
+		m.expiryChannel <- &expired
+	}
+}
+
+// publish sends book's depth and new trades to market data, if anything
+// has changed since it last did.
+func (m *market) publish(book *orderBook) {
+	bids, asks := book.depth(BUY), book.depth(SELL)
+
+	if len(book.prints) == 0 && sameLevels(bids, book.publishedBids) && sameLevels(asks, book.publishedAsks) {
+		return
+	}
+
+	update := &bookUpdate{symbol: book.symbol, bids: bids, asks: asks, trades: book.prints}
+	book.publishedBids, book.publishedAsks, book.prints = bids, asks, nil
+
+	m.bookChannel <- update
+}
+
+// restore puts the journal's open orders back into their books, in the
+// order they were originally queued, along with every ClOrdID already used.
+func (m *market) restore() {
+	orders, clOrdIDs, orderID, lastPrices := m.journal.restored()
+
+	// stops need to know where the market last traded to trigger
+	for symbol, lastPx := range lastPrices {
+		m.symbolBook(symbol).book.lastPx = lastPx
+	}
+
+	for _, used := range clOrdIDs {
+		m.symbolBook(used.Symbol).book.clOrdIDs[orderKey{used.User, used.ID}] = true
+	}
+
+	for _, so := range orders {
+		book := m.symbolBook(so.symbol).book
+		book.track(so)
+
+		if so.pendingStop() {
+			book.triggers.add(so)
+		} else {
+			// an iceberg is journaled as it was when it was last copied,
+			// which can be before it was first shown
+			if so.displayed().IsZero() {
+				so.replenish()
 			}
+			book.rest(so)
 		}
 
-		if !found {
-			m.respChannel <- CANCEL_NO_SUCH_ORDER
+		if so.seq > book.seq {
+			book.seq = so.seq
 		}
+	}
+
+	m.orderID.Store(orderID)
+	m.risk.restore(orders, lastPrices)
+}
+
+// record journals an event for so and keeps the risk checks' tally of open
+// orders and positions in step with it. Once so is done book forgets it.
+func (m *market) record(book *orderBook, eventType string, so *SingleOrder, origID string) {
+	m.journal.record(eventType, so, origID)
+	m.risk.track(eventType, so, origID)
+	book.retire(so)
+}
 
+func (m *market) recordTrades(book *orderBook, trades []Trade) {
+	for i := range trades {
+		m.record(book, JOURNAL_FILL, &trades[i].aggressor, "")
+		m.record(book, JOURNAL_FILL, &trades[i].passive, "")
 	}
 }
 
-func (m market) startMarket() {
-	m.orders = make(map[string][]SingleOrder)
-	go m.listenNSO()
-	go m.listenQuery()
-	go m.listenCancel()
+func (m *market) startMarket() {
+	m.books = make(map[string]*symbolBook)
+	m.restore()
+
+	// restored books have depth market data hasn't seen
+	for _, sb := range m.books {
+		sb.requests <- &publishRequest{}
+	}
+
+	go m.route()
 }
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// runningMarket is a market routing requests to its books, the way the
+// server runs it, with its market data thrown away.
+func runningMarket() *market {
+	m := &market{
+		nsoChannel:        make(chan *newOrderRequest),
+		cancelChannel:     make(chan *cancelRequest),
+		replaceChannel:    make(chan *replaceRequest),
+		queryChannel:      make(chan *queryRequest),
+		massCancelChannel: make(chan *massCancelRequest),
+		massStatusChannel: make(chan *massStatusRequest),
+		expiryChannel:     make(chan *SingleOrder),
+		bookChannel:       make(chan *bookUpdate),
+		phaseChannel:      make(chan TradingPhase),
+		closeTime:         24 * time.Hour,
+		books:             make(map[string]*symbolBook),
+	}
+
+	go func() {
+		for range m.bookChannel {
+		}
+	}()
+	go m.route()
+
+	return m
+}
+
+// placeOn sends so to m and waits for the answer.
+func placeOn(m *market, so *SingleOrder) marketResult {
+	so.state = ORDER_PENDING_NEW
+	so.timeInForce = TIF_GTC
+
+	req := &newOrderRequest{order: so, reply: make(chan marketResult, 1)}
+	m.nsoChannel <- req
+	return <-req.reply
+}
+
+// queryOn asks m for the order id in symbol and waits for the answer.
+func queryOn(m *market, symbol, id string) marketResult {
+	req := &queryRequest{user: "alice", symbol: symbol, id: id, reply: make(chan marketResult, 1)}
+	m.queryChannel <- req
+	return <-req.reply
+}
+
+// Many sessions placing orders at once must each get the answer to their
+// own order. Run with -race.
+func TestMarketRepliesToEachRequest(t *testing.T) {
+
+	m := runningMarket()
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			id := fmt.Sprintf("o%d", i)
+
+			// none of them cross, so each is still open to be queried
+			res := placeOn(m, testOrder(id, BUY, 100, 1))
+			if res.order == nil || res.order.id != id {
+				t.Errorf("order %s answered with %+v", id, res.order)
+			}
+
+			if res := queryOn(m, "AAPL", id); res.status != QUERY_ORDER_FOUND || res.order.id != id {
+				t.Errorf("query for %s answered with status %d, %+v", id, res.status, res.order)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// A mass cancel only covers the orders that were sent before it, even
+// though its answer is waited for away from the routing goroutine.
+func TestMassCancelOrderedWithLaterOrders(t *testing.T) {
+
+	m := runningMarket()
+
+	for _, symbol := range []string{"AAPL", "MSFT", "IBM"} {
+		so := testOrder("before", BUY, 99, 10)
+		so.symbol = symbol
+		placeOn(m, so)
+	}
+
+	for i := range 20 {
+		cancel := &massCancelRequest{orderScope: orderScope{user: "alice"}, reply: make(chan marketResult, 1)}
+		m.massCancelChannel <- cancel
+
+		after := testOrder(fmt.Sprintf("after%d", i), BUY, 99, 10)
+		after.symbol = []string{"AAPL", "MSFT", "IBM"}[i%3]
+		placeOn(m, after)
+
+		for _, so := range (<-cancel.reply).orders {
+			if so.id == after.id {
+				t.Fatalf("mass cancel %d cancelled %s, sent after it", i, so.id)
+			}
+		}
+
+		// next time round the mass cancel takes this one
+		if res := queryOn(m, after.symbol, after.id); res.order == nil || !res.order.state.isOpen() {
+			t.Fatalf("%s not left open by the mass cancel before it", after.id)
+		}
+	}
+}
+
+// Each symbol's book is worked by its own goroutine, so orders sent to
+// several symbols at once are matched within their own symbol, and one
+// book falling behind doesn't hold up the others. Run with -race.
+func TestMarketSymbolsIndependent(t *testing.T) {
+
+	m := runningMarket()
+
+	// a book whose goroutine never gets to its requests
+	stuck := &symbolBook{book: newOrderBook("STUCK"), requests: make(chan any, 64)}
+	m.books["STUCK"] = stuck
+
+	waiting := testOrder("stuck", BUY, 100, 10)
+	waiting.symbol = "STUCK"
+	m.nsoChannel <- &newOrderRequest{order: waiting, reply: make(chan marketResult, 1)}
+
+	symbols := []string{"AAPL", "MSFT", "IBM", "TSLA"}
+
+	var wg sync.WaitGroup
+	for _, symbol := range symbols {
+		for i := range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				side := Side(i % 2)
+				so := testOrder(fmt.Sprintf("%s-%d", symbol, i), side, 100, 5)
+				so.symbol = symbol
+
+				res := placeOn(m, so)
+				if res.order == nil || res.order.id != so.id || res.order.symbol != symbol {
+					t.Errorf("%s answered with %+v", so.id, res.order)
+				}
+				for _, trade := range res.trades {
+					if trade.aggressor.symbol != symbol || trade.passive.symbol != symbol {
+						t.Errorf("%s traded %s against %s", so.id, trade.aggressor.symbol, trade.passive.symbol)
+					}
+				}
+			}()
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("orders held up behind another symbol's book")
+	}
+
+	// ten buys and ten sells at the same price, so every book ends flat
+	for _, symbol := range symbols {
+		for i := range 20 {
+			id := fmt.Sprintf("%s-%d", symbol, i)
+			if res := queryOn(m, symbol, id); res.status == QUERY_ORDER_FOUND && res.order.state.isOpen() {
+				t.Errorf("%s still open, want every book flat", id)
+			}
+		}
+	}
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+	"github.com/shopspring/decimal"
+
+	fix42mdir "github.com/quickfixgo/fix42/marketdataincrementalrefresh"
+	fix42mdr "github.com/quickfixgo/fix42/marketdatarequest"
+	fix42mdrr "github.com/quickfixgo/fix42/marketdatarequestreject"
+	fix42mdsfr "github.com/quickfixgo/fix42/marketdatasnapshotfullrefresh"
+	fix43mdir "github.com/quickfixgo/fix43/marketdataincrementalrefresh"
+	fix43mdr "github.com/quickfixgo/fix43/marketdatarequest"
+	fix43mdrr "github.com/quickfixgo/fix43/marketdatarequestreject"
+	fix43mdsfr "github.com/quickfixgo/fix43/marketdatasnapshotfullrefresh"
+	fix44mdir "github.com/quickfixgo/fix44/marketdataincrementalrefresh"
+	fix44mdr "github.com/quickfixgo/fix44/marketdatarequest"
+	fix44mdrr "github.com/quickfixgo/fix44/marketdatarequestreject"
+	fix44mdsfr "github.com/quickfixgo/fix44/marketdatasnapshotfullrefresh"
+	fix50mdir "github.com/quickfixgo/fix50/marketdataincrementalrefresh"
+	fix50mdr "github.com/quickfixgo/fix50/marketdatarequest"
+	fix50mdrr "github.com/quickfixgo/fix50/marketdatarequestreject"
+	fix50mdsfr "github.com/quickfixgo/fix50/marketdatasnapshotfullrefresh"
+)
+
+// Market data publishes the bids, offers and trades of each symbol. The
+// server keeps its own copy of every book from the market's updates, so a
+// snapshot and the refreshes that follow it always line up. A subscription
+// is sent a MarketDataIncrementalRefresh for each change, naming each price
+// level in MDEntryID by its entry type and price, or a new snapshot if it
+// asked for full refreshes. MarketDataRequest only exists from FIX 4.2,
+// every version of it is read the same way.
+
+type marketDataRequest interface {
+	GetMDReqID() (string, quickfix.MessageRejectError)
+	GetSubscriptionRequestType() (enum.SubscriptionRequestType, quickfix.MessageRejectError)
+	GetMarketDepth() (int, quickfix.MessageRejectError)
+	GetMDUpdateType() (enum.MDUpdateType, quickfix.MessageRejectError)
+	GetGroup(quickfix.FieldGroupReader) quickfix.MessageRejectError
+}
+
+// mdRequestGroups are the repeating groups of one version's
+// MarketDataRequest, which the entry types and symbols are read into.
+type mdRequestGroups struct {
+	entryTypes *quickfix.RepeatingGroup
+	symbols    *quickfix.RepeatingGroup
+}
+
+func (e *Server) onFIX42MarketDataRequest(msg fix42mdr.MarketDataRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onMarketDataRequest(msg, mdRequestGroups{
+		fix42mdr.NewNoMDEntryTypesRepeatingGroup().RepeatingGroup,
+		fix42mdr.NewNoRelatedSymRepeatingGroup().RepeatingGroup,
+	}, sessionID)
+}
+
+func (e *Server) onFIX43MarketDataRequest(msg fix43mdr.MarketDataRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onMarketDataRequest(msg, mdRequestGroups{
+		fix43mdr.NewNoMDEntryTypesRepeatingGroup().RepeatingGroup,
+		fix43mdr.NewNoRelatedSymRepeatingGroup().RepeatingGroup,
+	}, sessionID)
+}
+
+func (e *Server) onFIX44MarketDataRequest(msg fix44mdr.MarketDataRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onMarketDataRequest(msg, mdRequestGroups{
+		fix44mdr.NewNoMDEntryTypesRepeatingGroup().RepeatingGroup,
+		fix44mdr.NewNoRelatedSymRepeatingGroup().RepeatingGroup,
+	}, sessionID)
+}
+
+func (e *Server) onFIX50MarketDataRequest(msg fix50mdr.MarketDataRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onMarketDataRequest(msg, mdRequestGroups{
+		fix50mdr.NewNoMDEntryTypesRepeatingGroup().RepeatingGroup,
+		fix50mdr.NewNoRelatedSymRepeatingGroup().RepeatingGroup,
+	}, sessionID)
+}
+
+// mdKey identifies a subscription by the session that made it and its
+// MDReqID.
+type mdKey struct {
+	sessionID quickfix.SessionID
+	reqID     string
+}
+
+// mdSubscription is what one MarketDataRequest asked for. A depth of zero
+// is the whole book.
+type mdSubscription struct {
+	mdKey
+	symbols     []string
+	depth       int
+	bids        bool
+	offers      bool
+	trades      bool
+	fullRefresh bool
+}
+
+func (sub *mdSubscription) covers(symbol string) bool {
+	for _, s := range sub.symbols {
+		if s == symbol {
+			return true
+		}
+	}
+	return false
+}
+
+// mdBook is market data's copy of one book.
+type mdBook struct {
+	bids      []level
+	asks      []level
+	lastTrade *Trade
+}
+
+// mdEntry is one entry of a snapshot or refresh. Deleted levels have no
+// size.
+type mdEntry struct {
+	action    enum.MDUpdateAction
+	entryType enum.MDEntryType
+	price     decimal.Decimal
+	size      decimal.Decimal
+}
+
+// marketData holds the books as last published and who is subscribed to
+// them. Snapshots are sent with mu held, so no refresh can overtake them.
+type marketData struct {
+	mu            sync.Mutex
+	books         map[string]*mdBook
+	subscriptions map[mdKey]*mdSubscription
+}
+
+func newMarketData() *marketData {
+	return &marketData{
+		books:         make(map[string]*mdBook),
+		subscriptions: make(map[mdKey]*mdSubscription),
+	}
+}
+
+// book returns market data's copy of symbol's book, empty if nothing has
+// been published for it yet.
+func (md *marketData) book(symbol string) *mdBook {
+	book, ok := md.books[symbol]
+	if !ok {
+		book = &mdBook{}
+		md.books[symbol] = book
+	}
+	return book
+}
+
+// unsubscribeAll drops every subscription made by sessionID.
+func (md *marketData) unsubscribeAll(sessionID quickfix.SessionID) {
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	for key := range md.subscriptions {
+		if key.sessionID == sessionID {
+			delete(md.subscriptions, key)
+		}
+	}
+}
+
+func (e *Server) onMarketDataRequest(msg marketDataRequest, groups mdRequestGroups, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+
+	reqID, err := msg.GetMDReqID()
+	if err != nil {
+		return err
+	}
+
+	subscription, err := msg.GetSubscriptionRequestType()
+	if err != nil {
+		return err
+	}
+
+	key := mdKey{sessionID, reqID}
+
+	if subscription == enum.SubscriptionRequestType_DISABLE_PREVIOUS_SNAPSHOT_PLUS_UPDATE_REQUEST {
+		e.md.mu.Lock()
+		_, found := e.md.subscriptions[key]
+		delete(e.md.subscriptions, key)
+		e.md.mu.Unlock()
+
+		if !found {
+			fmt.Printf("No Market Data Subscription (%s) To Cancel \n\r", reqID)
+		}
+		return nil
+	}
+
+	depth, err := msg.GetMarketDepth()
+	if err != nil {
+		return err
+	}
+
+	updateType, _ := msg.GetMDUpdateType()
+	sub := &mdSubscription{mdKey: key, depth: depth, fullRefresh: updateType == enum.MDUpdateType_FULL_REFRESH}
+
+	if err := msg.GetGroup(groups.entryTypes); err != nil {
+		return err
+	}
+
+	for i := 0; i < groups.entryTypes.Len(); i++ {
+		entryType, _ := groups.entryTypes.Get(i).GetString(tag.MDEntryType)
+
+		switch enum.MDEntryType(entryType) {
+		case enum.MDEntryType_BID:
+			sub.bids = true
+		case enum.MDEntryType_OFFER:
+			sub.offers = true
+		case enum.MDEntryType_TRADE:
+			sub.trades = true
+		default:
+			e.send(marketDataReject(reqID, enum.MDReqRejReason_UNSUPPORTED_MDENTRYTYPE, "Only Bids, Offers And Trades Are Published", sessionID), sessionID)
+			return nil
+		}
+	}
+
+	if err := msg.GetGroup(groups.symbols); err != nil {
+		return err
+	}
+
+	for i := 0; i < groups.symbols.Len(); i++ {
+		symbol, _ := groups.symbols.Get(i).GetString(tag.Symbol)
+
+		if _, ok := e.instruments.lookup(symbol); !ok {
+			e.send(marketDataReject(reqID, enum.MDReqRejReason_UNKNOWN_SYMBOL, "Unknown Symbol "+symbol, sessionID), sessionID)
+			return nil
+		}
+		sub.symbols = append(sub.symbols, symbol)
+	}
+
+	switch {
+	case subscription != enum.SubscriptionRequestType_SNAPSHOT && subscription != enum.SubscriptionRequestType_SNAPSHOT_PLUS_UPDATES:
+		e.send(marketDataReject(reqID, enum.MDReqRejReason_UNSUPPORTED_SUBSCRIPTIONREQUESTTYPE, "Unsupported SubscriptionRequestType", sessionID), sessionID)
+		return nil
+
+	case depth < 0:
+		e.send(marketDataReject(reqID, enum.MDReqRejReason_UNSUPPORTED_MARKETDEPTH, "MarketDepth Can Not Be Negative", sessionID), sessionID)
+		return nil
+	}
+
+	e.md.mu.Lock()
+	defer e.md.mu.Unlock()
+
+	if subscription == enum.SubscriptionRequestType_SNAPSHOT_PLUS_UPDATES {
+		if _, exists := e.md.subscriptions[key]; exists {
+			e.send(marketDataReject(reqID, enum.MDReqRejReason_DUPLICATE_MDREQID, "Already Subscribed With MDReqID "+reqID, sessionID), sessionID)
+			return nil
+		}
+
+		e.md.subscriptions[key] = sub
+		fmt.Printf("Market Data Subscription (%s) For %v \n\r", reqID, sub.symbols)
+	}
+
+	for _, symbol := range sub.symbols {
+		e.send(marketDataSnapshot(reqID, symbol, sub.snapshot(e.md.book(symbol)), e.instruments.priceScale(symbol), sessionID), sessionID)
+	}
+
+	return nil
+}
+
+// publishMarketData applies each update from the market to market data's
+// copy of the book, and sends every subscription to the symbol what it
+// asked to see of the change.
+func (e *Server) publishMarketData() {
+	for update := range e.bookChannel {
+		e.md.mu.Lock()
+
+		book := e.md.book(update.symbol)
+		was := *book
+
+		book.bids, book.asks = update.bids, update.asks
+		if n := len(update.trades); n > 0 {
+			book.lastTrade = &update.trades[n-1]
+		}
+
+		for _, sub := range e.md.subscriptions {
+			if !sub.covers(update.symbol) {
+				continue
+			}
+
+			if sub.fullRefresh {
+				e.send(marketDataSnapshot(sub.reqID, update.symbol, sub.snapshot(book), e.instruments.priceScale(update.symbol), sub.sessionID), sub.sessionID)
+				continue
+			}
+
+			if entries := sub.changes(&was, book, update.trades); len(entries) > 0 {
+				e.send(marketDataIncrement(sub.reqID, update.symbol, entries, e.instruments.priceScale(update.symbol), sub.sessionID), sub.sessionID)
+			}
+		}
+
+		e.md.mu.Unlock()
+	}
+}
+
+// snapshot is everything sub sees of book.
+func (sub *mdSubscription) snapshot(book *mdBook) []mdEntry {
+	var entries []mdEntry
+
+	if sub.bids {
+		for _, l := range top(book.bids, sub.depth) {
+			entries = append(entries, mdEntry{entryType: enum.MDEntryType_BID, price: l.price, size: l.volume})
+		}
+	}
+
+	if sub.offers {
+		for _, l := range top(book.asks, sub.depth) {
+			entries = append(entries, mdEntry{entryType: enum.MDEntryType_OFFER, price: l.price, size: l.volume})
+		}
+	}
+
+	if sub.trades && book.lastTrade != nil {
+		entries = append(entries, mdEntry{entryType: enum.MDEntryType_TRADE, price: book.lastTrade.price, size: book.lastTrade.volume})
+	}
+
+	return entries
+}
+
+// changes is what sub sees change between two copies of a book, and the
+// trades that happened in between.
+func (sub *mdSubscription) changes(was, now *mdBook, trades []Trade) []mdEntry {
+	var entries []mdEntry
+
+	if sub.bids {
+		entries = append(entries, levelChanges(enum.MDEntryType_BID, top(was.bids, sub.depth), top(now.bids, sub.depth))...)
+	}
+
+	if sub.offers {
+		entries = append(entries, levelChanges(enum.MDEntryType_OFFER, top(was.asks, sub.depth), top(now.asks, sub.depth))...)
+	}
+
+	if sub.trades {
+		for _, trade := range trades {
+			entries = append(entries, mdEntry{enum.MDUpdateAction_NEW, enum.MDEntryType_TRADE, trade.price, trade.volume})
+		}
+	}
+
+	return entries
+}
+
+// levelChanges lists the price levels that went, then those that are new
+// or changed size.
+func levelChanges(entryType enum.MDEntryType, was, now []level) []mdEntry {
+	var entries []mdEntry
+
+	for _, l := range was {
+		if _, ok := findLevel(now, l.price); !ok {
+			entries = append(entries, mdEntry{action: enum.MDUpdateAction_DELETE, entryType: entryType, price: l.price})
+		}
+	}
+
+	for _, l := range now {
+		before, ok := findLevel(was, l.price)
+		switch {
+		case !ok:
+			entries = append(entries, mdEntry{enum.MDUpdateAction_NEW, entryType, l.price, l.volume})
+		case !before.volume.Equal(l.volume):
+			entries = append(entries, mdEntry{enum.MDUpdateAction_CHANGE, entryType, l.price, l.volume})
+		}
+	}
+
+	return entries
+}
+
+func findLevel(levels []level, price decimal.Decimal) (level, bool) {
+	for _, l := range levels {
+		if l.price.Equal(price) {
+			return l, true
+		}
+	}
+	return level{}, false
+}
+
+// top is the best depth levels, or all of them if depth is zero.
+func top(levels []level, depth int) []level {
+	if depth == 0 || len(levels) <= depth {
+		return levels
+	}
+	return levels[:depth]
+}
+
+// mdEntryID names the price level an entry is for.
+func mdEntryID(entry mdEntry) string {
+	return string(entry.entryType) + "-" + entry.price.String()
+}
+
+// marketDataSnapshot builds a MarketDataSnapshotFullRefresh of symbol in
+// the FIX version spoken by sessionID.
+func marketDataSnapshot(reqID, symbol string, entries []mdEntry, scale int32, sessionID quickfix.SessionID) *quickfix.Message {
+
+	var msg *quickfix.Message
+
+	switch sessionID.BeginString {
+	case quickfix.BeginStringFIX42:
+		msg = fix42mdsfr.New(field.NewSymbol(symbol)).ToMessage()
+	case quickfix.BeginStringFIX43:
+		msg = fix43mdsfr.New().ToMessage()
+	case quickfix.BeginStringFIX44:
+		msg = fix44mdsfr.New().ToMessage()
+	default:
+		msg = fix50mdsfr.New().ToMessage()
+	}
+
+	msg.Body.Set(field.NewMDReqID(reqID))
+	msg.Body.Set(field.NewSymbol(symbol))
+
+	template := quickfix.GroupTemplate{
+		quickfix.GroupElement(tag.MDEntryType),
+		quickfix.GroupElement(tag.MDEntryPx),
+		quickfix.GroupElement(tag.MDEntrySize),
+		quickfix.GroupElement(tag.MDEntryID),
+	}
+
+	// snapshots only name their levels from FIX 5.0
+	withID := sessionID.BeginString == quickfix.BeginStringFIXT11
+
+	group := quickfix.NewRepeatingGroup(tag.NoMDEntries, template)
+	for _, entry := range entries {
+		setMDEntryFields(&group.Add().FieldMap, entry, withID, scale)
+	}
+	msg.Body.SetGroup(group)
+
+	return msg
+}
+
+// marketDataIncrement builds a MarketDataIncrementalRefresh of symbol in
+// the FIX version spoken by sessionID.
+func marketDataIncrement(reqID, symbol string, entries []mdEntry, scale int32, sessionID quickfix.SessionID) *quickfix.Message {
+
+	var msg *quickfix.Message
+
+	switch sessionID.BeginString {
+	case quickfix.BeginStringFIX42:
+		msg = fix42mdir.New().ToMessage()
+	case quickfix.BeginStringFIX43:
+		msg = fix43mdir.New().ToMessage()
+	case quickfix.BeginStringFIX44:
+		msg = fix44mdir.New().ToMessage()
+	default:
+		msg = fix50mdir.New().ToMessage()
+	}
+
+	msg.Body.Set(field.NewMDReqID(reqID))
+
+	template := quickfix.GroupTemplate{
+		quickfix.GroupElement(tag.MDUpdateAction),
+		quickfix.GroupElement(tag.MDEntryType),
+		quickfix.GroupElement(tag.MDEntryID),
+		quickfix.GroupElement(tag.Symbol),
+		quickfix.GroupElement(tag.MDEntryPx),
+		quickfix.GroupElement(tag.MDEntrySize),
+	}
+
+	group := quickfix.NewRepeatingGroup(tag.NoMDEntries, template)
+	for _, entry := range entries {
+		fields := &group.Add().FieldMap
+		fields.Set(field.NewMDUpdateAction(entry.action))
+		fields.Set(field.NewSymbol(symbol))
+		setMDEntryFields(fields, entry, true, scale)
+	}
+	msg.Body.SetGroup(group)
+
+	return msg
+}
+
+// setMDEntryFields describes entry in fields, naming its level if withID
+// is set. Prices are given scale decimal places, sizes as many as they need.
+func setMDEntryFields(fields *quickfix.FieldMap, entry mdEntry, withID bool, scale int32) {
+	fields.Set(field.NewMDEntryType(entry.entryType))
+	fields.Set(field.NewMDEntryPx(entry.price, scale))
+
+	// trades are not levels, and a deleted level has no size
+	if withID && entry.entryType != enum.MDEntryType_TRADE {
+		fields.Set(field.NewMDEntryID(mdEntryID(entry)))
+	}
+	if entry.action != enum.MDUpdateAction_DELETE {
+		fields.Set(field.NewMDEntrySize(entry.size, decimalPlaces(entry.size)))
+	}
+}
+
+// marketDataReject turns down a MarketDataRequest in the FIX version spoken
+// by sessionID.
+func marketDataReject(reqID string, reason enum.MDReqRejReason, text string, sessionID quickfix.SessionID) *quickfix.Message {
+
+	mdReqID := field.NewMDReqID(reqID)
+
+	var msg *quickfix.Message
+
+	switch sessionID.BeginString {
+	case quickfix.BeginStringFIX42:
+		msg = fix42mdrr.New(mdReqID).ToMessage()
+	case quickfix.BeginStringFIX43:
+		msg = fix43mdrr.New(mdReqID).ToMessage()
+	case quickfix.BeginStringFIX44:
+		msg = fix44mdrr.New(mdReqID).ToMessage()
+	default:
+		msg = fix50mdrr.New(mdReqID).ToMessage()
+	}
+
+	msg.Body.Set(field.NewMDReqRejReason(reason))
+	msg.Body.Set(field.NewText(text))
+
+	fmt.Printf("Market Data Request (%s) Rejected, %s \n\r", reqID, text)
+
+	return msg
+}
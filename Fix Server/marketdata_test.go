@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+	"github.com/shopspring/decimal"
+)
+
+func levels(pairs ...int64) []level {
+	var ls []level
+	for i := 0; i+1 < len(pairs); i += 2 {
+		ls = append(ls, level{decimal.NewFromInt(pairs[i]), decimal.NewFromInt(pairs[i+1])})
+	}
+	return ls
+}
+
+// entriesOf writes entries as action, entry type, price and size. Snapshot
+// entries have no action.
+func entriesOf(entries []mdEntry) []string {
+	var got []string
+	for _, e := range entries {
+		got = append(got, strings.TrimSpace(fmt.Sprintf("%s %s %s %s", e.action, e.entryType, e.price, e.size)))
+	}
+	return got
+}
+
+func levelsOf(ls []level) []string {
+	var got []string
+	for _, l := range ls {
+		got = append(got, fmt.Sprintf("%s x %s", l.volume, l.price))
+	}
+	return got
+}
+
+func TestOrderBookDepth(t *testing.T) {
+
+	book := newOrderBook("AAPL")
+	book.add(testOrder("s1", SELL, 101, 10))
+	book.add(icebergOrder("s2", SELL, 101, 50, 5))
+	book.add(testOrder("s3", SELL, 102, 7))
+	book.add(testOrder("b1", BUY, 100, 4))
+	book.add(testOrder("b2", BUY, 99, 6))
+	book.add(testOrder("b3", BUY, 100, 1))
+
+	// the iceberg only counts for what it shows
+	if got, want := levelsOf(book.depth(SELL)), []string{"15 x 101", "7 x 102"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("asks = %v, want %v", got, want)
+	}
+	if got, want := levelsOf(book.depth(BUY)), []string{"5 x 100", "6 x 99"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("bids = %v, want %v", got, want)
+	}
+}
+
+func TestMarketDataSnapshot(t *testing.T) {
+
+	book := &mdBook{
+		bids:      levels(100, 5, 99, 6, 98, 7),
+		asks:      levels(101, 15, 102, 7),
+		lastTrade: &Trade{price: decimal.NewFromInt(100), volume: decimal.NewFromInt(3)},
+	}
+
+	tests := []struct {
+		name string
+		sub  mdSubscription
+		want []string
+	}{
+		{"whole book", mdSubscription{bids: true, offers: true, trades: true}, []string{
+			"0 100 5", "0 99 6", "0 98 7", "1 101 15", "1 102 7", "2 100 3"}},
+		{"top of book", mdSubscription{depth: 1, bids: true, offers: true}, []string{"0 100 5", "1 101 15"}},
+		{"trades only", mdSubscription{trades: true}, []string{"2 100 3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := entriesOf(tt.sub.snapshot(book)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("snapshot = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarketDataChanges(t *testing.T) {
+
+	was := &mdBook{bids: levels(100, 5, 99, 6), asks: levels(101, 15, 102, 7)}
+	now := &mdBook{bids: levels(100, 2, 99, 6), asks: levels(102, 7, 103, 4)}
+	trades := []Trade{{price: decimal.NewFromInt(101), volume: decimal.NewFromInt(15)}, {price: decimal.NewFromInt(100), volume: decimal.NewFromInt(3)}}
+
+	tests := []struct {
+		name string
+		sub  mdSubscription
+		want []string
+	}{
+		{"everything", mdSubscription{bids: true, offers: true, trades: true}, []string{
+			"1 0 100 2", "2 1 101 0", "0 1 103 4", "0 2 101 15", "0 2 100 3"}},
+		{"top of book", mdSubscription{depth: 1, bids: true, offers: true}, []string{
+			"1 0 100 2", "2 1 101 0", "0 1 102 7"}},
+		{"offers only", mdSubscription{offers: true}, []string{"2 1 101 0", "0 1 103 4"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := entriesOf(tt.sub.changes(was, now, trades)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changes = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Prices go out at the instrument's tick size, sizes as they are.
+func TestMarketDataIncrementScale(t *testing.T) {
+
+	entries := []mdEntry{
+		{enum.MDUpdateAction_NEW, enum.MDEntryType_BID, decimal.RequireFromString("100.5"), decimal.NewFromInt(10)},
+		{enum.MDUpdateAction_NEW, enum.MDEntryType_TRADE, decimal.RequireFromString("100.25"), decimal.RequireFromString("2.5")},
+	}
+
+	scale := testInstruments().priceScale("AAPL")
+	msg := marketDataIncrement("md1", "AAPL", entries, scale, quickfix.SessionID{BeginString: quickfix.BeginStringFIXT11})
+
+	group := quickfix.NewRepeatingGroup(tag.NoMDEntries, quickfix.GroupTemplate{
+		quickfix.GroupElement(tag.MDUpdateAction),
+		quickfix.GroupElement(tag.MDEntryType),
+		quickfix.GroupElement(tag.MDEntryID),
+		quickfix.GroupElement(tag.Symbol),
+		quickfix.GroupElement(tag.MDEntryPx),
+		quickfix.GroupElement(tag.MDEntrySize),
+	})
+	if err := msg.Body.GetGroup(group); err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][2]string{{"100.50", "10"}, {"100.25", "2.5"}}
+	if group.Len() != len(want) {
+		t.Fatalf("got %d entries, want %d", group.Len(), len(want))
+	}
+	for i, w := range want {
+		px, _ := group.Get(i).GetString(tag.MDEntryPx)
+		size, _ := group.Get(i).GetString(tag.MDEntrySize)
+		if px != w[0] || size != w[1] {
+			t.Errorf("entry %d = %s x %s, want %s x %s", i, size, px, w[1], w[0])
+		}
+	}
+}
+
+func TestPriceScale(t *testing.T) {
+
+	tests := []struct {
+		tickSize string
+		want     int32
+	}{
+		{"1", 0},
+		{"0.5", 1},
+		{"0.05", 2},
+		{"0.0025", 4},
+		{"0", 2},
+	}
+
+	for _, tt := range tests {
+		im := &instrumentMaster{bySymbol: map[string]instrument{"AAPL": {Symbol: "AAPL", TickSize: decimal.RequireFromString(tt.tickSize)}}}
+		if got := im.priceScale("AAPL"); got != tt.want {
+			t.Errorf("priceScale with tick size %s = %d, want %d", tt.tickSize, got, tt.want)
+		}
+	}
+
+	// without an instrument master there is no tick size to go by
+	if got := (*instrumentMaster)(nil).priceScale("AAPL"); got != 2 {
+		t.Errorf("priceScale without instruments = %d, want 2", got)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/quickfix"
+
+	fix43omcr "github.com/quickfixgo/fix43/ordermasscancelreport"
+	fix43omc "github.com/quickfixgo/fix43/ordermasscancelrequest"
+	fix44omcr "github.com/quickfixgo/fix44/ordermasscancelreport"
+	fix44omc "github.com/quickfixgo/fix44/ordermasscancelrequest"
+	fix50omcr "github.com/quickfixgo/fix50/ordermasscancelreport"
+	fix50omc "github.com/quickfixgo/fix50/ordermasscancelrequest"
+)
+
+// OrderMassCancelRequest only exists from FIX 4.3, every version of it is
+// read the same way.
+
+type orderMassCancel interface {
+	GetSenderSubID() (string, quickfix.MessageRejectError)
+	GetClOrdID() (string, quickfix.MessageRejectError)
+	GetMassCancelRequestType() (enum.MassCancelRequestType, quickfix.MessageRejectError)
+	GetSymbol() (string, quickfix.MessageRejectError)
+	HasSide() bool
+	GetSide() (enum.Side, quickfix.MessageRejectError)
+}
+
+func (e *Server) onFIX43OrderMassCancelRequest(msg fix43omc.OrderMassCancelRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onOrderMassCancelRequest(msg, sessionID)
+}
+
+func (e *Server) onFIX44OrderMassCancelRequest(msg fix44omc.OrderMassCancelRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onOrderMassCancelRequest(msg, sessionID)
+}
+
+func (e *Server) onFIX50OrderMassCancelRequest(msg fix50omc.OrderMassCancelRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onOrderMassCancelRequest(msg, sessionID)
+}
+
+// massCancelReply is what the report needs to know about the request it
+// answers.
+type massCancelReply struct {
+	user        string
+	id          string
+	requestType enum.MassCancelRequestType
+	symbol      string
+}
+
+func (e *Server) onOrderMassCancelRequest(msg orderMassCancel, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+
+	req := &massCancelRequest{}
+	reply := massCancelReply{}
+	var err quickfix.MessageRejectError
+
+	if req.user, err = msg.GetSenderSubID(); err != nil {
+		return err
+	}
+	reply.user = req.user
+
+	// SenderSubIDs are only unique within a firm
+	req.compID = sessionID.TargetCompID
+
+	if reply.id, err = msg.GetClOrdID(); err != nil {
+		return err
+	}
+
+	if reply.requestType, err = msg.GetMassCancelRequestType(); err != nil {
+		return err
+	}
+
+	if msg.HasSide() {
+		side, _ := msg.GetSide()
+		req.side = sideFromFix(side)
+		req.bySide = true
+	}
+
+	switch reply.requestType {
+	case enum.MassCancelRequestType_CANCEL_ALL_ORDERS:
+
+	case enum.MassCancelRequestType_CANCEL_ORDERS_FOR_A_SECURITY:
+		req.symbol, _ = msg.GetSymbol()
+		reply.symbol = req.symbol
+
+		if req.symbol == "" {
+			report := e.massCancelReport(reply, enum.MassCancelResponse_CANCEL_REQUEST_REJECTED, 0, sessionID)
+			report.Body.Set(field.NewMassCancelRejectReason(enum.MassCancelRejectReason_INVALID_OR_UNKNOWN_SECURITY))
+			report.Body.Set(field.NewText("Symbol Required To Cancel Orders For A Security"))
+
+			e.send(report, sessionID)
+			return nil
+		}
+
+	default:
+		report := e.massCancelReport(reply, enum.MassCancelResponse_CANCEL_REQUEST_REJECTED, 0, sessionID)
+		report.Body.Set(field.NewMassCancelRejectReason(enum.MassCancelRejectReason_MASS_CANCEL_NOT_SUPPORTED))
+		report.Body.Set(field.NewText("Only Cancelling By Security Or All Orders Is Supported"))
+
+		e.send(report, sessionID)
+		return nil
+	}
+
+	e.massCancel(req, reply, sessionID)
+	return nil
+}
+
+// massCancel cancels every order req covers, answering with an
+// OrderMassCancelReport followed by a Canceled ExecutionReport for each
+// order, sent to the session that entered it.
+func (e *Server) massCancel(req *massCancelRequest, reply massCancelReply, sessionID quickfix.SessionID) {
+
+	req.reply = make(chan marketResult, 1)
+	e.massCancelChannel <- req
+
+	res := <-req.reply
+
+	// the response echoes the scope that was asked for
+	report := e.massCancelReport(reply, enum.MassCancelResponse(reply.requestType), len(res.orders), sessionID)
+	if req.bySide {
+		report.Body.Set(field.NewSide(req.side.toFix()))
+	}
+	e.send(report, sessionID)
+
+	for i := range res.orders {
+		order := &res.orders[i]
+
+		execReport := e.execReport(order, enum.ExecType_CANCELED, order.sessionID)
+		execReport.Body.Set(field.NewText("Order has Been Cancelled By Mass Cancel " + reply.id))
+
+		e.send(execReport, order.sessionID)
+	}
+}
+
+// massCancelReport builds an OrderMassCancelReport in the FIX version
+// spoken by sessionID.
+func (e *Server) massCancelReport(reply massCancelReply, response enum.MassCancelResponse, affected int, sessionID quickfix.SessionID) *quickfix.Message {
+
+	// the report needs an id of its own, exec IDs are already unique
+	orderID := field.NewOrderID("MC" + e.genExecID().Value())
+	requestType := field.NewMassCancelRequestType(reply.requestType)
+	massCancelResponse := field.NewMassCancelResponse(response)
+
+	var msg *quickfix.Message
+
+	switch sessionID.BeginString {
+	case quickfix.BeginStringFIX43:
+		msg = fix43omcr.New(orderID, requestType, massCancelResponse).ToMessage()
+	case quickfix.BeginStringFIX44:
+		msg = fix44omcr.New(orderID, requestType, massCancelResponse).ToMessage()
+	default:
+		msg = fix50omcr.New(orderID, requestType, massCancelResponse).ToMessage()
+	}
+
+	msg.Header.Set(field.NewTargetSubID(reply.user))
+	msg.Body.Set(field.NewClOrdID(reply.id))
+	msg.Body.Set(field.NewTotalAffectedOrders(affected))
+
+	if reply.symbol != "" {
+		msg.Body.Set(field.NewSymbol(reply.symbol))
+	}
+
+	return msg
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/quickfix"
+
+	fix44omc "github.com/quickfixgo/fix44/ordermasscancelrequest"
+)
+
+// scopedMarket is a market with AAPL and MSFT books, each holding a buy and
+// a sell for alice and for bob, named by user, symbol and side.
+func scopedMarket() *market {
+	m := &market{books: make(map[string]*symbolBook)}
+
+	for _, symbol := range []string{"AAPL", "MSFT"} {
+		book := newOrderBook(symbol)
+		for _, user := range []string{"alice", "bob"} {
+			for _, side := range []Side{BUY, SELL} {
+				price := int64(99)
+				id := user + "-" + symbol + "-buy"
+				if side == SELL {
+					price, id = 101, user+"-"+symbol+"-sell"
+				}
+				so := testOrder(id, side, price, 10)
+				so.user, so.symbol = user, symbol
+				book.add(so)
+			}
+		}
+		m.books[symbol] = &symbolBook{book: book}
+	}
+
+	return m
+}
+
+func TestMassCancelScope(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		scope    orderScope
+		canceled []string
+	}{
+		{"every symbol", orderScope{user: "alice"}, []string{"alice-AAPL-buy", "alice-AAPL-sell", "alice-MSFT-buy", "alice-MSFT-sell"}},
+		{"one symbol", orderScope{user: "alice", symbol: "MSFT"}, []string{"alice-MSFT-buy", "alice-MSFT-sell"}},
+		{"one side", orderScope{user: "bob", symbol: "AAPL", side: SELL, bySide: true}, []string{"bob-AAPL-sell"}},
+		{"symbol without a book", orderScope{user: "alice", symbol: "IBM"}, nil},
+		{"user without orders", orderScope{user: "carol"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := scopedMarket()
+
+			var canceled []string
+			for _, sb := range m.booksFor(tt.scope.symbol) {
+				reply := make(chan marketResult, 1)
+				m.cancelAll(sb.book, &massCancelRequest{tt.scope, reply})
+
+				for _, so := range (<-reply).orders {
+					if so.state != ORDER_CANCELED {
+						t.Errorf("%s is %s, want canceled", so.id, so.state)
+					}
+					canceled = append(canceled, so.id)
+				}
+			}
+			sort.Strings(canceled)
+
+			if !reflect.DeepEqual(canceled, tt.canceled) {
+				t.Errorf("canceled = %v, want %v", canceled, tt.canceled)
+			}
+
+			// whatever was cancelled is gone from its book, the rest is left
+			resting := 0
+			for _, sb := range m.books {
+				resting += len(sb.book.bids) + len(sb.book.asks)
+			}
+			if want := 8 - len(tt.canceled); resting != want {
+				t.Errorf("%d orders left resting, want %d", resting, want)
+			}
+		})
+	}
+}
+
+// firmBook is an AAPL book holding an order from trader T1 at each of two
+// firms, named after the firm.
+func firmBook(firms ...quickfix.SessionID) *orderBook {
+	book := newOrderBook("AAPL")
+
+	for _, sessionID := range firms {
+		so := testOrder(sessionID.TargetCompID, BUY, 99, 10)
+		so.user, so.sessionID = "T1", sessionID
+		book.add(so)
+	}
+
+	return book
+}
+
+func TestMassCancelScopedToFirm(t *testing.T) {
+
+	firmA := quickfix.SessionID{BeginString: quickfix.BeginStringFIX44, SenderCompID: "EXCHANGE", TargetCompID: "FIRM_A"}
+	firmB := quickfix.SessionID{BeginString: quickfix.BeginStringFIX44, SenderCompID: "EXCHANGE", TargetCompID: "FIRM_B"}
+
+	e := newServer()
+	m := &market{}
+	book := firmBook(firmA, firmB)
+
+	canceled := make(chan []SingleOrder, 1)
+	go func() {
+		req := <-e.massCancelChannel
+		reply := make(chan marketResult, 1)
+		m.cancelAll(book, &massCancelRequest{req.orderScope, reply})
+
+		res := <-reply
+		canceled <- res.orders
+		req.reply <- res
+	}()
+
+	msg := fix44omc.New(field.NewClOrdID("mc1"), field.NewMassCancelRequestType(enum.MassCancelRequestType_CANCEL_ALL_ORDERS), field.NewTransactTime(time.Now()))
+	msg.Header.Set(field.NewSenderSubID("T1"))
+
+	if err := e.onOrderMassCancelRequest(msg, firmA); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, so := range <-canceled {
+		ids = append(ids, so.id)
+	}
+	if !reflect.DeepEqual(ids, []string{"FIRM_A"}) {
+		t.Errorf("canceled = %v, want only FIRM_A's order", ids)
+	}
+
+	if so, _ := book.find("T1", "FIRM_B"); !so.state.isOpen() {
+		t.Errorf("FIRM_B's order is %s, want it left open", so.state)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+
+	fix43omsr "github.com/quickfixgo/fix43/ordermassstatusrequest"
+	fix44omsr "github.com/quickfixgo/fix44/ordermassstatusrequest"
+	fix50omsr "github.com/quickfixgo/fix50/ordermassstatusrequest"
+)
+
+// OrderMassStatusRequest only exists from FIX 4.3, every version of it is
+// read the same way.
+
+type orderMassStatus interface {
+	GetSenderSubID() (string, quickfix.MessageRejectError)
+	GetMassStatusReqID() (string, quickfix.MessageRejectError)
+	GetMassStatusReqType() (enum.MassStatusReqType, quickfix.MessageRejectError)
+	GetSymbol() (string, quickfix.MessageRejectError)
+	HasSide() bool
+	GetSide() (enum.Side, quickfix.MessageRejectError)
+}
+
+func (e *Server) onFIX43OrderMassStatusRequest(msg fix43omsr.OrderMassStatusRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onOrderMassStatusRequest(msg, sessionID)
+}
+
+func (e *Server) onFIX44OrderMassStatusRequest(msg fix44omsr.OrderMassStatusRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onOrderMassStatusRequest(msg, sessionID)
+}
+
+func (e *Server) onFIX50OrderMassStatusRequest(msg fix50omsr.OrderMassStatusRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onOrderMassStatusRequest(msg, sessionID)
+}
+
+func (e *Server) onOrderMassStatusRequest(msg orderMassStatus, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+
+	req := &massStatusRequest{}
+	var err quickfix.MessageRejectError
+
+	if req.user, err = msg.GetSenderSubID(); err != nil {
+		return err
+	}
+
+	// SenderSubIDs are only unique within a firm
+	req.compID = sessionID.TargetCompID
+
+	reqID, err := msg.GetMassStatusReqID()
+	if err != nil {
+		return err
+	}
+
+	reqType, err := msg.GetMassStatusReqType()
+	if err != nil {
+		return err
+	}
+
+	switch reqType {
+	// every request is already limited to the sender's own orders
+	case enum.MassStatusReqType_STATUS_FOR_ALL_ORDERS, enum.MassStatusReqType_STATUS_FOR_ORDERS_FOR_A_PARTYID:
+
+	case enum.MassStatusReqType_STATUS_FOR_ORDERS_FOR_A_SECURITY:
+		if req.symbol, err = msg.GetSymbol(); err != nil {
+			return quickfix.ConditionallyRequiredFieldMissing(tag.Symbol)
+		}
+
+	default:
+		return quickfix.ValueIsIncorrect(tag.MassStatusReqType)
+	}
+
+	if msg.HasSide() {
+		side, _ := msg.GetSide()
+		req.side = sideFromFix(side)
+		req.bySide = true
+	}
+
+	e.massStatus(req, reqID, sessionID)
+	return nil
+}
+
+// massStatus answers with an order status ExecutionReport for every order
+// req covers, numbered with TotNumReports and the last marked with
+// LastRptRequested. With no orders a single Rejected report says so.
+func (e *Server) massStatus(req *massStatusRequest, reqID string, sessionID quickfix.SessionID) {
+
+	req.reply = make(chan marketResult, 1)
+	e.massStatusChannel <- req
+
+	res := <-req.reply
+
+	if len(res.orders) == 0 {
+		symbol := req.symbol
+		if symbol == "" {
+			symbol = "NONE"
+		}
+
+		order := unknownOrder(req.user, symbol, "NONE", req.side)
+
+		execReport := e.execReport(order, enum.ExecType_ORDER_STATUS, sessionID)
+		execReport.Body.Set(field.NewText("No Open Orders"))
+		setMassStatusFields(execReport, reqID, 0, true, sessionID)
+
+		e.send(execReport, sessionID)
+		return
+	}
+
+	for i := range res.orders {
+		execReport := e.execReport(&res.orders[i], enum.ExecType_ORDER_STATUS, sessionID)
+		setMassStatusFields(execReport, reqID, len(res.orders), i == len(res.orders)-1, sessionID)
+
+		e.send(execReport, sessionID)
+	}
+}
+
+// setMassStatusFields ties a status report to the request it answers.
+// FIX 4.3 has no fields for it.
+func setMassStatusFields(execReport *quickfix.Message, reqID string, total int, last bool, sessionID quickfix.SessionID) {
+
+	if sessionID.BeginString == quickfix.BeginStringFIX43 {
+		return
+	}
+
+	execReport.Body.Set(field.NewMassStatusReqID(reqID))
+	execReport.Body.Set(field.NewTotNumReports(total))
+	execReport.Body.Set(field.NewLastRptRequested(last))
+}
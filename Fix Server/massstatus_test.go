@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/quickfix"
+
+	fix44omsr "github.com/quickfixgo/fix44/ordermassstatusrequest"
+)
+
+func TestMassStatusScope(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		scope orderScope
+		found []string
+	}{
+		{"every symbol", orderScope{user: "bob"}, []string{"bob-AAPL-buy", "bob-AAPL-sell", "bob-MSFT-buy", "bob-MSFT-sell"}},
+		{"one symbol", orderScope{user: "bob", symbol: "AAPL"}, []string{"bob-AAPL-buy", "bob-AAPL-sell"}},
+		{"one side", orderScope{user: "alice", side: BUY, bySide: true}, []string{"alice-AAPL-buy", "alice-MSFT-buy"}},
+		{"user without orders", orderScope{user: "carol"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := scopedMarket()
+
+			// fills alice's AAPL sell, and filled orders are no longer open
+			m.books["AAPL"].book.add(testOrder("alice-AAPL-fill", BUY, 101, 10))
+
+			var found []string
+			for _, sb := range m.booksFor(tt.scope.symbol) {
+				reply := make(chan marketResult, 1)
+				m.queryAll(sb.book, &massStatusRequest{tt.scope, reply})
+
+				for _, so := range (<-reply).orders {
+					found = append(found, so.id)
+				}
+			}
+
+			sort.Strings(found)
+			if !reflect.DeepEqual(found, tt.found) {
+				t.Errorf("found = %v, want %v", found, tt.found)
+			}
+		})
+	}
+}
+
+func TestMassStatusScopedToFirm(t *testing.T) {
+
+	firmA := quickfix.SessionID{BeginString: quickfix.BeginStringFIX44, SenderCompID: "EXCHANGE", TargetCompID: "FIRM_A"}
+	firmB := quickfix.SessionID{BeginString: quickfix.BeginStringFIX44, SenderCompID: "EXCHANGE", TargetCompID: "FIRM_B"}
+
+	e := newServer()
+	m := &market{}
+	book := firmBook(firmA, firmB)
+
+	found := make(chan []SingleOrder, 1)
+	go func() {
+		req := <-e.massStatusChannel
+		reply := make(chan marketResult, 1)
+		m.queryAll(book, &massStatusRequest{req.orderScope, reply})
+
+		res := <-reply
+		found <- res.orders
+		req.reply <- res
+	}()
+
+	msg := fix44omsr.New(field.NewMassStatusReqID("ms1"), field.NewMassStatusReqType(enum.MassStatusReqType_STATUS_FOR_ALL_ORDERS))
+	msg.Header.Set(field.NewSenderSubID("T1"))
+
+	if err := e.onOrderMassStatusRequest(msg, firmB); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, so := range <-found {
+		ids = append(ids, so.id)
+	}
+	if !reflect.DeepEqual(ids, []string{"FIRM_B"}) {
+		t.Errorf("found = %v, want only FIRM_B's order", ids)
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// orderKey identifies an order by the user that sent it and its ClOrdID.
+type orderKey struct {
+	user string
+	id   string
+}
+
+// Trade is a single match between an incoming (aggressor) order and an
+// order resting in the book. Both orders are copies taken straight after
+// the fill was applied.
+type Trade struct {
+	symbol    string
+	price     decimal.Decimal
+	volume    decimal.Decimal
+	aggressor SingleOrder
+	passive   SingleOrder
+}
+
+// orderBook is a continuous limit order book for one symbol.
+// Bids are kept highest price first and asks lowest price first, with
+// orders at the same price kept in the order they arrived (price-time priority).
+// Stop orders wait in triggers until the last trade price reaches them.
+type orderBook struct {
+	symbol   string
+	bids     []*SingleOrder
+	asks     []*SingleOrder
+	triggers triggerBook
+	lastPx   decimal.Decimal
+	seq      uint64
+
+	// how far past the best opposite price a market order may trade, as a
+	// fraction of that price. Zero lets it sweep the whole book.
+	protection decimal.Decimal
+
+	// the trading phase, which decides what orders the book takes, and
+	// during an auction where it was last said it would uncross
+	phase     TradingPhase
+	indicated auctionIndication
+
+	// the orders still open, and every ClOrdID the book has ever accepted,
+	// which is kept once its order is done so it is never taken twice
+	orders   map[orderKey]*SingleOrder
+	clOrdIDs map[orderKey]bool
+
+	// trades and depth not yet published as market data, and the depth
+	// that last was
+	prints        []Trade
+	publishedBids []level
+	publishedAsks []level
+}
+
+func newOrderBook(symbol string) *orderBook {
+	return &orderBook{
+		symbol:   symbol,
+		orders:   make(map[orderKey]*SingleOrder),
+		clOrdIDs: make(map[orderKey]bool),
+	}
+}
+
+// find returns the open order user knows as id.
+func (b *orderBook) find(user, id string) (*SingleOrder, bool) {
+	so, ok := b.orders[orderKey{user, id}]
+	return so, ok
+}
+
+// taken reports whether user has already used id for an order, open or not.
+func (b *orderBook) taken(user, id string) bool {
+	return b.clOrdIDs[orderKey{user, id}]
+}
+
+// track makes so findable by its ClOrdID.
+func (b *orderBook) track(so *SingleOrder) {
+	b.orders[orderKey{so.user, so.id}] = so
+	b.clOrdIDs[orderKey{so.user, so.id}] = true
+}
+
+// retire forgets so once it is done, leaving only its ClOrdID behind.
+func (b *orderBook) retire(so *SingleOrder) {
+	if !so.state.isOpen() {
+		delete(b.orders, orderKey{so.user, so.id})
+	}
+}
+
+// add matches so against the opposite side of the book and rests whatever
+// is left over. The trades are returned in the order they happened. Stop
+// orders are put aside in the trigger book instead.
+func (b *orderBook) add(so *SingleOrder) []Trade {
+	b.track(so)
+
+	if so.pendingStop() {
+		b.seq++
+		so.seq = b.seq
+		b.triggers.add(so)
+		return nil
+	}
+
+	return b.enter(so)
+}
+
+// triggered takes every stop order the last trade price has reached out of
+// the trigger book. They are marked triggered, ready to be entered.
+func (b *orderBook) triggered() []*SingleOrder {
+	if b.lastPx.IsZero() {
+		return nil
+	}
+
+	released := b.triggers.release(b.lastPx)
+	for _, so := range released {
+		so.triggered = true
+	}
+
+	return released
+}
+
+// enter puts so at the back of the queue, trading whatever crosses and
+// resting the rest. A FOK order that can't be filled completely doesn't
+// trade at all, and neither it nor an IOC order rests; whatever is left of
+// them is for the caller to cancel. During an auction nothing trades until
+// the uncross.
+func (b *orderBook) enter(so *SingleOrder) []Trade {
+	b.seq++
+	so.seq = b.seq
+
+	if b.phase.isAuction() {
+		so.replenish()
+		b.rest(so)
+		return nil
+	}
+
+	if so.isMarket() {
+		so.protectPx = b.protectionPrice(so)
+	}
+
+	if so.timeInForce == TIF_FOK && b.available(so).LessThan(so.remaining()) {
+		return nil
+	}
+
+	trades := b.match(so)
+
+	if so.remaining().IsPositive() && so.rests() {
+		so.replenish()
+		b.rest(so)
+	}
+
+	return trades
+}
+
+// protectionPrice is the worst price a market order may trade at, which
+// is zero if it is not limited or there is nothing to trade against.
+func (b *orderBook) protectionPrice(so *SingleOrder) decimal.Decimal {
+	if b.protection.IsZero() {
+		return decimal.Zero
+	}
+
+	if so.side == BUY && len(b.asks) > 0 {
+		return b.asks[0].price.Mul(decimal.NewFromInt(1).Add(b.protection))
+	}
+
+	if so.side == SELL && len(b.bids) > 0 {
+		return b.bids[0].price.Mul(decimal.NewFromInt(1).Sub(b.protection))
+	}
+
+	return decimal.Zero
+}
+
+// available is how much of the opposite side so could trade against.
+func (b *orderBook) available(so *SingleOrder) decimal.Decimal {
+	opposite := b.bids
+	if so.side == BUY {
+		opposite = b.asks
+	}
+
+	total := decimal.Zero
+	for _, order := range opposite {
+		if !so.crosses(order.price) {
+			break
+		}
+		total = total.Add(order.remaining())
+	}
+
+	return total
+}
+
+func (b *orderBook) match(so *SingleOrder) []Trade {
+	var trades []Trade
+
+	opposite := &b.bids
+	if so.side == BUY {
+		opposite = &b.asks
+	}
+
+	for len(*opposite) > 0 && so.remaining().IsPositive() {
+		best := (*opposite)[0]
+
+		if !so.crosses(best.price) {
+			break
+		}
+
+		// nothing is ever traded at zero volume. An iceberg showing nothing
+		// goes to the back of the queue with its next slice.
+		if best.displayed().IsZero() {
+			*opposite = (*opposite)[1:]
+			if best.remaining().IsPositive() {
+				b.requeue(best)
+			}
+			continue
+		}
+
+		// trades always happen at the resting order's price, and only against
+		// what it is showing
+		volume := decimal.Min(so.remaining(), best.displayed())
+		so.fill(volume, best.price)
+		best.fill(volume, best.price)
+		if best.isIceberg() {
+			best.shown = best.shown.Sub(volume)
+		}
+		b.lastPx = best.price
+
+		if best.remaining().IsZero() {
+			*opposite = (*opposite)[1:]
+		} else if best.displayed().IsZero() {
+			// an iceberg shows its next slice at the back of the queue. It is
+			// re-queued before the copy is taken, so the fill is journaled
+			// with its new place.
+			*opposite = (*opposite)[1:]
+			b.requeue(best)
+		}
+
+		trades = append(trades, Trade{
+			symbol:    b.symbol,
+			price:     best.price,
+			volume:    volume,
+			aggressor: *so,
+			passive:   *best,
+		})
+		b.prints = append(b.prints, trades[len(trades)-1])
+	}
+
+	return trades
+}
+
+// replace amends a resting order and renames it to id. Reducing the
+// quantity keeps the order's place in the queue, any other change takes it
+// out of the book and returns true, after which it must be re-entered at
+// the back of the queue with enter.
+func (b *orderBook) replace(so *SingleOrder, id string, price, stopPx, volume decimal.Decimal) bool {
+	delete(b.orders, orderKey{so.user, so.id})
+	so.id = id
+	b.track(so)
+
+	keepPriority := price.Equal(so.price) && volume.LessThanOrEqual(so.volume)
+	so.volume = volume
+
+	// a stop that hasn't triggered yet has no place in the queue to lose,
+	// it just waits behind the other stops at its new StopPx
+	if so.pendingStop() && !stopPx.Equal(so.stopPx) {
+		b.triggers.remove(so)
+		so.stopPx = stopPx
+		b.triggers.add(so)
+	}
+
+	so.stopPx = stopPx
+
+	if keepPriority || so.pendingStop() {
+		so.price = price
+		so.shown = decimal.Min(so.shown, so.remaining())
+		return false
+	}
+
+	b.remove(so)
+	so.price = price
+	return true
+}
+
+// inScope returns the orders in the book that scope covers, oldest first.
+func (b *orderBook) inScope(scope orderScope) []*SingleOrder {
+	var orders []*SingleOrder
+
+	for _, order := range b.orders {
+		if scope.covers(order) {
+			orders = append(orders, order)
+		}
+	}
+
+	sort.Slice(orders, func(i, j int) bool { return orders[i].seq < orders[j].seq })
+
+	return orders
+}
+
+// expire takes every resting or waiting stop order whose time is up out of
+// the book and returns them.
+func (b *orderBook) expire(now time.Time) []*SingleOrder {
+	var expired []*SingleOrder
+
+	for _, side := range []*[]*SingleOrder{&b.bids, &b.asks, &b.triggers.buys, &b.triggers.sells} {
+		kept := (*side)[:0]
+		for _, order := range *side {
+			if order.expired(now) {
+				expired = append(expired, order)
+			} else {
+				kept = append(kept, order)
+			}
+		}
+		*side = kept
+	}
+
+	return expired
+}
+
+// level is the displayed volume at one price.
+type level struct {
+	price  decimal.Decimal
+	volume decimal.Decimal
+}
+
+// depth adds up what is displayed at each price on one side of the book,
+// best price first. Hidden iceberg quantity is left out.
+func (b *orderBook) depth(side Side) []level {
+	orders := b.asks
+	if side == BUY {
+		orders = b.bids
+	}
+
+	var levels []level
+	for _, order := range orders {
+		if n := len(levels); n > 0 && levels[n-1].price.Equal(order.price) {
+			levels[n-1].volume = levels[n-1].volume.Add(order.displayed())
+		} else {
+			levels = append(levels, level{order.price, order.displayed()})
+		}
+	}
+
+	return levels
+}
+
+// sameLevels reports whether two sides of depth are the same.
+func sameLevels(a, b []level) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].price.Equal(b[i].price) || !a[i].volume.Equal(b[i].volume) {
+			return false
+		}
+	}
+	return true
+}
+
+// requeue puts an iceberg at the back of the queue showing its next slice.
+func (b *orderBook) requeue(so *SingleOrder) {
+	b.seq++
+	so.seq = b.seq
+	so.replenish()
+	b.rest(so)
+}
+
+// rest inserts so behind every order at the same or a better price.
+func (b *orderBook) rest(so *SingleOrder) {
+	if so.side == BUY {
+		i := sort.Search(len(b.bids), func(i int) bool { return b.bids[i].price.LessThan(so.price) })
+		b.bids = insertAt(b.bids, i, so)
+	} else {
+		i := sort.Search(len(b.asks), func(i int) bool { return b.asks[i].price.GreaterThan(so.price) })
+		b.asks = insertAt(b.asks, i, so)
+	}
+}
+
+// remove takes a resting order out of the book. It returns false if the
+// order is not currently resting.
+func (b *orderBook) remove(so *SingleOrder) bool {
+	if so.pendingStop() {
+		return b.triggers.remove(so)
+	}
+
+	side := &b.asks
+	if so.side == BUY {
+		side = &b.bids
+	}
+
+	for i, order := range *side {
+		if order == so {
+			*side = append((*side)[:i], (*side)[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+func insertAt(orders []*SingleOrder, i int, so *SingleOrder) []*SingleOrder {
+	orders = append(orders, nil)
+	copy(orders[i+1:], orders[i:])
+	orders[i] = so
+	return orders
+}
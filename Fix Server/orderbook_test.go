@@ -0,0 +1,316 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func testOrder(id string, side Side, price, volume int64) *SingleOrder {
+	return &SingleOrder{
+		id:        id,
+		user:      "alice",
+		symbol:    "AAPL",
+		side:      side,
+		orderType: LIMIT,
+		price:     decimal.NewFromInt(price),
+		volume:    decimal.NewFromInt(volume),
+		state:     ORDER_NEW,
+	}
+}
+
+func icebergOrder(id string, side Side, price, volume, display int64) *SingleOrder {
+	so := testOrder(id, side, price, volume)
+	so.displayQty = decimal.NewFromInt(display)
+	return so
+}
+
+// testFill is a trade as the passive side saw it.
+type testFill struct {
+	passive string
+	price   int64
+	volume  int64
+}
+
+func fillsOf(trades []Trade) []testFill {
+	var fills []testFill
+	for _, trade := range trades {
+		fills = append(fills, testFill{trade.passive.id, trade.price.IntPart(), trade.volume.IntPart()})
+	}
+	return fills
+}
+
+func queueOf(orders []*SingleOrder) []string {
+	var ids []string
+	for _, so := range orders {
+		ids = append(ids, so.id)
+	}
+	return ids
+}
+
+func TestOrderBookMatch(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		resting   []*SingleOrder
+		incoming  *SingleOrder
+		fills     []testFill
+		remaining int64
+		state     OrderState
+		bids      []string
+		asks      []string
+	}{
+		{
+			name:      "best price first",
+			resting:   []*SingleOrder{testOrder("s1", SELL, 101, 10), testOrder("s2", SELL, 100, 10)},
+			incoming:  testOrder("b1", BUY, 101, 10),
+			fills:     []testFill{{"s2", 100, 10}},
+			remaining: 0,
+			state:     ORDER_FILLED,
+			asks:      []string{"s1"},
+		},
+		{
+			name:      "oldest first at the same price",
+			resting:   []*SingleOrder{testOrder("s1", SELL, 100, 10), testOrder("s2", SELL, 100, 10)},
+			incoming:  testOrder("b1", BUY, 100, 10),
+			fills:     []testFill{{"s1", 100, 10}},
+			remaining: 0,
+			state:     ORDER_FILLED,
+			asks:      []string{"s2"},
+		},
+		{
+			name:      "trades at the resting price",
+			resting:   []*SingleOrder{testOrder("b1", BUY, 102, 5)},
+			incoming:  testOrder("s1", SELL, 100, 5),
+			fills:     []testFill{{"b1", 102, 5}},
+			remaining: 0,
+			state:     ORDER_FILLED,
+		},
+		{
+			name:      "sweeps price levels",
+			resting:   []*SingleOrder{testOrder("s1", SELL, 100, 5), testOrder("s2", SELL, 101, 5)},
+			incoming:  testOrder("b1", BUY, 101, 8),
+			fills:     []testFill{{"s1", 100, 5}, {"s2", 101, 3}},
+			remaining: 0,
+			state:     ORDER_FILLED,
+			asks:      []string{"s2"},
+		},
+		{
+			name:      "partial fill rests the remainder",
+			resting:   []*SingleOrder{testOrder("s1", SELL, 100, 4)},
+			incoming:  testOrder("b1", BUY, 100, 10),
+			fills:     []testFill{{"s1", 100, 4}},
+			remaining: 6,
+			state:     ORDER_PARTIALLY_FILLED,
+			bids:      []string{"b1"},
+		},
+		{
+			name:      "partially filled resting order keeps its place",
+			resting:   []*SingleOrder{testOrder("s1", SELL, 100, 10), testOrder("s2", SELL, 100, 10)},
+			incoming:  testOrder("b1", BUY, 100, 4),
+			fills:     []testFill{{"s1", 100, 4}},
+			remaining: 0,
+			state:     ORDER_FILLED,
+			asks:      []string{"s1", "s2"},
+		},
+		{
+			name:      "nothing crosses",
+			resting:   []*SingleOrder{testOrder("s1", SELL, 101, 10)},
+			incoming:  testOrder("b1", BUY, 100, 10),
+			remaining: 10,
+			state:     ORDER_NEW,
+			bids:      []string{"b1"},
+			asks:      []string{"s1"},
+		},
+		{
+			name:      "iceberg only trades what it shows",
+			resting:   []*SingleOrder{icebergOrder("s1", SELL, 100, 10, 3), testOrder("s2", SELL, 100, 10)},
+			incoming:  testOrder("b1", BUY, 100, 5),
+			fills:     []testFill{{"s1", 100, 3}, {"s2", 100, 2}},
+			remaining: 0,
+			state:     ORDER_FILLED,
+			asks:      []string{"s2", "s1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := newOrderBook("AAPL")
+			for _, so := range tt.resting {
+				if trades := book.add(so); len(trades) > 0 {
+					t.Fatalf("resting order %s traded", so.id)
+				}
+			}
+
+			trades := book.add(tt.incoming)
+
+			if got := fillsOf(trades); !reflect.DeepEqual(got, tt.fills) {
+				t.Errorf("fills = %v, want %v", got, tt.fills)
+			}
+			if got := tt.incoming.remaining().IntPart(); got != tt.remaining {
+				t.Errorf("remaining = %d, want %d", got, tt.remaining)
+			}
+			if tt.incoming.state != tt.state {
+				t.Errorf("state = %s, want %s", tt.incoming.state, tt.state)
+			}
+			if got := queueOf(book.bids); !reflect.DeepEqual(got, tt.bids) {
+				t.Errorf("bids = %v, want %v", got, tt.bids)
+			}
+			if got := queueOf(book.asks); !reflect.DeepEqual(got, tt.asks) {
+				t.Errorf("asks = %v, want %v", got, tt.asks)
+			}
+		})
+	}
+}
+
+func TestOrderBookMarketProtection(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		protection string
+		side       Side
+		fills      []testFill
+		remaining  int64
+	}{
+		{"buy sweeps the book without protection", "0", BUY, []testFill{{"s1", 101, 5}, {"s2", 105, 5}, {"s3", 107, 5}}, 5},
+		{"buy stops at the protection limit", "0.05", BUY, []testFill{{"s1", 101, 5}, {"s2", 105, 5}}, 10},
+		{"sell sweeps the book without protection", "0", SELL, []testFill{{"b1", 100, 5}, {"b2", 96, 5}, {"b3", 94, 5}}, 5},
+		{"sell stops at the protection limit", "0.05", SELL, []testFill{{"b1", 100, 5}, {"b2", 96, 5}}, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := newOrderBook("AAPL")
+			book.protection = decimal.RequireFromString(tt.protection)
+			for _, so := range []*SingleOrder{
+				testOrder("s1", SELL, 101, 5), testOrder("s2", SELL, 105, 5), testOrder("s3", SELL, 107, 5),
+				testOrder("b1", BUY, 100, 5), testOrder("b2", BUY, 96, 5), testOrder("b3", BUY, 94, 5),
+			} {
+				book.add(so)
+			}
+
+			incoming := testOrder("m1", tt.side, 0, 20)
+			incoming.orderType = MARKET
+
+			trades := book.add(incoming)
+
+			if got := fillsOf(trades); !reflect.DeepEqual(got, tt.fills) {
+				t.Errorf("fills = %v, want %v", got, tt.fills)
+			}
+			if got := incoming.volume.Sub(incoming.filled).IntPart(); got != tt.remaining {
+				t.Errorf("unfilled = %d, want %d", got, tt.remaining)
+			}
+			for _, side := range [][]*SingleOrder{book.bids, book.asks} {
+				for _, so := range side {
+					if so == incoming {
+						t.Error("market order rested")
+					}
+				}
+			}
+		})
+	}
+}
+
+// A refilled iceberg slice goes to the back of the queue, and the copy of it
+// in the trade, which is what gets journaled, has to say so.
+func TestOrderBookIcebergRefillSeq(t *testing.T) {
+
+	book := newOrderBook("AAPL")
+	iceberg := icebergOrder("s1", SELL, 100, 10, 3)
+	book.add(iceberg)
+	book.add(testOrder("s2", SELL, 100, 10))
+
+	trades := book.add(testOrder("b1", BUY, 100, 3))
+
+	if len(trades) != 1 {
+		t.Fatalf("got %d trades, want 1", len(trades))
+	}
+	if trades[0].passive.seq != iceberg.seq {
+		t.Errorf("trade has seq %d, refilled iceberg has %d", trades[0].passive.seq, iceberg.seq)
+	}
+	if !trades[0].passive.shown.Equal(decimal.NewFromInt(3)) {
+		t.Errorf("trade shows %s, want the refilled slice of 3", trades[0].passive.shown)
+	}
+}
+
+func TestOrderBookReplace(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		price   int64
+		volume  int64
+		requeue bool
+		asks    []string
+	}{
+		{"reduced quantity keeps priority", 100, 5, false, []string{"s1r", "s2"}},
+		{"same quantity keeps priority", 100, 10, false, []string{"s1r", "s2"}},
+		{"increased quantity loses priority", 100, 15, true, []string{"s2", "s1r"}},
+		{"new price loses priority", 101, 5, true, []string{"s2", "s1r"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := newOrderBook("AAPL")
+			s1 := testOrder("s1", SELL, 100, 10)
+			book.add(s1)
+			book.add(testOrder("s2", SELL, 100, 10))
+
+			requeue := book.replace(s1, "s1r", decimal.NewFromInt(tt.price), decimal.Zero, decimal.NewFromInt(tt.volume))
+			if requeue != tt.requeue {
+				t.Fatalf("requeue = %v, want %v", requeue, tt.requeue)
+			}
+			if requeue {
+				book.enter(s1)
+			}
+
+			if got := queueOf(book.asks); !reflect.DeepEqual(got, tt.asks) {
+				t.Errorf("asks = %v, want %v", got, tt.asks)
+			}
+			if _, ok := book.find("alice", "s1r"); !ok {
+				t.Error("replaced order not found by its new ClOrdID")
+			}
+			if _, ok := book.find("alice", "s1"); ok {
+				t.Error("replaced order still found by its old ClOrdID")
+			}
+		})
+	}
+}
+
+// Reducing a partly filled order keeps its place and what is left of it.
+func TestOrderBookReplacePartiallyFilled(t *testing.T) {
+
+	book := newOrderBook("AAPL")
+	s1 := testOrder("s1", SELL, 100, 10)
+	book.add(s1)
+	book.add(testOrder("s2", SELL, 100, 10))
+	book.add(testOrder("b1", BUY, 100, 4))
+
+	if book.replace(s1, "s1r", decimal.NewFromInt(100), decimal.Zero, decimal.NewFromInt(8)) {
+		t.Fatal("reducing a partly filled order lost its priority")
+	}
+
+	if got := s1.remaining(); !got.Equal(decimal.NewFromInt(4)) {
+		t.Errorf("remaining = %s, want 4", got)
+	}
+	if got := queueOf(book.asks); !reflect.DeepEqual(got, []string{"s1r", "s2"}) {
+		t.Errorf("asks = %v, want [s1r s2]", got)
+	}
+}
+
+// An iceberg resting without its slice shown, as one restored from an old
+// journal could, is never traded at zero volume.
+func TestOrderBookIcebergShowingNothing(t *testing.T) {
+
+	book := newOrderBook("AAPL")
+	iceberg := icebergOrder("s1", SELL, 100, 10, 3)
+	book.rest(iceberg)
+	book.add(testOrder("s2", SELL, 100, 10))
+
+	trades := book.add(testOrder("b1", BUY, 100, 12))
+
+	want := []testFill{{"s2", 100, 10}, {"s1", 100, 2}}
+	if got := fillsOf(trades); !reflect.DeepEqual(got, want) {
+		t.Errorf("fills = %v, want %v", got, want)
+	}
+}
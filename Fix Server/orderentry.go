@@ -0,0 +1,225 @@
+package main
+
+import (
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+	"github.com/shopspring/decimal"
+)
+
+// The order entry messages of every FIX version share these getters, so
+// each one can be read into the same internal order model.
+
+type orderSingle interface {
+	GetSenderSubID() (string, quickfix.MessageRejectError)
+	GetClOrdID() (string, quickfix.MessageRejectError)
+	GetSymbol() (string, quickfix.MessageRejectError)
+	GetSide() (enum.Side, quickfix.MessageRejectError)
+	GetOrderQty() (decimal.Decimal, quickfix.MessageRejectError)
+	GetOrdType() (enum.OrdType, quickfix.MessageRejectError)
+	GetPrice() (decimal.Decimal, quickfix.MessageRejectError)
+	GetStopPx() (decimal.Decimal, quickfix.MessageRejectError)
+	GetMaxFloor() (decimal.Decimal, quickfix.MessageRejectError)
+	GetTimeInForce() (enum.TimeInForce, quickfix.MessageRejectError)
+	GetExpireTime() (time.Time, quickfix.MessageRejectError)
+}
+
+// FIX 5.0 orders give the displayed quantity of an iceberg as DisplayQty,
+// earlier versions only have MaxFloor.
+type displayQtyOrder interface {
+	GetDisplayQty() (decimal.Decimal, quickfix.MessageRejectError)
+}
+
+type orderCancel interface {
+	GetSenderSubID() (string, quickfix.MessageRejectError)
+	GetClOrdID() (string, quickfix.MessageRejectError)
+	GetOrigClOrdID() (string, quickfix.MessageRejectError)
+	GetSymbol() (string, quickfix.MessageRejectError)
+	GetSide() (enum.Side, quickfix.MessageRejectError)
+}
+
+type orderStatus interface {
+	GetSenderSubID() (string, quickfix.MessageRejectError)
+	GetClOrdID() (string, quickfix.MessageRejectError)
+	GetSymbol() (string, quickfix.MessageRejectError)
+	GetSide() (enum.Side, quickfix.MessageRejectError)
+}
+
+func (e *Server) onNewOrderSingle(msg orderSingle, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	order, err := newSingleOrder(msg, sessionID)
+	if err != nil {
+		return err
+	}
+
+	e.placeOrder(order, sessionID)
+	return nil
+}
+
+func (e *Server) onOrderCancelRequest(msg orderCancel, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	req, err := newCancelRequest(msg)
+	if err != nil {
+		return err
+	}
+
+	e.cancelOrder(req, sessionID)
+	return nil
+}
+
+func (e *Server) onOrderStatusRequest(msg orderStatus, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	req, err := newQueryRequest(msg)
+	if err != nil {
+		return err
+	}
+
+	e.queryOrder(req, "", sessionID)
+	return nil
+}
+
+// newSingleOrder reads a NewOrderSingle into a SingleOrder waiting to be
+// accepted by the market.
+func newSingleOrder(msg orderSingle, sessionID quickfix.SessionID) (so *SingleOrder, err quickfix.MessageRejectError) {
+
+	so = &SingleOrder{state: ORDER_PENDING_NEW, sessionID: sessionID}
+	ok := false
+
+	if so.user, err = msg.GetSenderSubID(); err != nil {
+		return
+	}
+
+	if so.id, err = msg.GetClOrdID(); err != nil {
+		return
+	}
+
+	if so.symbol, err = msg.GetSymbol(); err != nil {
+		return
+	}
+
+	side, err := msg.GetSide()
+	if err != nil {
+		return
+	}
+	so.side = sideFromFix(side)
+
+	if so.volume, err = msg.GetOrderQty(); err != nil {
+		return
+	}
+
+	if !so.volume.IsPositive() {
+		err = quickfix.ValueIsIncorrect(tag.OrderQty)
+		return
+	}
+
+	ot, _ := msg.GetOrdType()
+	so.orderType, ok = orderTypeFromFix(ot)
+	if !ok {
+		err = quickfix.ValueIsIncorrect(tag.OrdType)
+		return
+	}
+
+	// market and stop orders trade at whatever price they can get
+	if !so.isMarket() {
+		if so.price, err = msg.GetPrice(); err != nil {
+			return
+		}
+
+		if !so.price.IsPositive() {
+			err = quickfix.ValueIsIncorrect(tag.Price)
+			return
+		}
+	}
+
+	if so.orderType == STOP || so.orderType == STOP_LIMIT {
+		if so.stopPx, err = msg.GetStopPx(); err != nil {
+			err = quickfix.ConditionallyRequiredFieldMissing(tag.StopPx)
+			return
+		}
+
+		if !so.stopPx.IsPositive() {
+			err = quickfix.ValueIsIncorrect(tag.StopPx)
+			return
+		}
+	}
+
+	so.displayQty, _ = msg.GetMaxFloor()
+	if dq, isFix50 := msg.(displayQtyOrder); isFix50 {
+		if displayQty, dqErr := dq.GetDisplayQty(); dqErr == nil {
+			so.displayQty = displayQty
+		}
+	}
+
+	if so.displayQty.IsNegative() {
+		err = quickfix.ValueIsIncorrect(tag.MaxFloor)
+		return
+	}
+
+	// showing everything is just a normal order
+	if so.displayQty.GreaterThanOrEqual(so.volume) {
+		so.displayQty = decimal.Zero
+	}
+
+	tif, _ := msg.GetTimeInForce()
+	so.timeInForce, ok = timeInForceFromFix(tif)
+	if !ok {
+		err = quickfix.ValueIsIncorrect(tag.TimeInForce)
+		return
+	}
+
+	if so.timeInForce == TIF_GTD {
+		if so.expireTime, err = msg.GetExpireTime(); err != nil {
+			err = quickfix.ConditionallyRequiredFieldMissing(tag.ExpireTime)
+			return
+		}
+	}
+
+	return
+}
+
+func newCancelRequest(msg orderCancel) (req *cancelRequest, err quickfix.MessageRejectError) {
+
+	req = &cancelRequest{}
+
+	if req.user, err = msg.GetSenderSubID(); err != nil {
+		return
+	}
+
+	if req.id, err = msg.GetClOrdID(); err != nil {
+		return
+	}
+
+	if req.origID, err = msg.GetOrigClOrdID(); err != nil {
+		return
+	}
+
+	if req.symbol, err = msg.GetSymbol(); err != nil {
+		return
+	}
+
+	side, _ := msg.GetSide()
+	req.side = sideFromFix(side)
+
+	return
+}
+
+func newQueryRequest(msg orderStatus) (req *queryRequest, err quickfix.MessageRejectError) {
+
+	req = &queryRequest{}
+
+	if req.user, err = msg.GetSenderSubID(); err != nil {
+		return
+	}
+
+	if req.id, err = msg.GetClOrdID(); err != nil {
+		return
+	}
+
+	if req.symbol, err = msg.GetSymbol(); err != nil {
+		return
+	}
+
+	side, _ := msg.GetSide()
+	req.side = sideFromFix(side)
+
+	return
+}
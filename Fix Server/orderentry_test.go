@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+	"github.com/shopspring/decimal"
+
+	fix44nos "github.com/quickfixgo/fix44/newordersingle"
+)
+
+func TestNewSingleOrderRejectsNonPositiveValues(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		ordType enum.OrdType
+		qty     string
+		price   string
+		stopPx  string
+		badTag  quickfix.Tag
+	}{
+		{"limit order", enum.OrdType_LIMIT, "100", "10", "", 0},
+		{"zero quantity", enum.OrdType_LIMIT, "0", "10", "", tag.OrderQty},
+		{"negative quantity", enum.OrdType_LIMIT, "-100", "10", "", tag.OrderQty},
+		{"zero limit price", enum.OrdType_LIMIT, "100", "0", "", tag.Price},
+		{"negative limit price", enum.OrdType_LIMIT, "100", "-10", "", tag.Price},
+		{"negative stop-limit price", enum.OrdType_STOP_LIMIT, "100", "-10", "10", tag.Price},
+		{"negative stop price", enum.OrdType_STOP, "100", "", "-10", tag.StopPx},
+		{"zero stop price", enum.OrdType_STOP_LIMIT, "100", "10", "0", tag.StopPx},
+		{"market order", enum.OrdType_MARKET, "100", "", "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := fix44nos.New(field.NewClOrdID("1"), field.NewSide(enum.Side_BUY),
+				field.NewTransactTime(time.Now()), field.NewOrdType(tt.ordType))
+			msg.Header.Set(field.NewSenderSubID("alice"))
+			msg.SetSymbol("AAPL")
+			msg.SetOrderQty(decimal.RequireFromString(tt.qty), 0)
+			if tt.price != "" {
+				msg.SetPrice(decimal.RequireFromString(tt.price), 2)
+			}
+			if tt.stopPx != "" {
+				msg.SetStopPx(decimal.RequireFromString(tt.stopPx), 2)
+			}
+
+			_, err := newSingleOrder(msg, quickfix.SessionID{})
+
+			switch {
+			case tt.badTag == 0 && err != nil:
+				t.Errorf("rejected: %v", err)
+			case tt.badTag != 0 && err == nil:
+				t.Errorf("accepted, want tag %d rejected", tt.badTag)
+			case tt.badTag != 0 && (err.RefTagID() == nil || *err.RefTagID() != tt.badTag):
+				t.Errorf("rejected %v, want tag %d", err, tt.badTag)
+			}
+		})
+	}
+}
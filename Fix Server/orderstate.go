@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/quickfix"
+)
+
+// OrderState is where an order is in its lifecycle. Every ExecutionReport
+// the exchange sends for an order is built from its current state.
+type OrderState int
+
+const (
+	ORDER_PENDING_NEW OrderState = iota
+	ORDER_NEW
+	ORDER_PARTIALLY_FILLED
+	ORDER_FILLED
+	ORDER_CANCELED
+	ORDER_REJECTED
+	ORDER_EXPIRED
+)
+
+// orderTransitions lists the states an order may move to from each state.
+// Filled, Canceled, Rejected and Expired are terminal.
+var orderTransitions = map[OrderState][]OrderState{
+	ORDER_PENDING_NEW:      {ORDER_NEW, ORDER_REJECTED},
+	ORDER_NEW:              {ORDER_PARTIALLY_FILLED, ORDER_FILLED, ORDER_CANCELED, ORDER_EXPIRED},
+	ORDER_PARTIALLY_FILLED: {ORDER_PARTIALLY_FILLED, ORDER_FILLED, ORDER_CANCELED, ORDER_EXPIRED},
+}
+
+func (s OrderState) String() string {
+	switch s {
+	case ORDER_PENDING_NEW:
+		return "PendingNew"
+	case ORDER_NEW:
+		return "New"
+	case ORDER_PARTIALLY_FILLED:
+		return "PartiallyFilled"
+	case ORDER_FILLED:
+		return "Filled"
+	case ORDER_CANCELED:
+		return "Canceled"
+	case ORDER_REJECTED:
+		return "Rejected"
+	case ORDER_EXPIRED:
+		return "Expired"
+	}
+	return "Unknown"
+}
+
+func (s OrderState) toFix() enum.OrdStatus {
+	switch s {
+	case ORDER_NEW:
+		return enum.OrdStatus_NEW
+	case ORDER_PARTIALLY_FILLED:
+		return enum.OrdStatus_PARTIALLY_FILLED
+	case ORDER_FILLED:
+		return enum.OrdStatus_FILLED
+	case ORDER_CANCELED:
+		return enum.OrdStatus_CANCELED
+	case ORDER_REJECTED:
+		return enum.OrdStatus_REJECTED
+	case ORDER_EXPIRED:
+		return enum.OrdStatus_EXPIRED
+	}
+	return enum.OrdStatus_PENDING_NEW
+}
+
+// toFixFor is toFix for beginString. Expired only exists from FIX 4.2, before
+// that an expired order is reported as canceled.
+func (s OrderState) toFixFor(beginString string) enum.OrdStatus {
+	if s == ORDER_EXPIRED && (beginString == quickfix.BeginStringFIX40 || beginString == quickfix.BeginStringFIX41) {
+		return enum.OrdStatus_CANCELED
+	}
+	return s.toFix()
+}
+
+// isOpen reports whether an order in this state can still trade.
+func (s OrderState) isOpen() bool {
+	return s == ORDER_NEW || s == ORDER_PARTIALLY_FILLED
+}
+
+// transition moves so to state to, returning false and leaving so untouched
+// if the lifecycle does not allow it.
+func (so *SingleOrder) transition(to OrderState) bool {
+	for _, allowed := range orderTransitions[so.state] {
+		if allowed == to {
+			so.state = to
+			return true
+		}
+	}
+
+	fmt.Printf("Order (%s) From %s Can Not Move From %s To %s \n\r", so.id, so.user, so.state, to)
+	return false
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/quickfix"
+)
+
+// TradingPhase is where the market is in its trading day. The zero value
+// is continuous trading, which a market without a schedule is always in.
+type TradingPhase int
+
+const (
+	PHASE_CONTINUOUS TradingPhase = iota
+	PHASE_PRE_OPEN
+	PHASE_OPENING_AUCTION
+	PHASE_CLOSING_AUCTION
+	PHASE_POST_CLOSE
+	PHASE_HALTED
+)
+
+func (p TradingPhase) String() string {
+	switch p {
+	case PHASE_PRE_OPEN:
+		return "Pre-Open"
+	case PHASE_OPENING_AUCTION:
+		return "Opening Auction"
+	case PHASE_CONTINUOUS:
+		return "Continuous Trading"
+	case PHASE_CLOSING_AUCTION:
+		return "Closing Auction"
+	case PHASE_POST_CLOSE:
+		return "Post-Close"
+	}
+	return "Halted"
+}
+
+// isAuction reports whether orders are being collected for an uncross.
+func (p TradingPhase) isAuction() bool {
+	return p == PHASE_OPENING_AUCTION || p == PHASE_CLOSING_AUCTION
+}
+
+// acceptsOrders reports whether new orders and replaces may be entered.
+// Cancels, status requests and mass cancels are allowed in every phase.
+func (p TradingPhase) acceptsOrders() bool {
+	return p == PHASE_CONTINUOUS || p.isAuction()
+}
+
+// refuse says why so may not be entered in phase p, or is empty if it may.
+// Auctions only take orders that can wait for the uncross.
+func (p TradingPhase) refuse(so *SingleOrder) string {
+	if !p.acceptsOrders() {
+		return "Market Is " + p.String() + ", Orders Not Accepted"
+	}
+	if p.isAuction() && (so.isMarket() || !so.timeInForce.rests()) {
+		return "Only Limit Orders That Can Rest Are Accepted During The " + p.String()
+	}
+	return ""
+}
+
+// toFix maps p onto TradSesStatus and TradingSessionSubID. A halt has no
+// sub ID.
+func (p TradingPhase) toFix() (enum.TradSesStatus, enum.TradingSessionSubID) {
+	switch p {
+	case PHASE_PRE_OPEN:
+		return enum.TradSesStatus_PRE_OPEN, enum.TradingSessionSubID_PRE_TRADING
+	case PHASE_OPENING_AUCTION:
+		return enum.TradSesStatus_PRE_OPEN, enum.TradingSessionSubID_OPENING_OR_OPENING_AUCTION
+	case PHASE_CONTINUOUS:
+		return enum.TradSesStatus_OPEN, enum.TradingSessionSubID_3
+	case PHASE_CLOSING_AUCTION:
+		return enum.TradSesStatus_PRE_CLOSE, enum.TradingSessionSubID_CLOSING_OR_CLOSING_AUCTION
+	case PHASE_POST_CLOSE:
+		return enum.TradSesStatus_CLOSED, enum.TradingSessionSubID_POST_TRADING
+	}
+	return enum.TradSesStatus_HALTED, ""
+}
+
+// phaseStart is a scheduled phase change, at a time of day in UTC.
+type phaseStart struct {
+	at    time.Duration
+	phase TradingPhase
+}
+
+// tradingSession decides which phase the market is in, from the daily
+// schedule unless trading has been halted from the admin interface.
+type tradingSession struct {
+	mu       sync.Mutex
+	schedule []phaseStart
+	phase    TradingPhase
+
+	halted   bool
+	haltedBy string
+	haltedAt time.Time
+
+	nudge chan struct{}
+}
+
+func newTradingSession(schedule []phaseStart) *tradingSession {
+	sort.Slice(schedule, func(a, b int) bool { return schedule[a].at < schedule[b].at })
+
+	ts := &tradingSession{schedule: schedule, nudge: make(chan struct{}, 1)}
+	ts.phase = ts.scheduled(time.Now())
+	return ts
+}
+
+// scheduled is the phase the schedule puts the market in at now. Before the
+// first change of the day the last phase of the day before still applies,
+// and with no schedule at all the market trades continuously.
+func (ts *tradingSession) scheduled(now time.Time) TradingPhase {
+
+	if len(ts.schedule) == 0 {
+		return PHASE_CONTINUOUS
+	}
+
+	now = now.UTC()
+	sinceMidnight := now.Sub(now.Truncate(24 * time.Hour))
+
+	phase := ts.schedule[len(ts.schedule)-1].phase
+	for _, start := range ts.schedule {
+		if start.at > sinceMidnight {
+			break
+		}
+		phase = start.phase
+	}
+
+	return phase
+}
+
+// current returns the phase the market is in.
+func (ts *tradingSession) current() TradingPhase {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	return ts.phase
+}
+
+// describe is the phase and, if trading is halted, who halted it and when,
+// for the admin interface. The phase may lag a halt or resume by a moment.
+func (ts *tradingSession) describe() phaseReply {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	reply := phaseReply{Phase: ts.phase.String(), Halted: ts.halted}
+	if ts.halted {
+		haltedAt := ts.haltedAt
+		reply.HaltedBy, reply.HaltedAt = ts.haltedBy, &haltedAt
+	}
+	return reply
+}
+
+// update works out the phase at now, and returns it along with whether it
+// has changed since the last update.
+func (ts *tradingSession) update(now time.Time) (TradingPhase, bool) {
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	phase := PHASE_HALTED
+	if !ts.halted {
+		phase = ts.scheduled(now)
+	}
+
+	changed := phase != ts.phase
+	ts.phase = phase
+
+	return phase, changed
+}
+
+// halt stops trading until resume is called, whatever the schedule says.
+func (ts *tradingSession) halt(by string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if !ts.halted {
+		ts.halted, ts.haltedBy, ts.haltedAt = true, by, time.Now().UTC()
+		fmt.Printf("Trading Halted By %s \n\r", by)
+	}
+
+	ts.wake()
+}
+
+// resume goes back to the schedule after a halt. It returns false if
+// trading wasn't halted.
+func (ts *tradingSession) resume(by string) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if !ts.halted {
+		return false
+	}
+
+	ts.halted = false
+	fmt.Printf("Trading Resumed By %s \n\r", by)
+
+	ts.wake()
+	return true
+}
+
+// run checks the schedule every second, and straight away after a halt or
+// resume, passing each change of phase to the market and then to changed.
+func (ts *tradingSession) run(market chan<- TradingPhase, changed func(TradingPhase)) {
+	ticker := time.NewTicker(time.Second)
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ts.nudge:
+		}
+
+		if phase, ok := ts.update(time.Now()); ok {
+			fmt.Printf("Market Is Now In %s \n\r", phase)
+			market <- phase
+			changed(phase)
+		}
+	}
+}
+
+// wake makes run check the phase now rather than at the next tick.
+func (ts *tradingSession) wake() {
+	select {
+	case ts.nudge <- struct{}{}:
+	default:
+	}
+}
+
+// tradingSchedule reads the times of day (HH:MM:SS, UTC) each phase starts
+// from PreOpenTime, OpeningAuctionTime, ContinuousTime, ClosingAuctionTime
+// and PostCloseTime. Phases that aren't set are skipped, and with none set
+// the market trades continuously.
+func tradingSchedule(settings *quickfix.SessionSettings) ([]phaseStart, error) {
+
+	keys := []struct {
+		key   string
+		phase TradingPhase
+	}{
+		{"PreOpenTime", PHASE_PRE_OPEN},
+		{"OpeningAuctionTime", PHASE_OPENING_AUCTION},
+		{"ContinuousTime", PHASE_CONTINUOUS},
+		{"ClosingAuctionTime", PHASE_CLOSING_AUCTION},
+		{"PostCloseTime", PHASE_POST_CLOSE},
+	}
+
+	var schedule []phaseStart
+
+	for _, k := range keys {
+		if !settings.HasSetting(k.key) {
+			continue
+		}
+
+		value, _ := settings.Setting(k.key)
+		at, err := timeOfDay(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", k.key, err)
+		}
+
+		schedule = append(schedule, phaseStart{at: at, phase: k.phase})
+	}
+
+	return schedule, nil
+}
+
+// timeOfDay parses HH:MM:SS into the time since midnight.
+func timeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse(time.TimeOnly, strings.TrimSpace(value))
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second, nil
+}
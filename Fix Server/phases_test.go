@@ -0,0 +1,137 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTradingSessionScheduled(t *testing.T) {
+
+	day := []phaseStart{
+		{8 * time.Hour, PHASE_PRE_OPEN},
+		{9 * time.Hour, PHASE_OPENING_AUCTION},
+		{9*time.Hour + 5*time.Minute, PHASE_CONTINUOUS},
+		{16 * time.Hour, PHASE_CLOSING_AUCTION},
+		{16*time.Hour + 5*time.Minute, PHASE_POST_CLOSE},
+	}
+
+	at := func(clock string) time.Time {
+		t, _ := time.Parse(time.DateTime, "2026-10-16 "+clock)
+		return t
+	}
+
+	tests := []struct {
+		name     string
+		schedule []phaseStart
+		now      time.Time
+		want     TradingPhase
+	}{
+		{"no schedule", nil, at("03:00:00"), PHASE_CONTINUOUS},
+		{"before the first change", day, at("03:00:00"), PHASE_POST_CLOSE},
+		{"as a phase starts", day, at("09:00:00"), PHASE_OPENING_AUCTION},
+		{"during the day", day, at("12:00:00"), PHASE_CONTINUOUS},
+		{"closing auction", day, at("16:01:00"), PHASE_CLOSING_AUCTION},
+		{"after the close", day, at("23:59:59"), PHASE_POST_CLOSE},
+		{"in another time zone", day, at("12:00:00").In(time.FixedZone("UTC-10", -10*60*60)), PHASE_CONTINUOUS},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTradingSession(tt.schedule)
+			if got := ts.scheduled(tt.now); got != tt.want {
+				t.Errorf("scheduled(%s) = %s, want %s", tt.now.Format(time.TimeOnly), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTradingPhaseRefuse(t *testing.T) {
+
+	market := testOrder("1", BUY, 0, 10)
+	market.orderType = MARKET
+
+	ioc := testOrder("2", BUY, 100, 10)
+	ioc.timeInForce = TIF_IOC
+
+	tests := []struct {
+		name    string
+		phase   TradingPhase
+		so      *SingleOrder
+		refused bool
+	}{
+		{"limit in continuous trading", PHASE_CONTINUOUS, testOrder("3", BUY, 100, 10), false},
+		{"market in continuous trading", PHASE_CONTINUOUS, market, false},
+		{"limit in an auction", PHASE_OPENING_AUCTION, testOrder("3", BUY, 100, 10), false},
+		{"market in an auction", PHASE_CLOSING_AUCTION, market, true},
+		{"IOC in an auction", PHASE_OPENING_AUCTION, ioc, true},
+		{"limit before the open", PHASE_PRE_OPEN, testOrder("3", BUY, 100, 10), true},
+		{"limit after the close", PHASE_POST_CLOSE, testOrder("3", BUY, 100, 10), true},
+		{"limit while halted", PHASE_HALTED, testOrder("3", BUY, 100, 10), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if refused := tt.phase.refuse(tt.so); (refused != "") != tt.refused {
+				t.Errorf("refuse = %q, want refused %v", refused, tt.refused)
+			}
+		})
+	}
+}
+
+func TestTradingSessionHaltAndResume(t *testing.T) {
+
+	ts := newTradingSession(nil)
+	now := time.Now()
+
+	if ts.resume("admin") {
+		t.Error("resumed without a halt")
+	}
+
+	ts.halt("admin")
+	if phase, changed := ts.update(now); phase != PHASE_HALTED || !changed {
+		t.Errorf("after halt update = %s, %v, want %s, true", phase, changed, PHASE_HALTED)
+	}
+	if reply := ts.describe(); !reply.Halted || reply.HaltedBy != "admin" || reply.HaltedAt == nil {
+		t.Errorf("describe = %+v, want halted by admin", reply)
+	}
+
+	// halting again changes nothing
+	ts.halt("someone else")
+	if phase, changed := ts.update(now); phase != PHASE_HALTED || changed {
+		t.Errorf("after second halt update = %s, %v, want %s, false", phase, changed, PHASE_HALTED)
+	}
+	if reply := ts.describe(); reply.HaltedBy != "admin" {
+		t.Errorf("halted by %s, want admin", reply.HaltedBy)
+	}
+
+	if !ts.resume("admin") {
+		t.Fatal("resume after halt returned false")
+	}
+	if phase, changed := ts.update(now); phase != PHASE_CONTINUOUS || !changed {
+		t.Errorf("after resume update = %s, %v, want %s, true", phase, changed, PHASE_CONTINUOUS)
+	}
+}
+
+func TestTimeOfDay(t *testing.T) {
+
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"00:00:00", 0, true},
+		{"09:30:00", 9*time.Hour + 30*time.Minute, true},
+		{" 16:05:30 ", 16*time.Hour + 5*time.Minute + 30*time.Second, true},
+		{"23:59:59", 24*time.Hour - time.Second, true},
+		{"9:30", 0, false},
+		{"24:00:00", 0, false},
+		{"noon", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, err := timeOfDay(tt.value)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("timeOfDay(%q) = %s, %v, want %s, ok %v", tt.value, got, err, tt.want, tt.ok)
+		}
+	}
+}
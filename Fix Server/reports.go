@@ -0,0 +1,199 @@
+package main
+
+import (
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/quickfix"
+
+	fix40er "github.com/quickfixgo/fix40/executionreport"
+	fix40ocj "github.com/quickfixgo/fix40/ordercancelreject"
+	fix41er "github.com/quickfixgo/fix41/executionreport"
+	fix41ocj "github.com/quickfixgo/fix41/ordercancelreject"
+	fix42er "github.com/quickfixgo/fix42/executionreport"
+	fix42ocj "github.com/quickfixgo/fix42/ordercancelreject"
+	fix43er "github.com/quickfixgo/fix43/executionreport"
+	fix43ocj "github.com/quickfixgo/fix43/ordercancelreject"
+	fix44er "github.com/quickfixgo/fix44/executionreport"
+	fix44ocj "github.com/quickfixgo/fix44/ordercancelreject"
+	fix50er "github.com/quickfixgo/fix50/executionreport"
+	fix50ocj "github.com/quickfixgo/fix50/ordercancelreject"
+)
+
+// unknownOrder stands in for an order the market could not find, so that
+// rejects can be reported the same way as any other order.
+func unknownOrder(user, symbol, id string, side Side) *SingleOrder {
+	return &SingleOrder{
+		orderID: "NONE",
+		user:    user,
+		symbol:  symbol,
+		id:      id,
+		side:    side,
+		state:   ORDER_REJECTED,
+	}
+}
+
+// execReport builds an ExecutionReport from the order's current state in
+// the FIX version spoken by sessionID.
+func (e *Server) execReport(order *SingleOrder, execType enum.ExecType, sessionID quickfix.SessionID) *quickfix.Message {
+
+	orderID := field.NewOrderID(order.orderID)
+	execID := e.genExecID()
+	ordStatus := field.NewOrdStatus(order.state.toFixFor(sessionID.BeginString))
+	symbol := field.NewSymbol(order.symbol)
+	side := field.NewSide(order.side.toFix())
+	// prices go out at the instrument's tick size, quantities as they are
+	scale := e.instruments.priceScale(order.symbol)
+	orderQty := field.NewOrderQty(order.volume, decimalPlaces(order.volume))
+	leavesQty := field.NewLeavesQty(order.remaining(), decimalPlaces(order.remaining()))
+	cumQty := field.NewCumQty(order.filled, decimalPlaces(order.filled))
+	avgPx := field.NewAvgPx(order.avgPx(), scale)
+	lastQty := decimalPlaces(order.lastQty)
+
+	// Triggered only exists from FIX 5.0, before that it is a restatement
+	if execType == enum.ExecType_TRIGGERED_OR_ACTIVATED_BY_SYSTEM && sessionID.BeginString != quickfix.BeginStringFIXT11 {
+		execType = enum.ExecType_RESTATED
+		if sessionID.BeginString == quickfix.BeginStringFIX41 {
+			execType = enum.ExecType_NEW
+		}
+	}
+
+	transType := field.NewExecTransType(enum.ExecTransType_NEW)
+	if execType == enum.ExecType_ORDER_STATUS {
+		transType = field.NewExecTransType(enum.ExecTransType_STATUS)
+	}
+
+	legacyExecType := field.NewExecType(legacyExecType(order, execType, sessionID.BeginString))
+
+	var msg *quickfix.Message
+
+	switch sessionID.BeginString {
+	case quickfix.BeginStringFIX40:
+		msg = fix40er.New(orderID, execID, transType, ordStatus, symbol, side, orderQty,
+			field.NewLastShares(order.lastQty, lastQty), field.NewLastPx(order.lastPx, scale), cumQty, avgPx).ToMessage()
+
+	case quickfix.BeginStringFIX41:
+		msg = fix41er.New(orderID, execID, transType, legacyExecType, ordStatus, symbol, side, orderQty,
+			field.NewLastShares(order.lastQty, lastQty), field.NewLastPx(order.lastPx, scale), leavesQty, cumQty, avgPx).ToMessage()
+
+	case quickfix.BeginStringFIX42:
+		msg = fix42er.New(orderID, execID, transType, legacyExecType, ordStatus, symbol, side, leavesQty, cumQty, avgPx).ToMessage()
+
+	case quickfix.BeginStringFIX43:
+		msg = fix43er.New(orderID, execID, legacyExecType, ordStatus, side, leavesQty, cumQty, avgPx).ToMessage()
+
+	case quickfix.BeginStringFIX44:
+		msg = fix44er.New(orderID, execID, field.NewExecType(execType), ordStatus, side, leavesQty, cumQty, avgPx).ToMessage()
+
+	default:
+		msg = fix50er.New(orderID, execID, field.NewExecType(execType), ordStatus, side, leavesQty, cumQty).ToMessage()
+		msg.Body.Set(avgPx)
+	}
+
+	msg.Header.Set(field.NewTargetSubID(order.user))
+	msg.Body.Set(field.NewClOrdID(order.id))
+	msg.Body.Set(symbol)
+	msg.Body.Set(orderQty)
+
+	if !order.price.IsZero() {
+		msg.Body.Set(field.NewPrice(order.price, scale))
+	}
+
+	msg.Body.Set(field.NewOrdType(order.orderType.toFix()))
+	msg.Body.Set(field.NewTimeInForce(order.timeInForce.toFix()))
+
+	if order.isIceberg() {
+		switch sessionID.BeginString {
+		case quickfix.BeginStringFIX40, quickfix.BeginStringFIX41:
+		case quickfix.BeginStringFIXT11:
+			msg.Body.Set(field.NewDisplayQty(order.displayQty, decimalPlaces(order.displayQty)))
+		default:
+			msg.Body.Set(field.NewMaxFloor(order.displayQty, decimalPlaces(order.displayQty)))
+		}
+	}
+
+	if order.orderType == STOP || order.orderType == STOP_LIMIT {
+		msg.Body.Set(field.NewStopPx(order.stopPx, scale))
+	}
+
+	if order.timeInForce == TIF_GTD {
+		msg.Body.Set(field.NewExpireTime(order.expireTime))
+	}
+
+	if execType == enum.ExecType_TRADE {
+		msg.Body.Set(field.NewLastQty(order.lastQty, lastQty))
+		msg.Body.Set(field.NewLastPx(order.lastPx, scale))
+	}
+
+	return msg
+}
+
+// legacyExecType maps an ExecType onto the values used before FIX 4.4.
+// Before FIX 4.3 fills are reported as partial fill/fill and a status
+// request is answered with the order's status. FIX 4.1 has no expired, so
+// an expiry is reported as a cancel.
+func legacyExecType(order *SingleOrder, execType enum.ExecType, beginString string) enum.ExecType {
+
+	switch execType {
+	case enum.ExecType_TRADE:
+		if beginString == quickfix.BeginStringFIX43 {
+			return execType
+		}
+		if order.state == ORDER_FILLED {
+			return enum.ExecType_FILL
+		}
+		return enum.ExecType_PARTIAL_FILL
+
+	case enum.ExecType_ORDER_STATUS:
+		if beginString == quickfix.BeginStringFIX43 {
+			return execType
+		}
+		return enum.ExecType(order.state.toFixFor(beginString))
+
+	case enum.ExecType_EXPIRED:
+		if beginString == quickfix.BeginStringFIX41 {
+			return enum.ExecType_CANCELED
+		}
+	}
+
+	return execType
+}
+
+// cancelReject turns down a cancel or cancel/replace request in the FIX
+// version spoken by sessionID, reporting the order's current status.
+func (e *Server) cancelReject(order *SingleOrder, req *cancelRequest, responseTo enum.CxlRejResponseTo, reason enum.CxlRejReason, sessionID quickfix.SessionID) *quickfix.Message {
+
+	orderID := field.NewOrderID(order.orderID)
+	clOrdID := field.NewClOrdID(req.id)
+	origClOrdID := field.NewOrigClOrdID(req.origID)
+	ordStatus := field.NewOrdStatus(order.state.toFixFor(sessionID.BeginString))
+	cxlRejResponseTo := field.NewCxlRejResponseTo(responseTo)
+
+	var msg *quickfix.Message
+
+	switch sessionID.BeginString {
+	case quickfix.BeginStringFIX40:
+		msg = fix40ocj.New(orderID, clOrdID).ToMessage()
+		msg.Body.Set(origClOrdID)
+		msg.Body.Set(ordStatus)
+
+	case quickfix.BeginStringFIX41:
+		msg = fix41ocj.New(orderID, clOrdID, origClOrdID, ordStatus).ToMessage()
+
+	case quickfix.BeginStringFIX42:
+		msg = fix42ocj.New(orderID, clOrdID, origClOrdID, ordStatus, cxlRejResponseTo).ToMessage()
+
+	case quickfix.BeginStringFIX43:
+		msg = fix43ocj.New(orderID, clOrdID, origClOrdID, ordStatus, cxlRejResponseTo).ToMessage()
+
+	case quickfix.BeginStringFIX44:
+		msg = fix44ocj.New(orderID, clOrdID, origClOrdID, ordStatus, cxlRejResponseTo).ToMessage()
+
+	default:
+		msg = fix50ocj.New(orderID, clOrdID, origClOrdID, ordStatus, cxlRejResponseTo).ToMessage()
+	}
+
+	msg.Header.Set(field.NewTargetSubID(req.user))
+	msg.Body.Set(field.NewCxlRejReason(reason))
+
+	return msg
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/quickfix"
+)
+
+func TestLegacyExecType(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		state       OrderState
+		execType    enum.ExecType
+		beginString string
+		want        enum.ExecType
+	}{
+		{"fill on FIX 4.1", ORDER_FILLED, enum.ExecType_TRADE, quickfix.BeginStringFIX41, enum.ExecType_FILL},
+		{"partial fill on FIX 4.2", ORDER_PARTIALLY_FILLED, enum.ExecType_TRADE, quickfix.BeginStringFIX42, enum.ExecType_PARTIAL_FILL},
+		{"fill on FIX 4.3", ORDER_FILLED, enum.ExecType_TRADE, quickfix.BeginStringFIX43, enum.ExecType_TRADE},
+		{"partial fill on FIX 4.3", ORDER_PARTIALLY_FILLED, enum.ExecType_TRADE, quickfix.BeginStringFIX43, enum.ExecType_TRADE},
+		{"status on FIX 4.2", ORDER_NEW, enum.ExecType_ORDER_STATUS, quickfix.BeginStringFIX42, enum.ExecType_NEW},
+		{"status on FIX 4.3", ORDER_NEW, enum.ExecType_ORDER_STATUS, quickfix.BeginStringFIX43, enum.ExecType_ORDER_STATUS},
+		{"expiry on FIX 4.1", ORDER_EXPIRED, enum.ExecType_EXPIRED, quickfix.BeginStringFIX41, enum.ExecType_CANCELED},
+		{"expiry on FIX 4.2", ORDER_EXPIRED, enum.ExecType_EXPIRED, quickfix.BeginStringFIX42, enum.ExecType_EXPIRED},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := &SingleOrder{state: tt.state}
+			if got := legacyExecType(order, tt.execType, tt.beginString); got != tt.want {
+				t.Errorf("legacyExecType = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
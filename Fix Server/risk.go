@@ -0,0 +1,400 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/quickfix"
+	"github.com/shopspring/decimal"
+)
+
+// riskLimits are the pre-trade limits for one SenderSubID. A zero limit is
+// not checked.
+type riskLimits struct {
+	MaxOrderQty        decimal.Decimal `json:"maxOrderQty"`
+	MaxNotional        decimal.Decimal `json:"maxNotional"`
+	PriceCollarPercent decimal.Decimal `json:"priceCollarPercent"`
+	MaxOpenOrders      int             `json:"maxOpenOrders"`
+	MaxGrossExposure   decimal.Decimal `json:"maxGrossExposure"`
+}
+
+// orderRejection is why a new order was turned away before it reached the
+// book.
+type orderRejection struct {
+	reason enum.OrdRejReason
+	text   string
+}
+
+// riskOrder is what the risk checks need to know about an open order,
+// including what a replace keeps of it.
+type riskOrder struct {
+	user      string
+	notional  decimal.Decimal
+	orderType OrderType
+	stopPx    decimal.Decimal
+	filled    decimal.Decimal
+}
+
+// riskEngine checks each new order and replace against its sender's limits
+// before it is handed to the market. It keeps its own tally of every
+// user's open orders, positions and the last trade in each symbol, fed with
+// the same events as the journal, so the checks never have to ask the
+// books.
+//
+// A nil riskEngine lets every order through.
+type riskEngine struct {
+	mu       sync.Mutex
+	defaults riskLimits
+	users    map[string]riskLimits
+
+	open      map[string]riskOrder
+	positions map[string]map[string]decimal.Decimal
+	lastPx    map[string]decimal.Decimal
+
+	// orders that passed the checks but that their book hasn't taken or
+	// turned down yet, counted so orders checked in the meantime see them
+	pending map[*SingleOrder]riskOrder
+}
+
+func newRiskEngine(defaults riskLimits, users map[string]riskLimits) *riskEngine {
+	return &riskEngine{
+		defaults:  defaults,
+		users:     users,
+		open:      make(map[string]riskOrder),
+		positions: make(map[string]map[string]decimal.Decimal),
+		lastPx:    make(map[string]decimal.Decimal),
+		pending:   make(map[*SingleOrder]riskOrder),
+	}
+}
+
+// loadRiskLimits reads the per user limits in path, a JSON object keyed by
+// SenderSubID. Any limit a user leaves out is taken from defaults. A
+// missing file means every user has the defaults.
+func loadRiskLimits(path string, defaults riskLimits) (map[string]riskLimits, error) {
+
+	users := make(map[string]riskLimits)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return users, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("corrupt risk limits: %w", err)
+	}
+
+	for user, entry := range raw {
+		limits := defaults
+		if err := json.Unmarshal(entry, &limits); err != nil {
+			return nil, fmt.Errorf("corrupt risk limits for %s: %w", user, err)
+		}
+		users[user] = limits
+	}
+
+	return users, nil
+}
+
+func (r *riskEngine) limitsFor(user string) riskLimits {
+	if limits, ok := r.users[user]; ok {
+		return limits
+	}
+	return r.defaults
+}
+
+// exposureOf is how much an order commits its owner to: the value of what
+// is left of it, at its limit price, its stop price for a stop order, or
+// the last trade for a market order.
+func exposureOf(so *SingleOrder, quantity, lastPx decimal.Decimal) decimal.Decimal {
+	price := so.price
+
+	switch so.orderType {
+	case MARKET:
+		price = lastPx
+	case STOP:
+		price = so.stopPx
+	}
+
+	return quantity.Mul(price)
+}
+
+// check runs the pre-trade checks on a new order. It returns nil if the
+// order may go ahead, in which case the order is counted straight away
+// until settle is called for it, once its book has accepted or rejected it.
+func (r *riskEngine) check(so *SingleOrder) *orderRejection {
+
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	notional := exposureOf(so, so.volume, r.lastPx[so.symbol])
+
+	if breach := r.breach(so, notional, r.openOrders(so.user)+1, r.grossExposure(so.user).Add(notional)); breach != nil {
+		return breach
+	}
+
+	r.pending[so] = riskOrder{user: so.user, notional: notional}
+
+	return nil
+}
+
+// settle stops counting so as pending. The book's own events count it from
+// then on if it was accepted.
+func (r *riskEngine) settle(so *SingleOrder) {
+
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.pending, so)
+}
+
+// checkReplace runs the pre-trade checks on user's open order origID as the
+// replace would amend it. The order's own current exposure is left out of
+// the totals, so only what the replace changes counts. An order the tally
+// doesn't have isn't checked, the book turns the replace down.
+func (r *riskEngine) checkReplace(req *replaceRequest) *orderRejection {
+
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, ok := r.open[journalKey(req.symbol, req.user, req.origID)]
+	if !ok {
+		return nil
+	}
+
+	amended := &SingleOrder{
+		user:      req.user,
+		symbol:    req.symbol,
+		orderType: current.orderType,
+		price:     req.price,
+		volume:    req.volume,
+		stopPx:    req.stopPx,
+		filled:    current.filled,
+	}
+
+	lastPx := r.lastPx[req.symbol]
+	notional := exposureOf(amended, amended.volume, lastPx)
+	remaining := decimal.Max(amended.volume.Sub(amended.filled), decimal.Zero)
+	exposure := r.grossExposure(req.user).Sub(current.notional).Add(exposureOf(amended, remaining, lastPx))
+
+	return r.breach(amended, notional, r.openOrders(req.user), exposure)
+}
+
+// breach checks so, worth notional, against its owner's limits, given how
+// many open orders and how much gross exposure they would have with it. It
+// returns nil if no limit is breached. The caller must hold r.mu.
+func (r *riskEngine) breach(so *SingleOrder, notional decimal.Decimal, openOrders int, exposure decimal.Decimal) *orderRejection {
+
+	limits := r.limitsFor(so.user)
+	lastPx := r.lastPx[so.symbol]
+
+	if limits.MaxOrderQty.IsPositive() && so.volume.GreaterThan(limits.MaxOrderQty) {
+		return &orderRejection{enum.OrdRejReason_ORDER_EXCEEDS_LIMIT,
+			fmt.Sprintf("Order Quantity %s Exceeds Limit Of %s", so.volume, limits.MaxOrderQty)}
+	}
+
+	// a market order is valued at the last trade, so until the symbol has
+	// traded there is nothing to hold it to the value limits with
+	if so.orderType == MARKET && !lastPx.IsPositive() && (limits.MaxNotional.IsPositive() || limits.MaxGrossExposure.IsPositive()) {
+		return &orderRejection{enum.OrdRejReason_ORDER_EXCEEDS_LIMIT,
+			fmt.Sprintf("No Last Trade In %s To Value Market Order", so.symbol)}
+	}
+
+	if limits.MaxNotional.IsPositive() && notional.GreaterThan(limits.MaxNotional) {
+		return &orderRejection{enum.OrdRejReason_ORDER_EXCEEDS_LIMIT,
+			fmt.Sprintf("Order Notional %s Exceeds Limit Of %s", notional, limits.MaxNotional)}
+	}
+
+	// the collar only applies to a limit price, and only once the symbol has traded
+	if limits.PriceCollarPercent.IsPositive() && lastPx.IsPositive() && (so.orderType == LIMIT || so.orderType == STOP_LIMIT) {
+		band := lastPx.Mul(limits.PriceCollarPercent).Div(decimal.NewFromInt(100))
+		low, high := lastPx.Sub(band), lastPx.Add(band)
+
+		if so.price.LessThan(low) || so.price.GreaterThan(high) {
+			return &orderRejection{enum.OrdRejReason_ORDER_EXCEEDS_LIMIT,
+				fmt.Sprintf("Price %s Outside Collar Of %s To %s", so.price, low, high)}
+		}
+	}
+
+	if limits.MaxOpenOrders > 0 && openOrders > limits.MaxOpenOrders {
+		return &orderRejection{enum.OrdRejReason_ORDER_EXCEEDS_LIMIT,
+			fmt.Sprintf("Open Orders Would Exceed Limit Of %d", limits.MaxOpenOrders)}
+	}
+
+	if limits.MaxGrossExposure.IsPositive() && exposure.GreaterThan(limits.MaxGrossExposure) {
+		return &orderRejection{enum.OrdRejReason_ORDER_EXCEEDS_LIMIT,
+			fmt.Sprintf("Gross Exposure %s Would Exceed Limit Of %s", exposure, limits.MaxGrossExposure)}
+	}
+
+	return nil
+}
+
+// openOrders counts user's open and pending orders. The caller must hold
+// r.mu.
+func (r *riskEngine) openOrders(user string) int {
+	count := 0
+	for _, order := range r.open {
+		if order.user == user {
+			count++
+		}
+	}
+	for _, order := range r.pending {
+		if order.user == user {
+			count++
+		}
+	}
+	return count
+}
+
+// grossExposure is the value of user's open and pending orders plus the
+// value of their position in each symbol at its last trade, longs and
+// shorts alike. The caller must hold r.mu.
+func (r *riskEngine) grossExposure(user string) decimal.Decimal {
+	exposure := decimal.Zero
+
+	for _, order := range r.open {
+		if order.user == user {
+			exposure = exposure.Add(order.notional)
+		}
+	}
+
+	for _, order := range r.pending {
+		if order.user == user {
+			exposure = exposure.Add(order.notional)
+		}
+	}
+
+	for symbol, position := range r.positions[user] {
+		exposure = exposure.Add(position.Abs().Mul(r.lastPx[symbol]))
+	}
+
+	return exposure
+}
+
+// track updates the tally with so, which must be the order's state straight
+// after eventType happened to it, in the same way the journal records it.
+func (r *riskEngine) track(eventType string, so *SingleOrder, origID string) {
+
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if eventType == JOURNAL_REPLACE {
+		delete(r.open, journalKey(so.symbol, so.user, origID))
+	}
+
+	if eventType == JOURNAL_FILL {
+		r.lastPx[so.symbol] = so.lastPx
+
+		qty := so.lastQty
+		if so.side == SELL {
+			qty = qty.Neg()
+		}
+
+		if r.positions[so.user] == nil {
+			r.positions[so.user] = make(map[string]decimal.Decimal)
+		}
+		r.positions[so.user][so.symbol] = r.positions[so.user][so.symbol].Add(qty)
+	}
+
+	key := journalKey(so.symbol, so.user, so.id)
+	if so.state.isOpen() {
+		r.open[key] = riskOrder{
+			user:      so.user,
+			notional:  exposureOf(so, so.remaining(), r.lastPx[so.symbol]),
+			orderType: so.orderType,
+			stopPx:    so.stopPx,
+			filled:    so.filled,
+		}
+	} else {
+		delete(r.open, key)
+	}
+}
+
+// restore seeds the tally with the open orders and last trade prices the
+// journal restored. Positions are not journaled, so they start afresh.
+func (r *riskEngine) restore(orders []*SingleOrder, lastPrices map[string]decimal.Decimal) {
+
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	for symbol, lastPx := range lastPrices {
+		r.lastPx[symbol] = lastPx
+	}
+	r.mu.Unlock()
+
+	for _, so := range orders {
+		r.track(JOURNAL_ACCEPT, so, "")
+	}
+}
+
+// riskSettings reads the default limits from the RiskMaxOrderQty,
+// RiskMaxNotional, RiskPriceCollarPercent, RiskMaxOpenOrders and
+// RiskMaxGrossExposure settings, then any per user limits from the JSON
+// file named by RiskLimitsPath.
+func riskSettings(settings *quickfix.SessionSettings) (*riskEngine, error) {
+
+	var defaults riskLimits
+	var err error
+
+	decimals := []struct {
+		key   string
+		limit *decimal.Decimal
+	}{
+		{"RiskMaxOrderQty", &defaults.MaxOrderQty},
+		{"RiskMaxNotional", &defaults.MaxNotional},
+		{"RiskPriceCollarPercent", &defaults.PriceCollarPercent},
+		{"RiskMaxGrossExposure", &defaults.MaxGrossExposure},
+	}
+
+	for _, d := range decimals {
+		if !settings.HasSetting(d.key) {
+			continue
+		}
+
+		value, _ := settings.Setting(d.key)
+		if *d.limit, err = decimal.NewFromString(value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", d.key, err)
+		}
+	}
+
+	if settings.HasSetting("RiskMaxOpenOrders") {
+		if defaults.MaxOpenOrders, err = settings.IntSetting("RiskMaxOpenOrders"); err != nil {
+			return nil, err
+		}
+	}
+
+	users := make(map[string]riskLimits)
+
+	if settings.HasSetting("RiskLimitsPath") {
+		path, _ := settings.Setting("RiskLimitsPath")
+		if users, err = loadRiskLimits(path, defaults); err != nil {
+			return nil, err
+		}
+	}
+
+	return newRiskEngine(defaults, users), nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestRiskCheckReplace(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		limits riskLimits
+		price  string
+		volume string
+		breach bool
+	}{
+		{"within limits", riskLimits{MaxOrderQty: decimal.NewFromInt(100), PriceCollarPercent: decimal.NewFromInt(10)}, "105", "20", false},
+		{"over max quantity", riskLimits{MaxOrderQty: decimal.NewFromInt(100)}, "100", "100000", true},
+		{"outside collar", riskLimits{PriceCollarPercent: decimal.NewFromInt(10)}, "500", "10", true},
+		{"own exposure left out", riskLimits{MaxGrossExposure: decimal.NewFromInt(1000)}, "100", "10", false},
+		{"more exposure", riskLimits{MaxGrossExposure: decimal.NewFromInt(1000)}, "100", "11", true},
+		{"own order left out", riskLimits{MaxOpenOrders: 1}, "100", "5", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRiskEngine(tt.limits, nil)
+			r.lastPx["AAPL"] = decimal.NewFromInt(100)
+			r.track(JOURNAL_ACCEPT, &SingleOrder{id: "1", user: "alice", symbol: "AAPL", side: SELL, orderType: LIMIT,
+				price: decimal.NewFromInt(100), volume: decimal.NewFromInt(10), state: ORDER_NEW}, "")
+
+			req := &replaceRequest{
+				cancelRequest: cancelRequest{user: "alice", symbol: "AAPL", side: SELL, id: "2", origID: "1"},
+				price:         decimal.RequireFromString(tt.price),
+				volume:        decimal.RequireFromString(tt.volume),
+			}
+
+			if breach := r.checkReplace(req); (breach != nil) != tt.breach {
+				t.Errorf("checkReplace(%s@%s) = %v, want breach %v", tt.volume, tt.price, breach, tt.breach)
+			}
+		})
+	}
+}
+
+func TestRiskCheckCountsPendingOrders(t *testing.T) {
+
+	r := newRiskEngine(riskLimits{MaxOpenOrders: 1}, nil)
+
+	first := &SingleOrder{id: "1", user: "alice", symbol: "AAPL", side: BUY, orderType: LIMIT,
+		price: decimal.NewFromInt(100), volume: decimal.NewFromInt(10)}
+	second := &SingleOrder{id: "2", user: "alice", symbol: "MSFT", side: BUY, orderType: LIMIT,
+		price: decimal.NewFromInt(100), volume: decimal.NewFromInt(10)}
+
+	if breach := r.check(first); breach != nil {
+		t.Fatalf("first order rejected: %s", breach.text)
+	}
+	if breach := r.check(second); breach == nil {
+		t.Fatal("second order accepted while the first is pending")
+	}
+
+	// rejected by its book, so no longer counted
+	r.settle(first)
+	if breach := r.check(second); breach != nil {
+		t.Fatalf("second order rejected after the first settled: %s", breach.text)
+	}
+}
+
+func TestRiskCheckMarketOrder(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		limits riskLimits
+		lastPx int64
+		breach bool
+	}{
+		{"valued at the last trade", riskLimits{MaxNotional: decimal.NewFromInt(1000)}, 100, false},
+		{"over max notional at the last trade", riskLimits{MaxNotional: decimal.NewFromInt(1000)}, 101, true},
+		{"no last trade with max notional", riskLimits{MaxNotional: decimal.NewFromInt(1000)}, 0, true},
+		{"no last trade with max exposure", riskLimits{MaxGrossExposure: decimal.NewFromInt(1000)}, 0, true},
+		{"no last trade without value limits", riskLimits{MaxOrderQty: decimal.NewFromInt(100)}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRiskEngine(tt.limits, nil)
+			if tt.lastPx > 0 {
+				r.lastPx["AAPL"] = decimal.NewFromInt(tt.lastPx)
+			}
+
+			so := &SingleOrder{id: "1", user: "alice", symbol: "AAPL", side: BUY, orderType: MARKET, volume: decimal.NewFromInt(10)}
+
+			if breach := r.check(so); (breach != nil) != tt.breach {
+				t.Errorf("check = %v, want breach %v", breach, tt.breach)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+
+	fix43sd "github.com/quickfixgo/fix43/securitydefinition"
+	fix43sdr "github.com/quickfixgo/fix43/securitydefinitionrequest"
+	fix43sl "github.com/quickfixgo/fix43/securitylist"
+	fix43slr "github.com/quickfixgo/fix43/securitylistrequest"
+	fix44sd "github.com/quickfixgo/fix44/securitydefinition"
+	fix44sdr "github.com/quickfixgo/fix44/securitydefinitionrequest"
+	fix44sl "github.com/quickfixgo/fix44/securitylist"
+	fix44slr "github.com/quickfixgo/fix44/securitylistrequest"
+	fix50sd "github.com/quickfixgo/fix50/securitydefinition"
+	fix50sdr "github.com/quickfixgo/fix50/securitydefinitionrequest"
+	fix50sl "github.com/quickfixgo/fix50/securitylist"
+	fix50slr "github.com/quickfixgo/fix50/securitylistrequest"
+)
+
+// SecurityListRequest and SecurityDefinitionRequest are answered from the
+// instrument master for FIX 4.3 and later, every version of each is read
+// the same way.
+
+type securityListRequest interface {
+	GetSecurityReqID() (string, quickfix.MessageRejectError)
+	GetSecurityListRequestType() (enum.SecurityListRequestType, quickfix.MessageRejectError)
+	GetSymbol() (string, quickfix.MessageRejectError)
+}
+
+type securityDefinitionRequest interface {
+	GetSecurityReqID() (string, quickfix.MessageRejectError)
+	GetSecurityRequestType() (enum.SecurityRequestType, quickfix.MessageRejectError)
+	GetSymbol() (string, quickfix.MessageRejectError)
+}
+
+func (e *Server) onFIX43SecurityListRequest(msg fix43slr.SecurityListRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onSecurityListRequest(msg, sessionID)
+}
+
+func (e *Server) onFIX44SecurityListRequest(msg fix44slr.SecurityListRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onSecurityListRequest(msg, sessionID)
+}
+
+func (e *Server) onFIX50SecurityListRequest(msg fix50slr.SecurityListRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onSecurityListRequest(msg, sessionID)
+}
+
+func (e *Server) onFIX43SecurityDefinitionRequest(msg fix43sdr.SecurityDefinitionRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onSecurityDefinitionRequest(msg, sessionID)
+}
+
+func (e *Server) onFIX44SecurityDefinitionRequest(msg fix44sdr.SecurityDefinitionRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onSecurityDefinitionRequest(msg, sessionID)
+}
+
+func (e *Server) onFIX50SecurityDefinitionRequest(msg fix50sdr.SecurityDefinitionRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onSecurityDefinitionRequest(msg, sessionID)
+}
+
+// onSecurityListRequest lists every instrument, or just the one named by
+// Symbol.
+func (e *Server) onSecurityListRequest(msg securityListRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+
+	reqID, err := msg.GetSecurityReqID()
+	if err != nil {
+		return err
+	}
+
+	requestType, err := msg.GetSecurityListRequestType()
+	if err != nil {
+		return err
+	}
+
+	var listed []instrument
+	result := enum.SecurityRequestResult_VALID_REQUEST
+
+	switch requestType {
+	case enum.SecurityListRequestType_ALL_SECURITIES:
+		listed = e.instruments.list()
+
+	case enum.SecurityListRequestType_SYMBOL:
+		symbol, _ := msg.GetSymbol()
+		if symbol == "" {
+			return quickfix.ConditionallyRequiredFieldMissing(tag.Symbol)
+		}
+
+		if inst, ok := e.instruments.lookup(symbol); ok {
+			listed = append(listed, inst)
+		}
+
+	default:
+		result = enum.SecurityRequestResult_INVALID_OR_UNSUPPORTED_REQUEST
+	}
+
+	if result == enum.SecurityRequestResult_VALID_REQUEST && len(listed) == 0 {
+		result = enum.SecurityRequestResult_NO_INSTRUMENTS_FOUND_THAT_MATCH_SELECTION_CRITERIA
+	}
+
+	e.send(e.securityList(reqID, result, listed, sessionID), sessionID)
+	return nil
+}
+
+// onSecurityDefinitionRequest describes the instrument named by Symbol, or
+// rejects the request if it isn't listed.
+func (e *Server) onSecurityDefinitionRequest(msg securityDefinitionRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+
+	reqID, err := msg.GetSecurityReqID()
+	if err != nil {
+		return err
+	}
+
+	requestType, err := msg.GetSecurityRequestType()
+	if err != nil {
+		return err
+	}
+
+	if requestType != enum.SecurityRequestType_REQUEST_SECURITY_IDENTITY_AND_SPECIFICATIONS &&
+		requestType != enum.SecurityRequestType_REQUEST_SECURITY_IDENTITY_FOR_THE_SPECIFICATIONS_PROVIDED {
+		return quickfix.ValueIsIncorrect(tag.SecurityRequestType)
+	}
+
+	symbol, _ := msg.GetSymbol()
+	if symbol == "" {
+		return quickfix.ConditionallyRequiredFieldMissing(tag.Symbol)
+	}
+
+	inst, ok := e.instruments.lookup(symbol)
+	if !ok {
+		definition := e.securityDefinition(reqID, enum.SecurityResponseType_REJECT_SECURITY_PROPOSAL, sessionID)
+		definition.Body.Set(field.NewSymbol(symbol))
+		definition.Body.Set(field.NewText("Unknown Symbol " + symbol))
+
+		e.send(definition, sessionID)
+		return nil
+	}
+
+	definition := e.securityDefinition(reqID, enum.SecurityResponseType_ACCEPT_SECURITY_PROPOSAL_AS_IS, sessionID)
+	setInstrumentFields(&definition.Body.FieldMap, inst, sessionID.BeginString)
+
+	e.send(definition, sessionID)
+	return nil
+}
+
+// securityList builds a SecurityList in the FIX version spoken by sessionID.
+func (e *Server) securityList(reqID string, result enum.SecurityRequestResult, listed []instrument, sessionID quickfix.SessionID) *quickfix.Message {
+
+	// the response needs an id of its own, exec IDs are already unique
+	securityReqID := field.NewSecurityReqID(reqID)
+	responseID := field.NewSecurityResponseID("SL" + e.genExecID().Value())
+	requestResult := field.NewSecurityRequestResult(result)
+
+	var msg *quickfix.Message
+
+	switch sessionID.BeginString {
+	case quickfix.BeginStringFIX43:
+		msg = fix43sl.New(securityReqID, responseID, requestResult).ToMessage()
+	case quickfix.BeginStringFIX44:
+		msg = fix44sl.New(securityReqID, responseID, requestResult).ToMessage()
+		msg.Body.Set(field.NewTotNoRelatedSym(len(listed)))
+	default:
+		list := fix50sl.New()
+		list.Set(securityReqID)
+		list.Set(responseID)
+		list.Set(requestResult)
+		list.SetTotNoRelatedSym(len(listed))
+		msg = list.ToMessage()
+	}
+
+	if len(listed) == 0 {
+		return msg
+	}
+
+	template := quickfix.GroupTemplate{
+		quickfix.GroupElement(tag.Symbol),
+		quickfix.GroupElement(tag.SecurityStatus),
+		quickfix.GroupElement(tag.MinPriceIncrement),
+		quickfix.GroupElement(tag.Currency),
+		quickfix.GroupElement(tag.RoundLot),
+		quickfix.GroupElement(tag.MinTradeVol),
+		quickfix.GroupElement(tag.Text),
+	}
+
+	group := quickfix.NewRepeatingGroup(tag.NoRelatedSym, template)
+	for _, inst := range listed {
+		setInstrumentFields(&group.Add().FieldMap, inst, sessionID.BeginString)
+	}
+	msg.Body.SetGroup(group)
+
+	return msg
+}
+
+// securityDefinition builds a SecurityDefinition in the FIX version spoken
+// by sessionID.
+func (e *Server) securityDefinition(reqID string, response enum.SecurityResponseType, sessionID quickfix.SessionID) *quickfix.Message {
+
+	securityReqID := field.NewSecurityReqID(reqID)
+	responseID := field.NewSecurityResponseID("SD" + e.genExecID().Value())
+	responseType := field.NewSecurityResponseType(response)
+
+	switch sessionID.BeginString {
+	case quickfix.BeginStringFIX43:
+		return fix43sd.New(securityReqID, responseID, responseType).ToMessage()
+	case quickfix.BeginStringFIX44:
+		return fix44sd.New(securityReqID, responseID, responseType).ToMessage()
+	}
+
+	definition := fix50sd.New()
+	definition.Set(securityReqID)
+	definition.Set(responseID)
+	definition.Set(responseType)
+
+	return definition.ToMessage()
+}
+
+// setInstrumentFields describes inst in fields. FIX 5.0 has fields for the
+// tick size and status, older versions are given them as Text.
+func setInstrumentFields(fields *quickfix.FieldMap, inst instrument, beginString string) {
+
+	fields.Set(field.NewSymbol(inst.Symbol))
+
+	if inst.Currency != "" {
+		fields.Set(field.NewCurrency(inst.Currency))
+	}
+
+	if inst.LotSize.IsPositive() {
+		fields.Set(field.NewRoundLot(inst.LotSize, decimalPlaces(inst.LotSize)))
+		fields.Set(field.NewMinTradeVol(inst.LotSize, decimalPlaces(inst.LotSize)))
+	}
+
+	if beginString != quickfix.BeginStringFIXT11 {
+		fields.Set(field.NewText(inst.describe()))
+		return
+	}
+
+	status := enum.SecurityStatus_ACTIVE
+	if inst.Status != INSTRUMENT_ACTIVE {
+		status = enum.SecurityStatus_INACTIVE
+	}
+	fields.Set(field.NewSecurityStatus(status))
+
+	if inst.TickSize.IsPositive() {
+		fields.Set(field.NewMinPriceIncrement(inst.TickSize, decimalPlaces(inst.TickSize)))
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+	"github.com/shopspring/decimal"
+)
+
+// MinPriceIncrement, RoundLot and MinTradeVol are sent with as many decimal
+// places as the tick and lot sizes need, however they were written.
+func TestSecurityDefinitionScale(t *testing.T) {
+
+	tests := []struct {
+		size string
+		want string
+	}{
+		{"0.01", "0.01"},
+		{"0.050", "0.05"},
+		{"0.5", "0.5"},
+		{"5", "5"},
+		{"100", "100"},
+		{"1E-3", "0.001"},
+	}
+
+	for _, tt := range tests {
+		size := decimal.RequireFromString(tt.size)
+		inst := instrument{Symbol: "AAPL", TickSize: size, LotSize: size, Status: INSTRUMENT_ACTIVE}
+
+		msg := quickfix.NewMessage()
+		setInstrumentFields(&msg.Body.FieldMap, inst, quickfix.BeginStringFIXT11)
+
+		for _, sent := range []quickfix.Tag{tag.MinPriceIncrement, tag.RoundLot, tag.MinTradeVol} {
+			got, err := msg.Body.GetString(sent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("size %s sent in tag %d as %s, want %s", tt.size, sent, got, tt.want)
+			}
+		}
+	}
+}
@@ -7,64 +7,170 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/quickfixgo/enum"
 	"github.com/quickfixgo/field"
 	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/quickfix/store/file"
 	"github.com/quickfixgo/tag"
 	"github.com/shopspring/decimal"
 
-	fix50er "github.com/quickfixgo/fix50/executionreport"
+	fix40nos "github.com/quickfixgo/fix40/newordersingle"
+	fix40cxl "github.com/quickfixgo/fix40/ordercancelrequest"
+	fix40osr "github.com/quickfixgo/fix40/orderstatusrequest"
+	fix41nos "github.com/quickfixgo/fix41/newordersingle"
+	fix41cxl "github.com/quickfixgo/fix41/ordercancelrequest"
+	fix41osr "github.com/quickfixgo/fix41/orderstatusrequest"
+	fix42mdr "github.com/quickfixgo/fix42/marketdatarequest"
+	fix42nos "github.com/quickfixgo/fix42/newordersingle"
+	fix42cxl "github.com/quickfixgo/fix42/ordercancelrequest"
+	fix42osr "github.com/quickfixgo/fix42/orderstatusrequest"
+	fix42tssr "github.com/quickfixgo/fix42/tradingsessionstatusrequest"
+	fix43mdr "github.com/quickfixgo/fix43/marketdatarequest"
+	fix43nos "github.com/quickfixgo/fix43/newordersingle"
+	fix43cxl "github.com/quickfixgo/fix43/ordercancelrequest"
+	fix43omc "github.com/quickfixgo/fix43/ordermasscancelrequest"
+	fix43omsr "github.com/quickfixgo/fix43/ordermassstatusrequest"
+	fix43osr "github.com/quickfixgo/fix43/orderstatusrequest"
+	fix43sdr "github.com/quickfixgo/fix43/securitydefinitionrequest"
+	fix43slr "github.com/quickfixgo/fix43/securitylistrequest"
+	fix43tssr "github.com/quickfixgo/fix43/tradingsessionstatusrequest"
+	fix44mdr "github.com/quickfixgo/fix44/marketdatarequest"
+	fix44nos "github.com/quickfixgo/fix44/newordersingle"
+	fix44cxl "github.com/quickfixgo/fix44/ordercancelrequest"
+	fix44omc "github.com/quickfixgo/fix44/ordermasscancelrequest"
+	fix44omsr "github.com/quickfixgo/fix44/ordermassstatusrequest"
+	fix44osr "github.com/quickfixgo/fix44/orderstatusrequest"
+	fix44sdr "github.com/quickfixgo/fix44/securitydefinitionrequest"
+	fix44slr "github.com/quickfixgo/fix44/securitylistrequest"
+	fix44tssr "github.com/quickfixgo/fix44/tradingsessionstatusrequest"
+	fix50mdr "github.com/quickfixgo/fix50/marketdatarequest"
 	fix50nos "github.com/quickfixgo/fix50/newordersingle"
+	fix50ocrr "github.com/quickfixgo/fix50/ordercancelreplacerequest"
 	fix50cxl "github.com/quickfixgo/fix50/ordercancelrequest"
+	fix50omc "github.com/quickfixgo/fix50/ordermasscancelrequest"
+	fix50omsr "github.com/quickfixgo/fix50/ordermassstatusrequest"
 	fix50osr "github.com/quickfixgo/fix50/orderstatusrequest"
+	fix50sdr "github.com/quickfixgo/fix50/securitydefinitionrequest"
+	fix50slr "github.com/quickfixgo/fix50/securitylistrequest"
+	fix50tssr "github.com/quickfixgo/fix50/tradingsessionstatusrequest"
 )
 
 type Server struct {
-	nsoChannel       chan *fix50nos.NewOrderSingle
-	cancelChannel    chan *fix50cxl.OrderCancelRequest
-	queryChannel     chan *fix50osr.OrderStatusRequest
-	marketChannel    chan OrderExecutionStatus
-	queryRespChannel chan *SingleOrder
-	orderID          int
-	execID           int
+	nsoChannel        chan *newOrderRequest
+	cancelChannel     chan *cancelRequest
+	replaceChannel    chan *replaceRequest
+	queryChannel      chan *queryRequest
+	expiryChannel     chan *SingleOrder
+	auctionChannel    chan *auctionReport
+	bookChannel       chan *bookUpdate
+	massCancelChannel chan *massCancelRequest
+	massStatusChannel chan *massStatusRequest
+	execID            atomic.Int64
+	kills             *killSwitches
+	instruments       *instrumentMaster
+	risk              *riskEngine
+	session           *tradingSession
+	md                *marketData
+
+	// sessions currently logged on, to tell about changes of phase and
+	// auction prices
+	loggedOnMu sync.Mutex
+	loggedOn   map[quickfix.SessionID]bool
+
 	*quickfix.MessageRouter
 }
 
 func newServer() *Server {
-	e := &Server{MessageRouter: quickfix.NewMessageRouter()}
-
-	e.nsoChannel = make(chan *fix50nos.NewOrderSingle)
-	e.cancelChannel = make(chan *fix50cxl.OrderCancelRequest)
-	e.queryChannel = make(chan *fix50osr.OrderStatusRequest)
-	e.marketChannel = make(chan OrderExecutionStatus)
-	e.queryRespChannel = make(chan *SingleOrder)
+	e := &Server{MessageRouter: quickfix.NewMessageRouter(), loggedOn: make(map[quickfix.SessionID]bool), md: newMarketData()}
+
+	e.nsoChannel = make(chan *newOrderRequest)
+	e.cancelChannel = make(chan *cancelRequest)
+	e.replaceChannel = make(chan *replaceRequest)
+	e.queryChannel = make(chan *queryRequest)
+	e.expiryChannel = make(chan *SingleOrder)
+	e.auctionChannel = make(chan *auctionReport)
+	e.bookChannel = make(chan *bookUpdate)
+	e.massCancelChannel = make(chan *massCancelRequest)
+	e.massStatusChannel = make(chan *massStatusRequest)
+
+	e.AddRoute(fix40nos.Route(e.OnFIX40NewOrderSingle))
+	e.AddRoute(fix40cxl.Route(e.onFIX40OrderCancelRequest))
+	e.AddRoute(fix40osr.Route(e.onFIX40OrderStatusRequest))
+
+	e.AddRoute(fix41nos.Route(e.OnFIX41NewOrderSingle))
+	e.AddRoute(fix41cxl.Route(e.onFIX41OrderCancelRequest))
+	e.AddRoute(fix41osr.Route(e.onFIX41OrderStatusRequest))
+
+	e.AddRoute(fix42nos.Route(e.OnFIX42NewOrderSingle))
+	e.AddRoute(fix42cxl.Route(e.onFIX42OrderCancelRequest))
+	e.AddRoute(fix42osr.Route(e.onFIX42OrderStatusRequest))
+	e.AddRoute(fix42tssr.Route(e.onFIX42TradingSessionStatusRequest))
+	e.AddRoute(fix42mdr.Route(e.onFIX42MarketDataRequest))
+
+	e.AddRoute(fix43nos.Route(e.OnFIX43NewOrderSingle))
+	e.AddRoute(fix43cxl.Route(e.onFIX43OrderCancelRequest))
+	e.AddRoute(fix43osr.Route(e.onFIX43OrderStatusRequest))
+	e.AddRoute(fix43omc.Route(e.onFIX43OrderMassCancelRequest))
+	e.AddRoute(fix43omsr.Route(e.onFIX43OrderMassStatusRequest))
+	e.AddRoute(fix43slr.Route(e.onFIX43SecurityListRequest))
+	e.AddRoute(fix43sdr.Route(e.onFIX43SecurityDefinitionRequest))
+	e.AddRoute(fix43tssr.Route(e.onFIX43TradingSessionStatusRequest))
+	e.AddRoute(fix43mdr.Route(e.onFIX43MarketDataRequest))
+
+	e.AddRoute(fix44nos.Route(e.OnFIX44NewOrderSingle))
+	e.AddRoute(fix44cxl.Route(e.onFIX44OrderCancelRequest))
+	e.AddRoute(fix44osr.Route(e.onFIX44OrderStatusRequest))
+	e.AddRoute(fix44omc.Route(e.onFIX44OrderMassCancelRequest))
+	e.AddRoute(fix44omsr.Route(e.onFIX44OrderMassStatusRequest))
+	e.AddRoute(fix44slr.Route(e.onFIX44SecurityListRequest))
+	e.AddRoute(fix44sdr.Route(e.onFIX44SecurityDefinitionRequest))
+	e.AddRoute(fix44tssr.Route(e.onFIX44TradingSessionStatusRequest))
+	e.AddRoute(fix44mdr.Route(e.onFIX44MarketDataRequest))
 
 	e.AddRoute(fix50nos.Route(e.OnFIX50NewOrderSingle))
 	e.AddRoute(fix50cxl.Route(e.onFIX50OrderCancelRequest))
+	e.AddRoute(fix50ocrr.Route(e.onFIX50OrderCancelReplaceRequest))
 	e.AddRoute(fix50osr.Route(e.onFix50OrderStatusRequest))
+	e.AddRoute(fix50omc.Route(e.onFIX50OrderMassCancelRequest))
+	e.AddRoute(fix50omsr.Route(e.onFIX50OrderMassStatusRequest))
+	e.AddRoute(fix50slr.Route(e.onFIX50SecurityListRequest))
+	e.AddRoute(fix50sdr.Route(e.onFIX50SecurityDefinitionRequest))
+	e.AddRoute(fix50tssr.Route(e.onFIX50TradingSessionStatusRequest))
+	e.AddRoute(fix50mdr.Route(e.onFIX50MarketDataRequest))
 
 	return e
 }
 
-func (e *Server) genOrderID() field.OrderIDField {
-	e.orderID++
-	return field.NewOrderID(strconv.Itoa(e.orderID))
-}
-
 func (e *Server) genExecID() field.ExecIDField {
-	e.execID++
-	return field.NewExecID(strconv.Itoa(e.execID))
+	return field.NewExecID(strconv.FormatInt(e.execID.Add(1), 10))
 }
 
 // quickfix.Application interface
-func (e Server) OnCreate(sessionID quickfix.SessionID)                           {}
-func (e Server) OnLogon(sessionID quickfix.SessionID)                            {}
-func (e Server) OnLogout(sessionID quickfix.SessionID)                           {}
-func (e Server) ToAdmin(msg *quickfix.Message, sessionID quickfix.SessionID)     {}
-func (e Server) ToApp(msg *quickfix.Message, sessionID quickfix.SessionID) error { return nil }
-func (e Server) FromAdmin(msg *quickfix.Message, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+func (e *Server) OnCreate(sessionID quickfix.SessionID) {}
+func (e *Server) OnLogon(sessionID quickfix.SessionID) {
+	e.loggedOnMu.Lock()
+	e.loggedOn[sessionID] = true
+	e.loggedOnMu.Unlock()
+
+	e.sendPhase(e.session.current(), sessionID)
+}
+
+func (e *Server) OnLogout(sessionID quickfix.SessionID) {
+	e.loggedOnMu.Lock()
+	delete(e.loggedOn, sessionID)
+	e.loggedOnMu.Unlock()
+
+	e.md.unsubscribeAll(sessionID)
+}
+
+func (e *Server) ToAdmin(msg *quickfix.Message, sessionID quickfix.SessionID)     {}
+func (e *Server) ToApp(msg *quickfix.Message, sessionID quickfix.SessionID) error { return nil }
+func (e *Server) FromAdmin(msg *quickfix.Message, sessionID quickfix.SessionID) quickfix.MessageRejectError {
 	return nil
 }
 
@@ -79,186 +185,471 @@ func (e *Server) onFix50OrderStatusRequest(msg fix50osr.OrderStatusRequest, sess
 		return quickfix.ValueIsIncorrect(tag.OrdStatusReqID)
 	}
 
-	if !msg.HasSenderSubID() {
-		return quickfix.ValueIsIncorrect(tag.SenderSubID)
+	req, err := newQueryRequest(msg)
+	if err != nil {
+		return err
 	}
 
-	if !msg.HasSymbol() {
-		return quickfix.ValueIsIncorrect(tag.Symbol)
+	// the web client asks about the order named in OrdStatusReqID
+	req.id, _ = msg.GetOrdStatusReqID()
+
+	e.queryOrder(req, req.id, sessionID)
+
+	return nil
+
+}
+
+func (e *Server) onFIX50OrderCancelRequest(msg fix50cxl.OrderCancelRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onOrderCancelRequest(msg, sessionID)
+}
+
+func (e *Server) onFIX50OrderCancelReplaceRequest(msg fix50ocrr.OrderCancelReplaceRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+
+	cancel, err := newCancelRequest(msg)
+	if err != nil {
+		return err
 	}
 
-	side, _ := msg.GetSide()
-	symbol, _ := msg.GetSymbol()
-	subId, _ := msg.GetSenderSubID()
+	req := &replaceRequest{cancelRequest: *cancel}
 
-	execReport := fix50er.New(
-		e.genOrderID(),
-		e.genExecID(),
-		field.NewExecType(enum.ExecType_ORDER_STATUS),
-		field.NewOrdStatus(enum.OrdStatus_PARTIALLY_FILLED),
-		field.NewSide(side),
-		field.NewLeavesQty(decimal.Zero, 2),
-		field.NewCumQty(decimal.Zero, 2),
-	)
+	if req.volume, err = msg.GetOrderQty(); err != nil {
+		return err
+	}
 
-	execReport.SetTargetSubID(subId)
-	execReport.SetSymbol(symbol)
+	if !req.volume.IsPositive() {
+		return quickfix.ValueIsIncorrect(tag.OrderQty)
+	}
 
-	e.queryChannel <- &msg
+	ot, err := msg.GetOrdType()
+	if err != nil {
+		return err
+	}
 
-	resp := <-e.marketChannel
+	var ok bool
+	if req.orderType, ok = orderTypeFromFix(ot); !ok {
+		return quickfix.ValueIsIncorrect(tag.OrdType)
+	}
 
-	switch resp {
-	case QUERY_NO_SUCH_ORDER:
-		return quickfix.ValueIsIncorrect(tag.OrdStatusReqID)
-	case QUERY_ORDER_FOUND:
+	// only limit-type orders have a price to amend, and only stops a StopPx
+	if req.orderType == LIMIT || req.orderType == STOP_LIMIT {
+		if req.price, err = msg.GetPrice(); err != nil {
+			return err
+		}
 
-		order := <-e.queryRespChannel
+		if !req.price.IsPositive() {
+			return quickfix.ValueIsIncorrect(tag.Price)
+		}
+	}
 
-		execReport.SetPrice(order.price, 0)
-		execReport.SetOrderQty(order.volume, 0)
-		execReport.SetText("Order Is Considered Half Filled Filled")
-		execReport.SetLeavesQty(order.volume.Div(decimal.NewFromInt(2)), 0)
-		execReport.SetCumQty(order.volume.Div(decimal.NewFromInt(2)), 0)
+	if req.orderType == STOP || req.orderType == STOP_LIMIT {
+		if req.stopPx, err = msg.GetStopPx(); err != nil {
+			return quickfix.ConditionallyRequiredFieldMissing(tag.StopPx)
+		}
 
+		if !req.stopPx.IsPositive() {
+			return quickfix.ValueIsIncorrect(tag.StopPx)
+		}
 	}
 
-	quickfix.SendToTarget(execReport, sessionID)
+	e.replaceOrder(req, sessionID)
 
 	return nil
+}
 
+func (e *Server) OnFIX50NewOrderSingle(msg fix50nos.NewOrderSingle, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onNewOrderSingle(msg, sessionID)
 }
 
-func (e *Server) onFIX50OrderCancelRequest(msg fix50cxl.OrderCancelRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+// placeOrder hands a new order to the market and reports how it got on.
+func (e *Server) placeOrder(order *SingleOrder, sessionID quickfix.SessionID) {
+
+	if ks := e.kills.blocking(sessionID.TargetCompID, order.user); ks != nil {
+		order.orderID = "NONE"
+		order.transition(ORDER_REJECTED)
+
+		execReport := e.execReport(order, enum.ExecType_REJECTED, sessionID)
+		execReport.Body.Set(field.NewOrdRejReason(enum.OrdRejReason_BROKER))
+		execReport.Body.Set(field.NewText(killSwitchText(ks)))
+
+		e.send(execReport, sessionID)
+		fmt.Printf("New Single Order (%s) From %s NOT Placed, Kill Switch Engaged\n\r", order.id, order.user)
+		return
+	}
+
+	if rejection := e.instruments.validate(order); rejection != nil {
+		order.orderID = "NONE"
+		order.transition(ORDER_REJECTED)
+
+		execReport := e.execReport(order, enum.ExecType_REJECTED, sessionID)
+		execReport.Body.Set(field.NewOrdRejReason(ordRejReasonFor(rejection.reason, sessionID.BeginString)))
+		execReport.Body.Set(field.NewText(rejection.text))
 
-	if !msg.HasClOrdID() {
-		return quickfix.ValueIsIncorrect(tag.ClOrdID)
+		e.send(execReport, sessionID)
+		fmt.Printf("New Single Order (%s) From %s NOT Placed, %s\n\r", order.id, order.user, rejection.text)
+		return
 	}
 
-	if !msg.HasOrigClOrdID() {
-		return quickfix.ValueIsIncorrect(tag.OrigClOrdID)
+	if breach := e.risk.check(order); breach != nil {
+		order.orderID = "NONE"
+		order.transition(ORDER_REJECTED)
+
+		execReport := e.execReport(order, enum.ExecType_REJECTED, sessionID)
+		execReport.Body.Set(field.NewOrdRejReason(ordRejReasonFor(breach.reason, sessionID.BeginString)))
+		execReport.Body.Set(field.NewText(breach.text))
+
+		e.send(execReport, sessionID)
+		fmt.Printf("New Single Order (%s) From %s NOT Placed, %s\n\r", order.id, order.user, breach.text)
+		return
 	}
 
-	if !msg.HasSenderSubID() {
-		return quickfix.ValueIsIncorrect(tag.SenderSubID)
+	req := &newOrderRequest{order: order, reply: make(chan marketResult, 1)}
+	e.nsoChannel <- req
+
+	res := <-req.reply
+	order = res.order
+
+	switch res.status {
+	case NSO_FAILED_ORDER_EXISTS:
+		execReport := e.execReport(order, enum.ExecType_REJECTED, sessionID)
+		execReport.Body.Set(field.NewOrdRejReason(enum.OrdRejReason_DUPLICATE_ORDER))
+		execReport.Body.Set(field.NewText("Duplicate Order Placed"))
+
+		e.send(execReport, sessionID)
+		return
+
+	case NSO_FAILED_TOO_LATE:
+		execReport := e.execReport(order, enum.ExecType_REJECTED, sessionID)
+		execReport.Body.Set(field.NewOrdRejReason(enum.OrdRejReason_TOO_LATE_TO_ENTER))
+		execReport.Body.Set(field.NewText("ExpireTime Has Already Passed"))
+
+		e.send(execReport, sessionID)
+		return
+
+	case NSO_FAILED, NSO_FAILED_PHASE:
+		execReport := e.execReport(order, enum.ExecType_REJECTED, sessionID)
+		execReport.Body.Set(field.NewOrdRejReason(res.rejection.reason))
+		execReport.Body.Set(field.NewText(res.rejection.text))
+
+		e.send(execReport, sessionID)
+		return
 	}
 
-	if !msg.HasSymbol() {
-		return quickfix.ValueIsIncorrect(tag.Symbol)
+	// acknowledge the order before reporting anything it matched against
+	e.send(e.execReport(order, enum.ExecType_NEW, sessionID), sessionID)
+
+	e.sendTrades(res.trades)
+	e.sendRemainderCanceled(res.canceled)
+	e.sendReleases(res.released)
+}
+
+// reportExpiries tells the owner of each Day or GTD order that runs out of
+// time that it has expired.
+func (e *Server) reportExpiries() {
+	for order := range e.expiryChannel {
+		execReport := e.execReport(order, enum.ExecType_EXPIRED, order.sessionID)
+		execReport.Body.Set(field.NewText("Order Has Expired"))
+
+		e.send(execReport, order.sessionID)
 	}
+}
 
-	e.cancelChannel <- &msg
+func (e *Server) cancelOrder(req *cancelRequest, sessionID quickfix.SessionID) {
 
-	mkrt := <-e.marketChannel
+	req.reply = make(chan marketResult, 1)
+	e.cancelChannel <- req
 
-	side, _ := msg.GetSide()
-	symbol, _ := msg.GetSymbol()
-	subId, _ := msg.GetSenderSubID()
+	res := <-req.reply
 
-	execReport := fix50er.New(
-		e.genOrderID(),
-		e.genExecID(),
-		field.NewExecType(enum.ExecType_CANCELED),
-		field.NewOrdStatus(enum.OrdStatus_CANCELED),
-		field.NewSide(side),
-		field.NewLeavesQty(decimal.Zero, 2),
-		field.NewCumQty(decimal.Zero, 2),
-	)
+	if res.status == CANCEL_CANCELLED {
+		execReport := e.execReport(res.order, enum.ExecType_CANCELED, sessionID)
+		execReport.Body.Set(field.NewClOrdID(req.id))
+		execReport.Body.Set(field.NewOrigClOrdID(req.origID))
+		execReport.Body.Set(field.NewText("Order has Been Cancelled"))
 
-	execReport.SetTargetSubID(subId)
-	execReport.SetSymbol(symbol)
+		e.send(execReport, sessionID)
+		return
+	}
 
-	switch mkrt {
-	case CANCEL_CANCELLED:
-		execReport.SetText("Order has Been Cancelled")
+	var reject *quickfix.Message
 
+	switch res.status {
 	case CANCEL_FAILED:
-		execReport.SetOrdStatus(enum.OrdStatus_REJECTED)
-		execReport.SetOrdRejReason(enum.OrdRejReason_BROKER)
-		execReport.SetText("Failed To Cancel Order - Unkown Reason.")
+		reject = e.cancelReject(res.order, req, enum.CxlRejResponseTo_ORDER_CANCEL_REQUEST, enum.CxlRejReason_TOO_LATE_TO_CANCEL, sessionID)
+		reject.Body.Set(field.NewText("Failed To Cancel Order - Order Is No Longer Open"))
 
 	case CANCEL_NO_SUCH_ORDER:
-		execReport.SetOrdStatus(enum.OrdStatus_REJECTED)
-		execReport.SetOrdRejReason(enum.OrdRejReason_BROKER)
-		execReport.SetText("Failed To Cancel Order - No Such Order")
+		order := unknownOrder(req.user, req.symbol, req.origID, req.side)
+		reject = e.cancelReject(order, req, enum.CxlRejResponseTo_ORDER_CANCEL_REQUEST, enum.CxlRejReason_UNKNOWN_ORDER, sessionID)
+		reject.Body.Set(field.NewText("Failed To Cancel Order - No Such Order"))
 
 	}
 
-	quickfix.SendToTarget(execReport, sessionID)
-
-	return nil
+	e.send(reject, sessionID)
 }
 
-func (e *Server) OnFIX50NewOrderSingle(msg fix50nos.NewOrderSingle, sessionID quickfix.SessionID) (err quickfix.MessageRejectError) {
+func (e *Server) replaceOrder(req *replaceRequest, sessionID quickfix.SessionID) {
 
-	//ordType, err := msg.GetOrdType()
-	if err != nil {
-		return err
-	}
+	if ks := e.kills.blocking(sessionID.TargetCompID, req.user); ks != nil {
+		order := unknownOrder(req.user, req.symbol, req.origID, req.side)
+		reject := e.cancelReject(order, &req.cancelRequest, enum.CxlRejResponseTo_ORDER_CANCEL_REPLACE_REQUEST, enum.CxlRejReason_BROKER, sessionID)
+		reject.Body.Set(field.NewText(killSwitchText(ks)))
 
-	symbol, err := msg.GetSymbol()
-	if err != nil {
+		e.send(reject, sessionID)
 		return
 	}
 
-	side, err := msg.GetSide()
-	if err != nil {
+	if rejection := e.instruments.validateReplace(req); rejection != nil {
+		reason := enum.CxlRejReason_BROKER
+		if rejection.reason == enum.OrdRejReason_INVALID_PRICE_INCREMENT {
+			reason = enum.CxlRejReason_INVALID_PRICE_INCREMENT
+		}
+
+		order := unknownOrder(req.user, req.symbol, req.origID, req.side)
+		reject := e.cancelReject(order, &req.cancelRequest, enum.CxlRejResponseTo_ORDER_CANCEL_REPLACE_REQUEST, reason, sessionID)
+		reject.Body.Set(field.NewText("Failed To Replace Order - " + rejection.text))
+
+		e.send(reject, sessionID)
+		fmt.Printf("Order (%s) NOT Replaced With (%s) by %s, %s \n\r", req.origID, req.id, req.user, rejection.text)
 		return
 	}
 
-	orderQty, err := msg.GetOrderQty()
-	if err != nil {
+	if breach := e.risk.checkReplace(req); breach != nil {
+		order := unknownOrder(req.user, req.symbol, req.origID, req.side)
+		reject := e.cancelReject(order, &req.cancelRequest, enum.CxlRejResponseTo_ORDER_CANCEL_REPLACE_REQUEST, enum.CxlRejReason_BROKER, sessionID)
+		reject.Body.Set(field.NewText("Failed To Replace Order - " + breach.text))
+
+		e.send(reject, sessionID)
+		fmt.Printf("Order (%s) NOT Replaced With (%s) by %s, %s \n\r", req.origID, req.id, req.user, breach.text)
 		return
 	}
 
-	price, err := msg.GetPrice()
-	if err != nil {
+	req.reply = make(chan marketResult, 1)
+	e.replaceChannel <- req
+
+	res := <-req.reply
+
+	if res.status == REPLACE_REPLACED {
+		execReport := e.execReport(res.order, enum.ExecType_REPLACED, sessionID)
+		execReport.Body.Set(field.NewOrigClOrdID(req.origID))
+		execReport.Body.Set(field.NewText("Order has Been Replaced"))
+
+		e.send(execReport, sessionID)
+
+		e.sendTrades(res.trades)
+		e.sendReleases(res.released)
 		return
 	}
 
-	clOrdID, err := msg.GetClOrdID()
-	if err != nil {
+	var reject *quickfix.Message
+	responseTo := enum.CxlRejResponseTo_ORDER_CANCEL_REPLACE_REQUEST
+
+	switch res.status {
+	case REPLACE_FAILED:
+		order := res.order
+
+		if order.state.isOpen() {
+			reject = e.cancelReject(order, &req.cancelRequest, responseTo, enum.CxlRejReason_OTHER, sessionID)
+			reject.Body.Set(field.NewText("Failed To Replace Order - Quantity Already Filled"))
+		} else {
+			reject = e.cancelReject(order, &req.cancelRequest, responseTo, enum.CxlRejReason_TOO_LATE_TO_CANCEL, sessionID)
+			reject.Body.Set(field.NewText("Failed To Replace Order - Order Is No Longer Open"))
+		}
+
+	case REPLACE_FAILED_PHASE, REPLACE_FAILED_ORDER_TYPE:
+		reject = e.cancelReject(res.order, &req.cancelRequest, responseTo, enum.CxlRejReason_BROKER, sessionID)
+		reject.Body.Set(field.NewText("Failed To Replace Order - " + res.rejection.text))
+
+	case REPLACE_FAILED_ORDER_EXISTS:
+		reject = e.cancelReject(res.order, &req.cancelRequest, responseTo, enum.CxlRejReason_DUPLICATE_CLORDID, sessionID)
+		reject.Body.Set(field.NewText("Failed To Replace Order - Duplicate Order ID"))
+
+	case REPLACE_NO_SUCH_ORDER:
+		order := unknownOrder(req.user, req.symbol, req.origID, req.side)
+		reject = e.cancelReject(order, &req.cancelRequest, responseTo, enum.CxlRejReason_UNKNOWN_ORDER, sessionID)
+		reject.Body.Set(field.NewText("Failed To Replace Order - No Such Order"))
+
+	}
+
+	e.send(reject, sessionID)
+}
+
+// queryOrder reports the current state of an order. reqID is echoed back as
+// the OrdStatusReqID when the request had one.
+func (e *Server) queryOrder(req *queryRequest, reqID string, sessionID quickfix.SessionID) {
+
+	req.reply = make(chan marketResult, 1)
+	e.queryChannel <- req
+
+	res := <-req.reply
+
+	var execReport *quickfix.Message
+
+	switch res.status {
+	case QUERY_NO_SUCH_ORDER:
+		order := unknownOrder(req.user, req.symbol, req.id, req.side)
+		execReport = e.execReport(order, enum.ExecType_ORDER_STATUS, sessionID)
+		execReport.Body.Set(field.NewOrdRejReason(enum.OrdRejReason_UNKNOWN_ORDER))
+		execReport.Body.Set(field.NewText("No Such Order"))
+
+	case QUERY_ORDER_FOUND:
+		execReport = e.execReport(res.order, enum.ExecType_ORDER_STATUS, sessionID)
+	}
+
+	if reqID != "" {
+		execReport.Body.Set(field.NewOrdStatusReqID(reqID))
+	}
+
+	e.send(execReport, sessionID)
+}
+
+// sendTrades reports both sides of each trade to the sessions that own them.
+func (e *Server) sendTrades(trades []Trade) {
+	for _, trade := range trades {
+		e.send(e.execReport(&trade.aggressor, enum.ExecType_TRADE, trade.aggressor.sessionID), trade.aggressor.sessionID)
+		e.send(e.execReport(&trade.passive, enum.ExecType_TRADE, trade.passive.sessionID), trade.passive.sessionID)
+	}
+}
+
+// sendRemainderCanceled reports that what was left of an IOC, FOK or market
+// order was cancelled, as it could not rest in the book.
+func (e *Server) sendRemainderCanceled(order *SingleOrder) {
+	if order == nil {
 		return
 	}
 
-	execReport := fix50er.New(
-		e.genOrderID(),
-		e.genExecID(),
-		field.NewExecType(enum.ExecType_FILL),
-		field.NewOrdStatus(enum.OrdStatus_FILLED),
-		field.NewSide(side),
-		field.NewLeavesQty(decimal.Zero, 2),
-		field.NewCumQty(orderQty, 2),
-	)
+	kind := order.timeInForce.String()
+	if order.isMarket() {
+		kind = "Market"
+	}
+
+	execReport := e.execReport(order, enum.ExecType_CANCELED, order.sessionID)
+	execReport.Body.Set(field.NewText(kind + " Order Not Filled, Remainder Cancelled"))
 
-	execReport.SetClOrdID(clOrdID)
-	execReport.SetSymbol(symbol)
-	execReport.SetOrderQty(orderQty, 2)
-	execReport.SetLastQty(orderQty, 2)
-	execReport.SetLastPx(price, 2)
-	execReport.SetAvgPx(price, 2)
+	e.send(execReport, order.sessionID)
+}
+
+// sendReleases tells the owner of each stop order that triggered, then
+// reports what it traded.
+func (e *Server) sendReleases(released []stopRelease) {
+	for _, release := range released {
+		order := release.order
 
-	e.nsoChannel <- &msg
+		execReport := e.execReport(&order, enum.ExecType_TRIGGERED_OR_ACTIVATED_BY_SYSTEM, order.sessionID)
+		execReport.Body.Set(field.NewText("Stop Price Reached, Order Triggered"))
+		e.send(execReport, order.sessionID)
 
-	mkrt := <-e.marketChannel
-	if mkrt == NSO_FAILED_ORDER_EXISTS {
-		execReport.SetOrdStatus(enum.OrdStatus_REJECTED)
-		execReport.SetOrdRejReason(enum.OrdRejReason_DUPLICATE_ORDER)
-		execReport.SetText("Duplicate Order Placed")
+		e.sendTrades(release.trades)
+		e.sendRemainderCanceled(release.canceled)
 	}
+}
 
-	sendErr := quickfix.SendToTarget(execReport, sessionID)
+func (e *Server) send(msg quickfix.Messagable, sessionID quickfix.SessionID) {
+	sendErr := quickfix.SendToTarget(msg, sessionID)
 
 	if sendErr != nil {
 		fmt.Println("Failed To Send", sendErr)
 	}
-
-	return
 }
 
 //go:embed config/Server.cfg
 var cfgFS embed.FS
 
+// newMessageStoreFactory picks the message store named by MessageStore in
+// the [DEFAULT] section. "file" (FileStorePath) keeps sequence numbers and
+// sent messages across restarts so ResendRequests can be answered,
+// "memory" forgets them.
+func newMessageStoreFactory(appSettings *quickfix.Settings) (quickfix.MessageStoreFactory, error) {
+
+	store := "memory"
+	if appSettings.GlobalSettings().HasSetting("MessageStore") {
+		store, _ = appSettings.GlobalSettings().Setting("MessageStore")
+	}
+
+	switch store {
+	case "memory":
+		return quickfix.NewMemoryStoreFactory(), nil
+	case "file":
+		return file.NewStoreFactory(appSettings), nil
+	}
+
+	return nil, fmt.Errorf("unknown MessageStore %q, expected memory or file", store)
+}
+
+// marketCloseTime reads MarketCloseTime (HH:MM:SS, UTC), when Day orders
+// expire. Without one they last until midnight UTC.
+func marketCloseTime(settings *quickfix.SessionSettings) time.Duration {
+
+	if !settings.HasSetting("MarketCloseTime") {
+		return 24 * time.Hour
+	}
+
+	value, _ := settings.Setting("MarketCloseTime")
+
+	close, err := timeOfDay(value)
+	if err != nil {
+		log.Fatalf("Bad MarketCloseTime %s \n\r", err)
+	}
+
+	return close
+}
+
+// marketProtection reads MarketProtectionPercent, how far past the best
+// opposite price a market order may trade before the rest of it is
+// cancelled. It defaults to 5%, and 0 turns protection off.
+func marketProtection(settings *quickfix.SessionSettings) decimal.Decimal {
+
+	if !settings.HasSetting("MarketProtectionPercent") {
+		return decimal.NewFromInt(5).Div(decimal.NewFromInt(100))
+	}
+
+	value, _ := settings.Setting("MarketProtectionPercent")
+
+	percent, err := decimal.NewFromString(value)
+	if err != nil || percent.IsNegative() {
+		log.Fatalf("Bad MarketProtectionPercent %s \n\r", value)
+	}
+
+	return percent.Div(decimal.NewFromInt(100))
+}
+
+// openServerJournal opens the journal named by JournalPath, or returns nil
+// to run without one. JournalSnapshotEvery (events) and
+// JournalSnapshotInterval (seconds) control how often it is compacted.
+func openServerJournal(settings *quickfix.SessionSettings) (*journal, time.Duration) {
+
+	if !settings.HasSetting("JournalPath") {
+		fmt.Println("No JournalPath Configured, Orders Will Not Survive A Restart")
+		return nil, 0
+	}
+
+	path, _ := settings.Setting("JournalPath")
+
+	snapshotEvery := 1000
+	if settings.HasSetting("JournalSnapshotEvery") {
+		every, err := settings.IntSetting("JournalSnapshotEvery")
+		if err != nil {
+			log.Fatalf("Bad JournalSnapshotEvery %s \n\r", err)
+		}
+		snapshotEvery = every
+	}
+
+	interval := 60
+	if settings.HasSetting("JournalSnapshotInterval") {
+		seconds, err := settings.IntSetting("JournalSnapshotInterval")
+		if err != nil {
+			log.Fatalf("Bad JournalSnapshotInterval %s \n\r", err)
+		}
+		interval = seconds
+	}
+
+	journal, err := openJournal(path, snapshotEvery)
+	if err != nil {
+		log.Fatalf("Failed to Open Journal %s \n\r", err)
+	}
+
+	return journal, time.Duration(interval) * time.Second
+}
+
 func main() {
 
 	//cfg, err := os.Open("./Server.cfg")
@@ -278,15 +669,67 @@ func main() {
 
 	app := newServer()
 
+	killSwitchPath := "tmp/killswitches.json"
+	if appSettings.GlobalSettings().HasSetting("KillSwitchPath") {
+		killSwitchPath, _ = appSettings.GlobalSettings().Setting("KillSwitchPath")
+	}
+
+	if app.kills, err = loadKillSwitches(killSwitchPath); err != nil {
+		log.Fatalf("Failed to Load Kill Switches %s \n\r", err)
+	}
+
+	if app.instruments, err = instrumentSettings(appSettings.GlobalSettings()); err != nil {
+		log.Fatalf("Failed to Load Instruments %s \n\r", err)
+	}
+
+	schedule, err := tradingSchedule(appSettings.GlobalSettings())
+	if err != nil {
+		log.Fatalf("Failed to Read Trading Schedule %s \n\r", err)
+	}
+
+	app.session = newTradingSession(schedule)
+	fmt.Printf("Market Is In %s \n\r", app.session.current())
+
+	if appSettings.GlobalSettings().HasSetting("AdminAddress") {
+		adminAddress, _ := appSettings.GlobalSettings().Setting("AdminAddress")
+		go app.serveAdmin(adminAddress)
+	}
+
+	journal, snapshotInterval := openServerJournal(appSettings.GlobalSettings())
+
+	risk, err := riskSettings(appSettings.GlobalSettings())
+
+	if err != nil {
+		log.Fatalf("Failed to Load Risk Limits %s \n\r", err)
+	}
+
+	app.risk = risk
+
 	market := market{
-		nsoChannel:       app.nsoChannel,
-		cancelChannel:    app.cancelChannel,
-		queryChannel:     app.queryChannel,
-		respChannel:      app.marketChannel,
-		queryRespChannel: app.queryRespChannel,
+		nsoChannel:        app.nsoChannel,
+		cancelChannel:     app.cancelChannel,
+		replaceChannel:    app.replaceChannel,
+		queryChannel:      app.queryChannel,
+		expiryChannel:     app.expiryChannel,
+		auctionChannel:    app.auctionChannel,
+		bookChannel:       app.bookChannel,
+		massCancelChannel: app.massCancelChannel,
+		massStatusChannel: app.massStatusChannel,
+		closeTime:         marketCloseTime(appSettings.GlobalSettings()),
+		protection:        marketProtection(appSettings.GlobalSettings()),
+		journal:           journal,
+		risk:              risk,
+		kills:             app.kills,
+		phaseChannel:      make(chan TradingPhase),
+		phase:             app.session.current(),
 	}
 
 	market.startMarket()
+	go app.session.run(market.phaseChannel, app.broadcastPhase)
+	go app.reportExpiries()
+	go app.reportAuctions()
+	go app.publishMarketData()
+	go journal.compactEvery(snapshotInterval)
 
 	logFactory, err := quickfix.NewFileLogFactory(appSettings)
 
@@ -297,11 +740,21 @@ func main() {
 
 	}
 
+	storeFactory, err := newMessageStoreFactory(appSettings)
+
+	if err != nil {
+		log.Fatalf("Failed to Create Message Store %s \n\r", err)
+	}
+
 	acceptor, err := quickfix.NewAcceptor(app,
-		quickfix.NewMemoryStoreFactory(),
+		storeFactory,
 		appSettings,
 		logFactory)
 
+	if err != nil {
+		log.Fatalf("Failed to Create Acceptor %s \n\r", err)
+	}
+
 	err = acceptor.Start()
 
 	interrupt := make(chan os.Signal, 1)
@@ -310,6 +763,7 @@ func main() {
 
 	fmt.Println("Stopping Acceptor Service")
 	acceptor.Stop()
+	journal.close()
 	fmt.Println("Stopped")
 
 }
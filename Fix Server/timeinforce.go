@@ -0,0 +1,85 @@
+package main
+
+import (
+	"time"
+
+	"github.com/quickfixgo/enum"
+)
+
+// TimeInForce is how long an order stays working.
+type TimeInForce int
+
+const (
+	TIF_DAY TimeInForce = iota
+	TIF_GTC
+	TIF_IOC
+	TIF_FOK
+	TIF_GTD
+)
+
+// timeInForceFromFix maps TimeInForce (59) onto the ones the exchange
+// supports. A missing TimeInForce means Day.
+func timeInForceFromFix(tif enum.TimeInForce) (TimeInForce, bool) {
+	switch tif {
+	case "", enum.TimeInForce_DAY:
+		return TIF_DAY, true
+	case enum.TimeInForce_GOOD_TILL_CANCEL:
+		return TIF_GTC, true
+	case enum.TimeInForce_IMMEDIATE_OR_CANCEL:
+		return TIF_IOC, true
+	case enum.TimeInForce_FILL_OR_KILL:
+		return TIF_FOK, true
+	case enum.TimeInForce_GOOD_TILL_DATE:
+		return TIF_GTD, true
+	}
+	return TIF_DAY, false
+}
+
+func (tif TimeInForce) toFix() enum.TimeInForce {
+	switch tif {
+	case TIF_GTC:
+		return enum.TimeInForce_GOOD_TILL_CANCEL
+	case TIF_IOC:
+		return enum.TimeInForce_IMMEDIATE_OR_CANCEL
+	case TIF_FOK:
+		return enum.TimeInForce_FILL_OR_KILL
+	case TIF_GTD:
+		return enum.TimeInForce_GOOD_TILL_DATE
+	}
+	return enum.TimeInForce_DAY
+}
+
+func (tif TimeInForce) String() string {
+	switch tif {
+	case TIF_GTC:
+		return "GTC"
+	case TIF_IOC:
+		return "IOC"
+	case TIF_FOK:
+		return "FOK"
+	case TIF_GTD:
+		return "GTD"
+	}
+	return "Day"
+}
+
+// rests reports whether whatever is left of an order after matching may
+// wait in the book. IOC and FOK orders are cancelled instead.
+func (tif TimeInForce) rests() bool {
+	return tif != TIF_IOC && tif != TIF_FOK
+}
+
+// dayEnd is when a Day order entered at now expires: the next market close,
+// given as a time of day in UTC.
+func dayEnd(now time.Time, close time.Duration) time.Time {
+	end := now.UTC().Truncate(24 * time.Hour).Add(close)
+	if !end.After(now) {
+		end = end.Add(24 * time.Hour)
+	}
+	return end
+}
+
+// expired reports whether so should have stopped working by now.
+func (so *SingleOrder) expired(now time.Time) bool {
+	return !so.expireTime.IsZero() && !now.Before(so.expireTime)
+}
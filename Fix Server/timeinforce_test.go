@@ -0,0 +1,154 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOrderBookTimeInForce(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		resting   []*SingleOrder
+		tif       TimeInForce
+		volume    int64
+		fills     []testFill
+		remaining int64
+		canceled  bool
+		bids      []string
+		asks      []string
+	}{
+		{
+			name:      "GTC rests what is left",
+			resting:   []*SingleOrder{testOrder("s1", SELL, 100, 4)},
+			tif:       TIF_GTC,
+			volume:    10,
+			fills:     []testFill{{"s1", 100, 4}},
+			remaining: 6,
+			bids:      []string{"b1"},
+		},
+		{
+			name:      "IOC cancels what is left",
+			resting:   []*SingleOrder{testOrder("s1", SELL, 100, 4)},
+			tif:       TIF_IOC,
+			volume:    10,
+			fills:     []testFill{{"s1", 100, 4}},
+			remaining: 6,
+			canceled:  true,
+		},
+		{
+			name:      "IOC that doesn't cross is cancelled",
+			resting:   []*SingleOrder{testOrder("s1", SELL, 101, 4)},
+			tif:       TIF_IOC,
+			volume:    10,
+			remaining: 10,
+			canceled:  true,
+			asks:      []string{"s1"},
+		},
+		{
+			name:      "FOK fills across levels",
+			resting:   []*SingleOrder{testOrder("s1", SELL, 99, 4), testOrder("s2", SELL, 100, 10)},
+			tif:       TIF_FOK,
+			volume:    10,
+			fills:     []testFill{{"s1", 99, 4}, {"s2", 100, 6}},
+			remaining: 0,
+			asks:      []string{"s2"},
+		},
+		{
+			name:      "FOK that can't fill doesn't trade",
+			resting:   []*SingleOrder{testOrder("s1", SELL, 100, 4), testOrder("s2", SELL, 101, 10)},
+			tif:       TIF_FOK,
+			volume:    10,
+			remaining: 10,
+			canceled:  true,
+			asks:      []string{"s1", "s2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := newOrderBook("AAPL")
+			for _, so := range tt.resting {
+				book.add(so)
+			}
+
+			incoming := testOrder("b1", BUY, 100, tt.volume)
+			incoming.state = ORDER_PENDING_NEW
+			incoming.timeInForce = tt.tif
+
+			reply := make(chan marketResult, 1)
+			(&market{}).newOrder(book, &newOrderRequest{order: incoming, reply: reply})
+			res := <-reply
+
+			if got := fillsOf(res.trades); !reflect.DeepEqual(got, tt.fills) {
+				t.Errorf("fills = %v, want %v", got, tt.fills)
+			}
+			if got := incoming.volume.Sub(incoming.filled).IntPart(); got != tt.remaining {
+				t.Errorf("unfilled = %d, want %d", got, tt.remaining)
+			}
+			if (res.canceled != nil) != tt.canceled {
+				t.Errorf("canceled = %v, want %v", res.canceled != nil, tt.canceled)
+			}
+			if got := queueOf(book.bids); !reflect.DeepEqual(got, tt.bids) {
+				t.Errorf("bids = %v, want %v", got, tt.bids)
+			}
+			if got := queueOf(book.asks); !reflect.DeepEqual(got, tt.asks) {
+				t.Errorf("asks = %v, want %v", got, tt.asks)
+			}
+		})
+	}
+}
+
+func TestDayEnd(t *testing.T) {
+
+	closeTime := 17 * time.Hour
+
+	tests := []struct {
+		name string
+		now  string
+		want string
+	}{
+		{"before the close", "2024-03-01T10:00:00Z", "2024-03-01T17:00:00Z"},
+		{"at the close", "2024-03-01T17:00:00Z", "2024-03-02T17:00:00Z"},
+		{"after the close", "2024-03-01T18:30:00Z", "2024-03-02T17:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now, _ := time.Parse(time.RFC3339, tt.now)
+			want, _ := time.Parse(time.RFC3339, tt.want)
+
+			if got := dayEnd(now, closeTime); !got.Equal(want) {
+				t.Errorf("dayEnd(%s) = %s, want %s", tt.now, got, want)
+			}
+		})
+	}
+}
+
+func TestOrderBookExpire(t *testing.T) {
+
+	now := time.Date(2024, 3, 1, 17, 0, 0, 0, time.UTC)
+
+	book := newOrderBook("AAPL")
+	for _, so := range []*SingleOrder{testOrder("s1", SELL, 100, 10), testOrder("s2", SELL, 101, 10), testOrder("b1", BUY, 99, 10), testOrder("b2", BUY, 98, 10)} {
+		so.timeInForce = TIF_GTD
+		so.expireTime = now.Add(time.Minute)
+		book.add(so)
+	}
+
+	// a Day order at the close, a GTD order past its time and a GTC order
+	book.asks[0].expireTime = now
+	book.bids[1].expireTime = now.Add(-time.Hour)
+	book.bids[0].expireTime = time.Time{}
+
+	if got, want := queueOf(book.expire(now)), []string{"b2", "s1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expired = %v, want %v", got, want)
+	}
+	if got, want := queueOf(book.bids), []string{"b1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("bids = %v, want %v", got, want)
+	}
+	if got, want := queueOf(book.asks), []string{"s2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("asks = %v, want %v", got, want)
+	}
+}
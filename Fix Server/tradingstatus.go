@@ -0,0 +1,162 @@
+package main
+
+import (
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+
+	fix42tss "github.com/quickfixgo/fix42/tradingsessionstatus"
+	fix42tssr "github.com/quickfixgo/fix42/tradingsessionstatusrequest"
+	fix43tss "github.com/quickfixgo/fix43/tradingsessionstatus"
+	fix43tssr "github.com/quickfixgo/fix43/tradingsessionstatusrequest"
+	fix44tss "github.com/quickfixgo/fix44/tradingsessionstatus"
+	fix44tssr "github.com/quickfixgo/fix44/tradingsessionstatusrequest"
+	fix50tss "github.com/quickfixgo/fix50/tradingsessionstatus"
+	fix50tssr "github.com/quickfixgo/fix50/tradingsessionstatusrequest"
+)
+
+// The exchange runs a single trading session, the day session. Its status
+// is sent unsolicited to every session as it logs on and whenever the phase
+// changes, and on request. TradingSessionStatus only exists from FIX 4.2.
+
+type tradingSessionStatusRequest interface {
+	GetTradSesReqID() (string, quickfix.MessageRejectError)
+	GetTradingSessionID() (enum.TradingSessionID, quickfix.MessageRejectError)
+	GetSubscriptionRequestType() (enum.SubscriptionRequestType, quickfix.MessageRejectError)
+}
+
+func (e *Server) onFIX42TradingSessionStatusRequest(msg fix42tssr.TradingSessionStatusRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onTradingSessionStatusRequest(msg, sessionID)
+}
+
+func (e *Server) onFIX43TradingSessionStatusRequest(msg fix43tssr.TradingSessionStatusRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onTradingSessionStatusRequest(msg, sessionID)
+}
+
+func (e *Server) onFIX44TradingSessionStatusRequest(msg fix44tssr.TradingSessionStatusRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onTradingSessionStatusRequest(msg, sessionID)
+}
+
+func (e *Server) onFIX50TradingSessionStatusRequest(msg fix50tssr.TradingSessionStatusRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	return e.onTradingSessionStatusRequest(msg, sessionID)
+}
+
+func (e *Server) onTradingSessionStatusRequest(msg tradingSessionStatusRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+
+	reqID, err := msg.GetTradSesReqID()
+	if err != nil {
+		return err
+	}
+
+	subscription, err := msg.GetSubscriptionRequestType()
+	if err != nil {
+		return err
+	}
+
+	phase := e.session.current()
+	tradingSessionID, _ := msg.GetTradingSessionID()
+
+	var status *quickfix.Message
+
+	switch {
+	case tradingSessionID != "" && tradingSessionID != enum.TradingSessionID_DAY:
+		status = e.tradingSessionStatus(phase, sessionID)
+		status.Body.Set(field.NewTradingSessionID(tradingSessionID))
+		rejectTradingSessionStatus(status, enum.TradSesStatusRejReason_UNKNOWN_OR_INVALID_TRADINGSESSIONID, "Unknown Trading Session", sessionID)
+
+	case subscription == enum.SubscriptionRequestType_DISABLE_PREVIOUS_SNAPSHOT_PLUS_UPDATE_REQUEST:
+		status = e.tradingSessionStatus(phase, sessionID)
+		rejectTradingSessionStatus(status, enum.TradSesStatusRejReason_OTHER, "Phase Changes Are Sent To Every Session", sessionID)
+
+	default:
+		status = e.tradingSessionStatus(phase, sessionID)
+		status.Body.Set(field.NewUnsolicitedIndicator(false))
+	}
+
+	status.Body.Set(field.NewTradSesReqID(reqID))
+
+	e.send(status, sessionID)
+	return nil
+}
+
+// rejectTradingSessionStatus turns status into an answer rejecting the
+// request. FIX 4.2 has no reject reason, only the Text.
+func rejectTradingSessionStatus(status *quickfix.Message, reason enum.TradSesStatusRejReason, text string, sessionID quickfix.SessionID) {
+	status.Body.Set(field.NewTradSesStatus(enum.TradSesStatus_REQUEST_REJECTED))
+	status.Body.Remove(tag.TradingSessionSubID)
+
+	if sessionID.BeginString != quickfix.BeginStringFIX42 {
+		status.Body.Set(field.NewTradSesStatusRejReason(reason))
+	}
+	status.Body.Set(field.NewText(text))
+}
+
+// tradingSessionStatus builds a TradingSessionStatus for phase in the FIX
+// version spoken by sessionID.
+func (e *Server) tradingSessionStatus(phase TradingPhase, sessionID quickfix.SessionID) *quickfix.Message {
+
+	status, subID := phase.toFix()
+
+	tradingSessionID := field.NewTradingSessionID(enum.TradingSessionID_DAY)
+	tradSesStatus := field.NewTradSesStatus(status)
+
+	var msg *quickfix.Message
+
+	switch sessionID.BeginString {
+	case quickfix.BeginStringFIX42:
+		msg = fix42tss.New(tradingSessionID, tradSesStatus).ToMessage()
+	case quickfix.BeginStringFIX43:
+		msg = fix43tss.New(tradingSessionID, tradSesStatus).ToMessage()
+	case quickfix.BeginStringFIX44:
+		msg = fix44tss.New(tradingSessionID, tradSesStatus).ToMessage()
+	default:
+		msg = fix50tss.New(tradingSessionID, tradSesStatus).ToMessage()
+	}
+
+	if subID != "" && sessionID.BeginString != quickfix.BeginStringFIX42 {
+		msg.Body.Set(field.NewTradingSessionSubID(subID))
+	}
+	msg.Body.Set(field.NewText("Market Phase: " + phase.String()))
+
+	return msg
+}
+
+// hasTradingSessionStatus reports whether sessionID's FIX version has a
+// TradingSessionStatus message.
+func hasTradingSessionStatus(sessionID quickfix.SessionID) bool {
+	return sessionID.BeginString != quickfix.BeginStringFIX40 && sessionID.BeginString != quickfix.BeginStringFIX41
+}
+
+// sendPhase tells sessionID, unprompted, which phase the market is in.
+func (e *Server) sendPhase(phase TradingPhase, sessionID quickfix.SessionID) {
+
+	if !hasTradingSessionStatus(sessionID) {
+		return
+	}
+
+	status := e.tradingSessionStatus(phase, sessionID)
+	status.Body.Set(field.NewUnsolicitedIndicator(true))
+
+	e.send(status, sessionID)
+}
+
+// broadcastPhase tells every logged on session the market has moved into
+// phase.
+func (e *Server) broadcastPhase(phase TradingPhase) {
+	for _, sessionID := range e.loggedOnSessions() {
+		e.sendPhase(phase, sessionID)
+	}
+}
+
+// loggedOnSessions lists the sessions logged on right now.
+func (e *Server) loggedOnSessions() []quickfix.SessionID {
+	e.loggedOnMu.Lock()
+	defer e.loggedOnMu.Unlock()
+
+	sessions := make([]quickfix.SessionID, 0, len(e.loggedOn))
+	for sessionID := range e.loggedOn {
+		sessions = append(sessions, sessionID)
+	}
+	return sessions
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+// triggerBook holds a symbol's stop and stop-limit orders until the last
+// trade price reaches their StopPx. Buy stops are kept lowest stop first
+// and sell stops highest stop first, so the next to trigger is always at
+// the front, with orders at the same stop kept in the order they arrived.
+type triggerBook struct {
+	buys  []*SingleOrder
+	sells []*SingleOrder
+}
+
+func (t *triggerBook) add(so *SingleOrder) {
+	if so.side == BUY {
+		i := sort.Search(len(t.buys), func(i int) bool { return t.buys[i].stopPx.GreaterThan(so.stopPx) })
+		t.buys = insertAt(t.buys, i, so)
+	} else {
+		i := sort.Search(len(t.sells), func(i int) bool { return t.sells[i].stopPx.LessThan(so.stopPx) })
+		t.sells = insertAt(t.sells, i, so)
+	}
+}
+
+// remove takes a waiting stop order out of the book. It returns false if
+// the order is not waiting to trigger.
+func (t *triggerBook) remove(so *SingleOrder) bool {
+	side := &t.sells
+	if so.side == BUY {
+		side = &t.buys
+	}
+
+	for i, order := range *side {
+		if order == so {
+			*side = append((*side)[:i], (*side)[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// release takes out every order whose stop lastPx has reached: buy stops
+// at or below it and sell stops at or above it. They are returned in the
+// order they were entered.
+func (t *triggerBook) release(lastPx decimal.Decimal) []*SingleOrder {
+	var released []*SingleOrder
+
+	for len(t.buys) > 0 && t.buys[0].stopPx.LessThanOrEqual(lastPx) {
+		released = append(released, t.buys[0])
+		t.buys = t.buys[1:]
+	}
+
+	for len(t.sells) > 0 && t.sells[0].stopPx.GreaterThanOrEqual(lastPx) {
+		released = append(released, t.sells[0])
+		t.sells = t.sells[1:]
+	}
+
+	sort.Slice(released, func(a, b int) bool { return released[a].seq < released[b].seq })
+
+	return released
+}
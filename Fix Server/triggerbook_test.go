@@ -0,0 +1,172 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func stopOrder(id string, side Side, orderType OrderType, stopPx, price, volume int64) *SingleOrder {
+	so := testOrder(id, side, price, volume)
+	so.orderType = orderType
+	so.stopPx = decimal.NewFromInt(stopPx)
+	return so
+}
+
+func TestTriggerBookRelease(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		lastPx int64
+		want   []string
+	}{
+		{"nothing reached", 100, nil},
+		{"buy stops at or below the last trade", 102, []string{"b1", "b2"}},
+		{"sell stops at or above the last trade", 97, []string{"s1", "s2"}},
+		{"released in the order they were entered", 105, []string{"b1", "b2", "b3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := newOrderBook("AAPL")
+			for _, so := range []*SingleOrder{
+				stopOrder("b1", BUY, STOP, 102, 0, 5),
+				stopOrder("s1", SELL, STOP, 97, 0, 5),
+				stopOrder("b2", BUY, STOP_LIMIT, 101, 103, 5),
+				stopOrder("b3", BUY, STOP, 105, 0, 5),
+				stopOrder("s2", SELL, STOP_LIMIT, 98, 96, 5),
+			} {
+				if trades := book.add(so); len(trades) > 0 {
+					t.Fatalf("stop order %s traded before it triggered", so.id)
+				}
+			}
+
+			if len(book.bids) > 0 || len(book.asks) > 0 {
+				t.Fatal("stop orders rested before they triggered")
+			}
+
+			book.lastPx = decimal.NewFromInt(tt.lastPx)
+			released := book.triggered()
+
+			if got := queueOf(released); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("released = %v, want %v", got, tt.want)
+			}
+			for _, so := range released {
+				if !so.triggered {
+					t.Errorf("%s released without being triggered", so.id)
+				}
+			}
+		})
+	}
+}
+
+// A trade that reaches a stop sets it off, and it then trades as a market
+// or limit order.
+func TestOrderBookStopTriggers(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		stop      *SingleOrder
+		fills     []testFill
+		remaining int64
+		bids      []string
+	}{
+		{"stop trades at any price", stopOrder("b2", BUY, STOP, 100, 0, 8), []testFill{{"s2", 101, 5}, {"s3", 103, 3}}, 0, nil},
+		{"stop-limit trades up to its price", stopOrder("b2", BUY, STOP_LIMIT, 100, 101, 8), []testFill{{"s2", 101, 5}}, 3, []string{"b2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := newOrderBook("AAPL")
+			book.add(testOrder("s1", SELL, 100, 2))
+			book.add(testOrder("s2", SELL, 101, 5))
+			book.add(testOrder("s3", SELL, 103, 10))
+			book.add(tt.stop)
+
+			book.add(testOrder("b1", BUY, 100, 2))
+
+			var trades []Trade
+			for _, so := range book.triggered() {
+				trades = append(trades, book.enter(so)...)
+			}
+
+			if got := fillsOf(trades); !reflect.DeepEqual(got, tt.fills) {
+				t.Errorf("fills = %v, want %v", got, tt.fills)
+			}
+			if got := tt.stop.remaining().IntPart(); got != tt.remaining {
+				t.Errorf("remaining = %d, want %d", got, tt.remaining)
+			}
+			if got := queueOf(book.bids); !reflect.DeepEqual(got, tt.bids) {
+				t.Errorf("bids = %v, want %v", got, tt.bids)
+			}
+		})
+	}
+}
+
+// Amending a stop that hasn't triggered moves it to its new StopPx, and a
+// stop, which trades at any price, is never given a limit.
+func TestOrderBookReplaceStop(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		stop   *SingleOrder
+		price  int64
+		stopPx int64
+		lastPx int64
+		want   []string
+	}{
+		{"stop moved past the other", stopOrder("b1", BUY, STOP, 101, 0, 5), 0, 103, 102, []string{"b2"}},
+		{"stop moved ahead of the other", stopOrder("b1", BUY, STOP, 104, 0, 5), 0, 101, 101, []string{"b1r"}},
+		{"stop-limit moved with its price", stopOrder("b1", BUY, STOP_LIMIT, 101, 102, 5), 104, 103, 102, []string{"b2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := newOrderBook("AAPL")
+			book.add(tt.stop)
+			book.add(stopOrder("b2", BUY, STOP, 102, 0, 5))
+
+			if book.replace(tt.stop, "b1r", decimal.NewFromInt(tt.price), decimal.NewFromInt(tt.stopPx), decimal.NewFromInt(5)) {
+				t.Fatal("stop that hasn't triggered asked to be re-entered")
+			}
+
+			if !tt.stop.stopPx.Equal(decimal.NewFromInt(tt.stopPx)) || !tt.stop.price.Equal(decimal.NewFromInt(tt.price)) {
+				t.Errorf("amended to StopPx %s Price %s, want %d and %d", tt.stop.stopPx, tt.stop.price, tt.stopPx, tt.price)
+			}
+
+			book.lastPx = decimal.NewFromInt(tt.lastPx)
+			if got := queueOf(book.triggered()); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("released = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// A replace has to keep the order's type, the price and stop it carries
+// are only good for that type.
+func TestMarketReplaceKeepsOrderType(t *testing.T) {
+
+	m := &market{}
+	book := newOrderBook("AAPL")
+
+	stop := stopOrder("b1", BUY, STOP, 101, 0, 5)
+	stop.state = ORDER_PENDING_NEW
+	stop.timeInForce = TIF_GTC
+	m.newOrder(book, &newOrderRequest{order: stop, reply: make(chan marketResult, 1)})
+
+	req := &replaceRequest{
+		cancelRequest: cancelRequest{user: "alice", symbol: "AAPL", side: BUY, id: "b1r", origID: "b1", reply: make(chan marketResult, 1)},
+		orderType:     LIMIT,
+		price:         decimal.NewFromInt(101),
+		volume:        decimal.NewFromInt(5),
+	}
+	m.replace(book, req)
+
+	if res := <-req.reply; res.status != REPLACE_FAILED_ORDER_TYPE {
+		t.Fatalf("status = %d, want REPLACE_FAILED_ORDER_TYPE", res.status)
+	}
+	if so, ok := book.find("alice", "b1"); !ok || !so.price.IsZero() || so.orderType != STOP {
+		t.Error("stop order amended by a replace of another type")
+	}
+}